@@ -2,8 +2,10 @@ package account
 
 import (
 	"context"
+	"time"
 
 	"github.com/haroonalbar/go-grpc-graphql-microservices/account/pb"
+	"github.com/haroonalbar/go-grpc-graphql-microservices/auth"
 	"google.golang.org/grpc"
 )
 
@@ -15,10 +17,19 @@ type Client struct {
 	service pb.AccountServiceClient
 }
 
-// returns a Client with grpc connection and account service client
-func NewClient(url string) (*Client, error) {
+// returns a Client with grpc connection and account service client.
+// opts are added to the dial options, like a dialer for in-process connections.
+func NewClient(url string, opts ...grpc.DialOption) (*Client, error) {
 	// making a grpc connection
-	conn, err := grpc.Dial(url, grpc.WithInsecure())
+	conn, err := grpc.Dial(
+		url,
+		append([]grpc.DialOption{
+			grpc.WithInsecure(),
+			// forwards the caller identity from the context, see auth.WithToken and auth.ServiceCredentials
+			grpc.WithUnaryInterceptor(auth.UnaryClientInterceptor),
+			grpc.WithStreamInterceptor(auth.StreamClientInterceptor),
+		}, opts...)...,
+	)
 	// conn, err := grpc.NewClient(url, grpc.WithTransportCredentials(insecure.NewCredentials()))
 	if err != nil {
 		return nil, err
@@ -44,10 +55,7 @@ func (c *Client) PostAccount(ctx context.Context, name string) (*Account, error)
 	if err != nil {
 		return nil, err
 	}
-	return &Account{
-		ID:   r.Account.Id,
-		Name: r.Account.Name,
-	}, nil
+	return accountFromProto(r.Account), nil
 }
 
 func (c *Client) GetAccount(ctx context.Context, id string) (*Account, error) {
@@ -60,18 +68,18 @@ func (c *Client) GetAccount(ctx context.Context, id string) (*Account, error) {
 	if err != nil {
 		return nil, err
 	}
-	return &Account{
-		ID:   r.Account.Id,
-		Name: r.Account.Name,
-	}, nil
+	return accountFromProto(r.Account), nil
 }
 
-func (c *Client) GetAccounts(ctx context.Context, skip uint64, take uint64) ([]Account, error) {
+// GetAccounts lists accounts newest first.
+// after is the ID of the last account of the previous page, empty for the first page.
+func (c *Client) GetAccounts(ctx context.Context, skip uint64, take uint64, after string) ([]Account, error) {
 	r, err := c.service.GetAccounts(
 		ctx,
 		&pb.GetAccountsRequest{
-			Skip: skip,
-			Take: take,
+			Skip:  skip,
+			Take:  take,
+			After: after,
 		},
 	)
 	if err != nil {
@@ -79,10 +87,90 @@ func (c *Client) GetAccounts(ctx context.Context, skip uint64, take uint64) ([]A
 	}
 	var accounts []Account
 	for _, acc := range r.Accounts {
-		accounts = append(accounts, Account{
-			ID:   acc.Id,
-			Name: acc.Name,
-		})
+		accounts = append(accounts, *accountFromProto(acc))
 	}
 	return accounts, nil
 }
+
+func (c *Client) UpdateAccount(ctx context.Context, id string, name string) (*Account, error) {
+	r, err := c.service.UpdateAccount(
+		ctx,
+		&pb.UpdateAccountRequest{
+			Id:   id,
+			Name: name,
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+	return accountFromProto(r.Account), nil
+}
+
+func (c *Client) DeleteAccount(ctx context.Context, id string) error {
+	_, err := c.service.DeleteAccount(
+		ctx,
+		&pb.DeleteAccountRequest{
+			Id: id,
+		},
+	)
+	return err
+}
+
+func (c *Client) Register(ctx context.Context, name, email, password string) (*Account, *Tokens, error) {
+	r, err := c.service.Register(
+		ctx,
+		&pb.RegisterRequest{
+			Name:     name,
+			Email:    email,
+			Password: password,
+		},
+	)
+	if err != nil {
+		return nil, nil, err
+	}
+	return accountFromProto(r.Account), tokensFromProto(r.Tokens), nil
+}
+
+func (c *Client) Login(ctx context.Context, email, password string) (*Account, *Tokens, error) {
+	r, err := c.service.Login(
+		ctx,
+		&pb.LoginRequest{
+			Email:    email,
+			Password: password,
+		},
+	)
+	if err != nil {
+		return nil, nil, err
+	}
+	return accountFromProto(r.Account), tokensFromProto(r.Tokens), nil
+}
+
+func (c *Client) RefreshToken(ctx context.Context, refreshToken string) (*Tokens, error) {
+	r, err := c.service.RefreshToken(
+		ctx,
+		&pb.RefreshTokenRequest{
+			RefreshToken: refreshToken,
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+	return tokensFromProto(r.Tokens), nil
+}
+
+func accountFromProto(a *pb.Account) *Account {
+	return &Account{
+		ID:    a.Id,
+		Name:  a.Name,
+		Email: a.Email,
+		Roles: a.Roles,
+	}
+}
+
+func tokensFromProto(t *pb.Tokens) *Tokens {
+	return &Tokens{
+		AccessToken:          t.AccessToken,
+		RefreshToken:         t.RefreshToken,
+		AccessTokenExpiresAt: time.Unix(t.AccessTokenExpiresAt, 0),
+	}
+}
@@ -11,6 +11,10 @@ import (
 
 type Config struct {
 	DatabaseURL string `envconfig:"DATABASE_URL"`
+	// PEM encoded RSA private key used to sign access and refresh tokens
+	JWTPrivateKeyFile string `envconfig:"JWT_PRIVATE_KEY_FILE" default:"/keys/jwt.pem"`
+	// Secret shared by the services to call each other
+	ServiceToken string `envconfig:"SERVICE_TOKEN"`
 }
 
 // NOTE: So in main we are populating cfg of type Config using envconfig
@@ -24,6 +28,11 @@ func main() {
 		log.Fatalf("Error processing env: %v", err)
 	}
 
+	tokens, err := account.NewTokenIssuer(cfg.JWTPrivateKeyFile)
+	if err != nil {
+		log.Fatalf("Error loading jwt signing key: %v", err)
+	}
+
 	var r account.Repository
 	// FIX: Later depricated package
 	//  some randome package called retry with 8 stars says it's archived so must be depricated
@@ -41,7 +50,7 @@ func main() {
 
 	log.Println("Listening on port 8080")
 	// get service
-	s := account.NewService(r)
+	s := account.NewService(r, tokens)
 	// start grpc server on 8080
-	log.Fatal(account.ListenGRPC(s, 8080))
+	log.Fatal(account.ListenGRPC(s, tokens.TokenVerifier, cfg.ServiceToken, 8080))
 }
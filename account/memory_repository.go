@@ -0,0 +1,141 @@
+package account
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// memoryRepository keeps accounts in memory, for tests and local demos.
+// It behaves like postgresRepository: accounts are listed by ID descending,
+// deleted accounts are hidden but keep their email taken.
+// Everything is lost when the process exits.
+type memoryRepository struct {
+	mu       sync.RWMutex
+	accounts map[string]*memoryAccount
+}
+
+type memoryAccount struct {
+	Account
+	deleted bool
+}
+
+// NewMemoryRepository returns an empty in-memory repository, it's safe for concurrent use
+func NewMemoryRepository() Repository {
+	return &memoryRepository{accounts: map[string]*memoryAccount{}}
+}
+
+func (r *memoryRepository) Close() {}
+
+// PutAccount inserts a new account.
+// ErrEmailTaken is returned if another account, even a deleted one, already uses the email.
+func (r *memoryRepository) PutAccount(ctx context.Context, a Account) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.accounts[a.ID]; ok {
+		// postgres fails on the primary key too
+		return fmt.Errorf("account %s already exists", a.ID)
+	}
+	if a.Email != "" {
+		for _, existing := range r.accounts {
+			if existing.Email == a.Email {
+				return ErrEmailTaken
+			}
+		}
+	}
+	r.accounts[a.ID] = &memoryAccount{Account: copyAccount(a)}
+	return nil
+}
+
+func (r *memoryRepository) GetAccountByID(ctx context.Context, id string) (*Account, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	a, ok := r.accounts[id]
+	if !ok || a.deleted {
+		return nil, ErrNotFound
+	}
+	return withoutPassword(a.Account), nil
+}
+
+// GetAccountByEmail returns the account with the given email, including its password hash
+func (r *memoryRepository) GetAccountByEmail(ctx context.Context, email string) (*Account, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if email == "" {
+		return nil, ErrNotFound
+	}
+	for _, a := range r.accounts {
+		if a.Email == email && !a.deleted {
+			account := copyAccount(a.Account)
+			return &account, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+// ListAccounts returns the accounts by ID descending, after skip and the ID in after,
+// like postgresRepository.ListAccounts
+func (r *memoryRepository) ListAccounts(ctx context.Context, skip uint64, take uint64, after string) ([]Account, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	ids := make([]string, 0, len(r.accounts))
+	for id, a := range r.accounts {
+		if !a.deleted && (after == "" || id < after) {
+			ids = append(ids, id)
+		}
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(ids)))
+
+	accounts := []Account{}
+	for i := skip; i < uint64(len(ids)) && uint64(len(accounts)) < take; i++ {
+		accounts = append(accounts, *withoutPassword(r.accounts[ids[i]].Account))
+	}
+	return accounts, nil
+}
+
+// UpdateAccount updates the name of an existing account.
+// ErrNotFound is returned if the account doesn't exist or was deleted.
+func (r *memoryRepository) UpdateAccount(ctx context.Context, a Account) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	existing, ok := r.accounts[a.ID]
+	if !ok || existing.deleted {
+		return ErrNotFound
+	}
+	existing.Name = a.Name
+	return nil
+}
+
+// DeleteAccount soft deletes an account, its email stays taken like in postgres.
+// ErrNotFound is returned if the account doesn't exist or was already deleted.
+func (r *memoryRepository) DeleteAccount(ctx context.Context, id string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	a, ok := r.accounts[id]
+	if !ok || a.deleted {
+		return ErrNotFound
+	}
+	a.deleted = true
+	return nil
+}
+
+// copyAccount copies the roles, so callers can't change a stored account through the slice
+func copyAccount(a Account) Account {
+	a.Roles = append([]string{}, a.Roles...)
+	return a
+}
+
+// withoutPassword returns a copy of the account without the password hash,
+// which is only returned by GetAccountByEmail
+func withoutPassword(a Account) *Account {
+	a = copyAccount(a)
+	a.PasswordHash = ""
+	return &a
+}
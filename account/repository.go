@@ -3,8 +3,14 @@ package account
 import (
 	"context"
 	"database/sql"
+	"errors"
 
-	_ "github.com/lib/pq" // postgres driver
+	"github.com/lib/pq" // postgres driver
+)
+
+var (
+	ErrNotFound   = errors.New("account not found")
+	ErrEmailTaken = errors.New("email is already registered")
 )
 
 // The Repository interface and its implementation (postgresRepository) serve several important purposes:
@@ -34,7 +40,10 @@ type Repository interface {
 	Close()
 	PutAccount(ctx context.Context, a Account) error
 	GetAccountByID(ctx context.Context, id string) (*Account, error)
-	ListAccounts(ctx context.Context, skip uint64, take uint64) ([]Account, error)
+	GetAccountByEmail(ctx context.Context, email string) (*Account, error)
+	ListAccounts(ctx context.Context, skip uint64, take uint64, after string) ([]Account, error)
+	UpdateAccount(ctx context.Context, a Account) error
+	DeleteAccount(ctx context.Context, id string) error
 }
 
 type postgresRepository struct {
@@ -49,15 +58,54 @@ func (r *postgresRepository) Close() {
 	r.db.Close()
 }
 
+// PutAccount inserts a new account.
+// Email and password hash are optional and stored as NULL when empty.
+// ErrEmailTaken is returned if another account already uses the email.
 func (r *postgresRepository) PutAccount(ctx context.Context, a Account) error {
-	_, err := r.db.ExecContext(ctx, "INSERT INTO accounts(id, name) VALUES($1, $2)", a.ID, a.Name)
+	_, err := r.db.ExecContext(
+		ctx,
+		"INSERT INTO accounts(id, name, email, password_hash, roles) VALUES($1, $2, NULLIF($3, ''), NULLIF($4, ''), $5)",
+		a.ID,
+		a.Name,
+		a.Email,
+		a.PasswordHash,
+		pq.Array(a.Roles),
+	)
+	if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" && pqErr.Constraint == "accounts_email_key" {
+		return ErrEmailTaken
+	}
 	return err
 }
 
 func (r *postgresRepository) GetAccountByID(ctx context.Context, id string) (*Account, error) {
-	row := r.db.QueryRowContext(ctx, "SELECT id, name FROM accounts WHERE id = $1", id)
+	row := r.db.QueryRowContext(
+		ctx,
+		"SELECT id, name, COALESCE(email, ''), roles FROM accounts WHERE id = $1 AND deleted_at IS NULL",
+		id,
+	)
+	a := &Account{}
+	if err := row.Scan(&a.ID, &a.Name, &a.Email, pq.Array(&a.Roles)); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return a, nil
+}
+
+// GetAccountByEmail returns the account with the given email, including its password hash.
+// It's used to check credentials on login.
+func (r *postgresRepository) GetAccountByEmail(ctx context.Context, email string) (*Account, error) {
+	row := r.db.QueryRowContext(
+		ctx,
+		"SELECT id, name, email, COALESCE(password_hash, ''), roles FROM accounts WHERE email = $1 AND deleted_at IS NULL",
+		email,
+	)
 	a := &Account{}
-	if err := row.Scan(&a.ID, &a.Name); err != nil {
+	if err := row.Scan(&a.ID, &a.Name, &a.Email, &a.PasswordHash, pq.Array(&a.Roles)); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrNotFound
+		}
 		return nil, err
 	}
 	return a, nil
@@ -65,13 +113,18 @@ func (r *postgresRepository) GetAccountByID(ctx context.Context, id string) (*Ac
 
 // ListAccounts returns a slice of Account objects, sorted by ID descending,
 // with 'skip' number of elements skipped and 'take' number of elements returned.
+// If 'after' is set only accounts with an ID below it are returned. That's keyset pagination,
+// it uses the primary key index, so deep pages stay as fast as the first one and don't shift
+// when accounts are inserted. IDs are KSUIDs, so descending IDs are newest first.
+// Deleted accounts are left out.
 // The method returns an error if the query fails.
-func (r *postgresRepository) ListAccounts(ctx context.Context, skip uint64, take uint64) ([]Account, error) {
+func (r *postgresRepository) ListAccounts(ctx context.Context, skip uint64, take uint64, after string) ([]Account, error) {
 	rows, err := r.db.QueryContext(
 		ctx,
-		"SELECT id, name FROM accounts ORDER BY id DESC OFFSET $1 LIMIT $2",
+		"SELECT id, name, COALESCE(email, ''), roles FROM accounts WHERE deleted_at IS NULL AND ($3 = '' OR id < $3::CHAR(27)) ORDER BY id DESC OFFSET $1 LIMIT $2",
 		skip,
 		take,
+		after,
 	)
 	if err != nil {
 		return nil, err
@@ -81,7 +134,7 @@ func (r *postgresRepository) ListAccounts(ctx context.Context, skip uint64, take
 	accounts := []Account{}
 	for rows.Next() {
 		a := &Account{}
-		if err = rows.Scan(&a.ID, &a.Name); err == nil {
+		if err = rows.Scan(&a.ID, &a.Name, &a.Email, pq.Array(&a.Roles)); err == nil {
 			accounts = append(accounts, *a)
 		}
 	}
@@ -91,6 +144,39 @@ func (r *postgresRepository) ListAccounts(ctx context.Context, skip uint64, take
 	return accounts, nil
 }
 
+// UpdateAccount updates the name of an existing account.
+// ErrNotFound is returned if the account doesn't exist or was deleted.
+func (r *postgresRepository) UpdateAccount(ctx context.Context, a Account) error {
+	res, err := r.db.ExecContext(ctx, "UPDATE accounts SET name = $2 WHERE id = $1 AND deleted_at IS NULL", a.ID, a.Name)
+	if err != nil {
+		return err
+	}
+	return expectAffected(res)
+}
+
+// DeleteAccount soft deletes an account by setting deleted_at.
+// The row is kept so orders placed by the account still reference it.
+// ErrNotFound is returned if the account doesn't exist or was already deleted.
+func (r *postgresRepository) DeleteAccount(ctx context.Context, id string) error {
+	res, err := r.db.ExecContext(ctx, "UPDATE accounts SET deleted_at = now() WHERE id = $1 AND deleted_at IS NULL", id)
+	if err != nil {
+		return err
+	}
+	return expectAffected(res)
+}
+
+// expectAffected returns ErrNotFound if the statement didn't change any row
+func expectAffected(res sql.Result) error {
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
 func NewPostgresRepository(url string) (Repository, error) {
 	// connect to db
 	db, err := sql.Open("postgres", url)
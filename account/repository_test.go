@@ -0,0 +1,231 @@
+package account
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+
+	"github.com/segmentio/ksuid"
+)
+
+// The conformance suite runs against every Repository implementation.
+// PostgreSQL is only tested if TEST_DATABASE_URL is set, its accounts table is emptied by every test.
+func TestRepositories(t *testing.T) {
+	implementations := map[string]func(t *testing.T) Repository{
+		"memory": func(t *testing.T) Repository {
+			return NewMemoryRepository()
+		},
+		"postgres": newTestPostgresRepository,
+	}
+	for name, newRepository := range implementations {
+		t.Run(name, func(t *testing.T) {
+			testRepository(t, newRepository)
+		})
+	}
+}
+
+func newTestPostgresRepository(t *testing.T) Repository {
+	url := os.Getenv("TEST_DATABASE_URL")
+	if url == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+	r, err := NewPostgresRepository(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	schema, err := os.ReadFile("up.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := r.(*postgresRepository).db
+	if _, err := db.Exec(string(schema)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec("TRUNCATE accounts"); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(r.Close)
+	return r
+}
+
+func testRepository(t *testing.T, newRepository func(t *testing.T) Repository) {
+	ctx := context.Background()
+
+	t.Run("get", func(t *testing.T) {
+		r := newRepository(t)
+		a := Account{ID: ksuid.New().String(), Name: "Alice", Email: "alice@example.com", Roles: []string{RoleUser}, PasswordHash: "hash"}
+		if err := r.PutAccount(ctx, a); err != nil {
+			t.Fatal(err)
+		}
+
+		got, err := r.GetAccountByID(ctx, a.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := a
+		want.PasswordHash = ""
+		if !reflect.DeepEqual(*got, want) {
+			t.Errorf("GetAccountByID = %+v, want %+v", *got, want)
+		}
+
+		got, err = r.GetAccountByEmail(ctx, a.Email)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(*got, a) {
+			t.Errorf("GetAccountByEmail = %+v, want %+v", *got, a)
+		}
+
+		if _, err := r.GetAccountByID(ctx, ksuid.New().String()); err != ErrNotFound {
+			t.Errorf("GetAccountByID of unknown account: %v, want ErrNotFound", err)
+		}
+		if _, err := r.GetAccountByEmail(ctx, "bob@example.com"); err != ErrNotFound {
+			t.Errorf("GetAccountByEmail of unknown email: %v, want ErrNotFound", err)
+		}
+	})
+
+	t.Run("email is unique", func(t *testing.T) {
+		r := newRepository(t)
+		for _, a := range []Account{
+			{ID: ksuid.New().String(), Name: "Alice", Email: "alice@example.com", Roles: []string{RoleUser}},
+			// accounts without email don't collide
+			{ID: ksuid.New().String(), Name: "Guest", Roles: []string{RoleUser}},
+			{ID: ksuid.New().String(), Name: "Guest", Roles: []string{RoleUser}},
+		} {
+			if err := r.PutAccount(ctx, a); err != nil {
+				t.Fatal(err)
+			}
+		}
+		err := r.PutAccount(ctx, Account{ID: ksuid.New().String(), Name: "Alice", Email: "alice@example.com", Roles: []string{RoleUser}})
+		if err != ErrEmailTaken {
+			t.Errorf("PutAccount with a taken email: %v, want ErrEmailTaken", err)
+		}
+	})
+
+	t.Run("list", func(t *testing.T) {
+		r := newRepository(t)
+		var ids []string
+		for i := 0; i < 5; i++ {
+			a := Account{ID: ksuid.New().String(), Name: fmt.Sprint("account ", i), Roles: []string{RoleUser}}
+			if err := r.PutAccount(ctx, a); err != nil {
+				t.Fatal(err)
+			}
+			ids = append(ids, a.ID)
+		}
+		// newest first
+		sort.Sort(sort.Reverse(sort.StringSlice(ids)))
+
+		for _, tc := range []struct {
+			skip, take uint64
+			after      string
+			want       []string
+		}{
+			{0, 10, "", ids},
+			{1, 2, "", ids[1:3]},
+			{0, 2, ids[1], ids[2:4]},
+			{1, 10, ids[1], ids[3:]},
+			{0, 10, ids[4], nil},
+		} {
+			accounts, err := r.ListAccounts(ctx, tc.skip, tc.take, tc.after)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := accountIDs(accounts); !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("ListAccounts(%d, %d, %q) = %v, want %v", tc.skip, tc.take, tc.after, got, tc.want)
+			}
+		}
+	})
+
+	t.Run("update", func(t *testing.T) {
+		r := newRepository(t)
+		a := Account{ID: ksuid.New().String(), Name: "Alice", Roles: []string{RoleUser}}
+		if err := r.PutAccount(ctx, a); err != nil {
+			t.Fatal(err)
+		}
+		if err := r.UpdateAccount(ctx, Account{ID: a.ID, Name: "Alicia"}); err != nil {
+			t.Fatal(err)
+		}
+		got, err := r.GetAccountByID(ctx, a.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Name != "Alicia" || !reflect.DeepEqual(got.Roles, a.Roles) {
+			t.Errorf("updated account = %+v, want only the name changed", *got)
+		}
+
+		if err := r.UpdateAccount(ctx, Account{ID: ksuid.New().String(), Name: "Bob"}); err != ErrNotFound {
+			t.Errorf("UpdateAccount of unknown account: %v, want ErrNotFound", err)
+		}
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		r := newRepository(t)
+		a := Account{ID: ksuid.New().String(), Name: "Alice", Email: "alice@example.com", Roles: []string{RoleUser}}
+		if err := r.PutAccount(ctx, a); err != nil {
+			t.Fatal(err)
+		}
+		if err := r.DeleteAccount(ctx, a.ID); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := r.GetAccountByID(ctx, a.ID); err != ErrNotFound {
+			t.Errorf("GetAccountByID of deleted account: %v, want ErrNotFound", err)
+		}
+		if _, err := r.GetAccountByEmail(ctx, a.Email); err != ErrNotFound {
+			t.Errorf("GetAccountByEmail of deleted account: %v, want ErrNotFound", err)
+		}
+		if accounts, err := r.ListAccounts(ctx, 0, 10, ""); err != nil || len(accounts) != 0 {
+			t.Errorf("ListAccounts = %v, %v, want no accounts", accounts, err)
+		}
+		if err := r.UpdateAccount(ctx, Account{ID: a.ID, Name: "Alicia"}); err != ErrNotFound {
+			t.Errorf("UpdateAccount of deleted account: %v, want ErrNotFound", err)
+		}
+		if err := r.DeleteAccount(ctx, a.ID); err != ErrNotFound {
+			t.Errorf("DeleteAccount of deleted account: %v, want ErrNotFound", err)
+		}
+		// the row is kept, so the email stays taken
+		if err := r.PutAccount(ctx, Account{ID: ksuid.New().String(), Name: "Alice", Email: a.Email, Roles: []string{RoleUser}}); err != ErrEmailTaken {
+			t.Errorf("PutAccount with the email of a deleted account: %v, want ErrEmailTaken", err)
+		}
+	})
+
+	t.Run("concurrent", func(t *testing.T) {
+		r := newRepository(t)
+		var wg sync.WaitGroup
+		for i := 0; i < 20; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				a := Account{ID: ksuid.New().String(), Name: fmt.Sprint("account ", i), Email: fmt.Sprintf("user%d@example.com", i), Roles: []string{RoleUser}}
+				if err := r.PutAccount(ctx, a); err != nil {
+					t.Error(err)
+					return
+				}
+				if _, err := r.ListAccounts(ctx, 0, 100, ""); err != nil {
+					t.Error(err)
+				}
+			}(i)
+		}
+		wg.Wait()
+
+		accounts, err := r.ListAccounts(ctx, 0, 100, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(accounts) != 20 {
+			t.Errorf("ListAccounts returned %d accounts, want 20", len(accounts))
+		}
+	})
+}
+
+func accountIDs(accounts []Account) []string {
+	var ids []string
+	for _, a := range accounts {
+		ids = append(ids, a.ID)
+	}
+	return ids
+}
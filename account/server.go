@@ -7,6 +7,7 @@ import (
 	"net"
 
 	"github.com/haroonalbar/go-grpc-graphql-microservices/account/pb"
+	"github.com/haroonalbar/go-grpc-graphql-microservices/auth"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/reflection"
 )
@@ -20,22 +21,44 @@ type grpcServer struct {
 	service Service
 }
 
+// policy is what callers need for every method.
+// Owners of an account are checked in the handlers with auth.AuthorizeAccount.
+var policy = auth.Policy{
+	pb.AccountService_PostAccount_FullMethodName:  auth.RequireRole(auth.RoleAdmin),
+	pb.AccountService_GetAccounts_FullMethodName:  auth.RequireRole(auth.RoleAdmin),
+	pb.AccountService_Register_FullMethodName:     auth.Public,
+	pb.AccountService_Login_FullMethodName:        auth.Public,
+	pb.AccountService_RefreshToken_FullMethodName: auth.Public,
+}
+
 // This function sets up and starts the gRPC server
-func ListenGRPC(s Service, port int) error {
+// a checks the access tokens forwarded by the graphql gateway
+// serviceToken is shared with the other services so they can call this one
+func ListenGRPC(s Service, a auth.Authenticator, serviceToken string, port int) error {
 	// It creates a TCP listener on the specified port.
 	lis, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
 	if err != nil {
 		return err
 	}
+	// Starts serving gRPC requests.
+	return NewGRPCServer(s, a, serviceToken).Serve(lis)
+}
+
+// NewGRPCServer returns the gRPC server of the account service without listening,
+// so it can serve any listener, like an in-memory one.
+func NewGRPCServer(s Service, a auth.Authenticator, serviceToken string) *grpc.Server {
 	// Initializes a new gRPC server.
-	serv := grpc.NewServer()
+	// Every request goes through the auth interceptor before it reaches a handler
+	serv := grpc.NewServer(
+		grpc.UnaryInterceptor(auth.UnaryServerInterceptor(a, serviceToken, policy)),
+		grpc.StreamInterceptor(auth.StreamServerInterceptor(a, serviceToken, policy)),
+	)
 	// Registers the server for reflection (useful for debugging and service discovery).
 	reflection.Register(serv)
 	pb.RegisterAccountServiceServer(serv, &grpcServer{
 		service: s,
 	})
-	// Starts serving gRPC requests.
-	return serv.Serve(lis)
+	return serv
 }
 
 // pb is generated from protobuff file
@@ -46,39 +69,107 @@ func (s *grpcServer) PostAccount(ctx context.Context, r *pb.PostAccountRequest)
 		return nil, err
 	}
 	return &pb.PostAccountResponse{
-		Account: &pb.Account{
-			Id:   a.ID,
-			Name: a.Name,
-		},
+		Account: accountToProto(a),
 	}, nil
 }
 
 func (s *grpcServer) GetAccount(ctx context.Context, r *pb.GetAccountRequest) (*pb.GetAccountResponse, error) {
+	if err := auth.AuthorizeAccount(ctx, r.Id); err != nil {
+		return nil, err
+	}
 	a, err := s.service.GetAccount(ctx, r.Id)
 	if err != nil {
 		return nil, err
 	}
 	return &pb.GetAccountResponse{
-		Account: &pb.Account{
-			Id:   a.ID,
-			Name: a.Name,
-		},
+		Account: accountToProto(a),
 	}, nil
 }
 
 func (s *grpcServer) GetAccounts(ctx context.Context, r *pb.GetAccountsRequest) (*pb.GetAccountsResponse, error) {
-	res, err := s.service.GetAccounts(ctx, r.Skip, r.Take)
+	res, err := s.service.GetAccounts(ctx, r.Skip, r.Take, r.After)
 	if err != nil {
 		return nil, err
 	}
 	accounts := []*pb.Account{}
 	for _, a := range res {
-		accounts = append(accounts, &pb.Account{
-			Id:   a.ID,
-			Name: a.Name,
-		})
+		accounts = append(accounts, accountToProto(&a))
 	}
 	return &pb.GetAccountsResponse{
 		Accounts: accounts,
 	}, nil
 }
+
+func (s *grpcServer) UpdateAccount(ctx context.Context, r *pb.UpdateAccountRequest) (*pb.UpdateAccountResponse, error) {
+	if err := auth.AuthorizeAccount(ctx, r.Id); err != nil {
+		return nil, err
+	}
+	a, err := s.service.UpdateAccount(ctx, r.Id, r.Name)
+	if err != nil {
+		return nil, err
+	}
+	return &pb.UpdateAccountResponse{
+		Account: accountToProto(a),
+	}, nil
+}
+
+func (s *grpcServer) DeleteAccount(ctx context.Context, r *pb.DeleteAccountRequest) (*pb.DeleteAccountResponse, error) {
+	if err := auth.AuthorizeAccount(ctx, r.Id); err != nil {
+		return nil, err
+	}
+	if err := s.service.DeleteAccount(ctx, r.Id); err != nil {
+		return nil, err
+	}
+	return &pb.DeleteAccountResponse{}, nil
+}
+
+func (s *grpcServer) Register(ctx context.Context, r *pb.RegisterRequest) (*pb.RegisterResponse, error) {
+	a, tokens, err := s.service.Register(ctx, r.Name, r.Email, r.Password)
+	if err != nil {
+		return nil, err
+	}
+	return &pb.RegisterResponse{
+		Account: accountToProto(a),
+		Tokens:  tokensToProto(tokens),
+	}, nil
+}
+
+func (s *grpcServer) Login(ctx context.Context, r *pb.LoginRequest) (*pb.LoginResponse, error) {
+	a, tokens, err := s.service.Login(ctx, r.Email, r.Password)
+	if err != nil {
+		return nil, err
+	}
+	return &pb.LoginResponse{
+		Account: accountToProto(a),
+		Tokens:  tokensToProto(tokens),
+	}, nil
+}
+
+func (s *grpcServer) RefreshToken(ctx context.Context, r *pb.RefreshTokenRequest) (*pb.RefreshTokenResponse, error) {
+	tokens, err := s.service.RefreshToken(ctx, r.RefreshToken)
+	if err != nil {
+		return nil, err
+	}
+	return &pb.RefreshTokenResponse{
+		Tokens: tokensToProto(tokens),
+	}, nil
+}
+
+// accountToProto converts an Account to it's protobuf message.
+// The password hash is never sent.
+func accountToProto(a *Account) *pb.Account {
+	return &pb.Account{
+		Id:    a.ID,
+		Name:  a.Name,
+		Email: a.Email,
+		Roles: a.Roles,
+	}
+}
+
+func tokensToProto(t *Tokens) *pb.Tokens {
+	return &pb.Tokens{
+		AccessToken:          t.AccessToken,
+		RefreshToken:         t.RefreshToken,
+		AccessTokenExpiresAt: t.AccessTokenExpiresAt.Unix(),
+	}
+}
@@ -2,13 +2,39 @@ package account
 
 import (
 	"context"
+	"errors"
+	"net/mail"
+	"strings"
 
+	"github.com/haroonalbar/go-grpc-graphql-microservices/auth"
 	"github.com/segmentio/ksuid"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	RoleUser  = auth.RoleUser
+	RoleAdmin = auth.RoleAdmin
+
+	minPasswordLength = 8
+
+	// dummyPasswordHash is compared against when there is no password to check, so Login takes
+	// as long for unknown emails as for wrong passwords. It has the cost Register hashes with.
+	dummyPasswordHash = "$2a$10$zVnZlrJmcapSJx1vk9GJVeIclQjCyVKIwFv5/gH1IwU85OgShIaLG"
+)
+
+var (
+	ErrInvalidCredentials = errors.New("invalid email or password")
+	ErrInvalidEmail       = errors.New("invalid email")
+	ErrPasswordTooShort   = errors.New("password is too short")
 )
 
 type Account struct {
-	ID   string `json:"id"`
-	Name string `json:"name"`
+	ID    string   `json:"id"`
+	Name  string   `json:"name"`
+	Email string   `json:"email"`
+	Roles []string `json:"roles"`
+	// PasswordHash is the bcrypt hash of the password, it never leaves the account service
+	PasswordHash string `json:"-"`
 }
 
 // The Service layer acts as an intermediary between the Server (API handlers) and the Repository (data access).
@@ -33,16 +59,23 @@ type Account struct {
 type Service interface {
 	PostAccount(ctx context.Context, name string) (*Account, error)
 	GetAccount(ctx context.Context, id string) (*Account, error)
-	GetAccounts(ctx context.Context, skip uint64, take uint64) ([]Account, error)
+	// GetAccounts lists accounts newest first, after is the ID of the last account of the previous page
+	GetAccounts(ctx context.Context, skip uint64, take uint64, after string) ([]Account, error)
+	UpdateAccount(ctx context.Context, id string, name string) (*Account, error)
+	DeleteAccount(ctx context.Context, id string) error
+	Register(ctx context.Context, name, email, password string) (*Account, *Tokens, error)
+	Login(ctx context.Context, email, password string) (*Account, *Tokens, error)
+	RefreshToken(ctx context.Context, refreshToken string) (*Tokens, error)
 }
 
 type accountService struct {
 	repository Repository
+	tokens     *TokenIssuer
 }
 
 func (s *accountService) PostAccount(ctx context.Context, name string) (*Account, error) {
 	// creates new id using ksuid
-	a := Account{ID: ksuid.New().String(), Name: name}
+	a := Account{ID: ksuid.New().String(), Name: name, Roles: []string{RoleUser}}
 
 	if err := s.repository.PutAccount(ctx, a); err != nil {
 		return nil, err
@@ -54,13 +87,105 @@ func (s *accountService) GetAccount(ctx context.Context, id string) (*Account, e
 	return s.repository.GetAccountByID(ctx, id)
 }
 
-func (s *accountService) GetAccounts(ctx context.Context, skip uint64, take uint64) ([]Account, error) {
+func (s *accountService) GetAccounts(ctx context.Context, skip uint64, take uint64, after string) ([]Account, error) {
 	if take > 100 || (skip == 0 && take == 0) {
 		take = 100
 	}
-	return s.repository.ListAccounts(ctx, skip, take)
+	return s.repository.ListAccounts(ctx, skip, take, after)
+}
+
+// UpdateAccount renames the account and returns it with all its fields.
+// ErrNotFound is returned if the account doesn't exist or was deleted.
+func (s *accountService) UpdateAccount(ctx context.Context, id string, name string) (*Account, error) {
+	if err := s.repository.UpdateAccount(ctx, Account{ID: id, Name: name}); err != nil {
+		return nil, err
+	}
+	// the update only sets the name, read the account back for the email and roles
+	return s.repository.GetAccountByID(ctx, id)
+}
+
+// DeleteAccount is a soft delete, see postgresRepository.DeleteAccount
+func (s *accountService) DeleteAccount(ctx context.Context, id string) error {
+	return s.repository.DeleteAccount(ctx, id)
+}
+
+// Register creates an account that can log in with email and password
+// and returns it along with a fresh pair of tokens.
+func (s *accountService) Register(ctx context.Context, name, email, password string) (*Account, *Tokens, error) {
+	addr, err := mail.ParseAddress(email)
+	if err != nil {
+		return nil, nil, ErrInvalidEmail
+	}
+	if len(password) < minPasswordLength {
+		return nil, nil, ErrPasswordTooShort
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	a := Account{
+		ID:           ksuid.New().String(),
+		Name:         name,
+		Email:        strings.ToLower(addr.Address),
+		Roles:        []string{RoleUser},
+		PasswordHash: string(hash),
+	}
+	if err := s.repository.PutAccount(ctx, a); err != nil {
+		return nil, nil, err
+	}
+
+	tokens, err := s.tokens.Issue(&a)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &a, tokens, nil
+}
+
+// Login checks the password of the account registered with email.
+// Unknown emails and wrong passwords both return ErrInvalidCredentials after running bcrypt,
+// so callers can't find out which emails are registered, not even from the response time.
+func (s *accountService) Login(ctx context.Context, email, password string) (*Account, *Tokens, error) {
+	a, err := s.repository.GetAccountByEmail(ctx, strings.ToLower(strings.TrimSpace(email)))
+	if err != nil && err != ErrNotFound {
+		return nil, nil, err
+	}
+	if err == ErrNotFound || a.PasswordHash == "" {
+		// spend the time of a password check, the result doesn't matter
+		bcrypt.CompareHashAndPassword([]byte(dummyPasswordHash), []byte(password))
+		return nil, nil, ErrInvalidCredentials
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(a.PasswordHash), []byte(password)); err != nil {
+		return nil, nil, ErrInvalidCredentials
+	}
+
+	tokens, err := s.tokens.Issue(a)
+	if err != nil {
+		return nil, nil, err
+	}
+	return a, tokens, nil
+}
+
+// RefreshToken exchanges a valid refresh token for a new pair of tokens.
+// The account is loaded again, so deleted accounts can't refresh and role changes are picked up.
+func (s *accountService) RefreshToken(ctx context.Context, refreshToken string) (*Tokens, error) {
+	claims, err := s.tokens.Verify(refreshToken, TokenTypeRefresh)
+	if err != nil {
+		return nil, err
+	}
+
+	a, err := s.repository.GetAccountByID(ctx, claims.AccountID())
+	if err == ErrNotFound {
+		return nil, ErrInvalidToken
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return s.tokens.Issue(a)
 }
 
-func NewService(r Repository) Service {
-	return &accountService{r}
+func NewService(r Repository, tokens *TokenIssuer) Service {
+	return &accountService{r, tokens}
 }
@@ -0,0 +1,152 @@
+package account
+
+import (
+	"crypto/rsa"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/haroonalbar/go-grpc-graphql-microservices/auth"
+)
+
+const (
+	AccessTokenTTL  = 15 * time.Minute
+	RefreshTokenTTL = 7 * 24 * time.Hour
+
+	TokenTypeAccess  = "access"
+	TokenTypeRefresh = "refresh"
+
+	tokenIssuer = "account"
+)
+
+var ErrInvalidToken = errors.New("invalid token")
+
+// Claims are the JWT claims of access and refresh tokens.
+// The account ID is stored in the standard subject claim.
+type Claims struct {
+	Roles     []string `json:"roles"`
+	TokenType string   `json:"token_type"`
+	jwt.RegisteredClaims
+}
+
+func (c *Claims) AccountID() string {
+	return c.Subject
+}
+
+// Tokens is the pair of tokens handed out on register, login and refresh
+type Tokens struct {
+	AccessToken          string    `json:"access_token"`
+	RefreshToken         string    `json:"refresh_token"`
+	AccessTokenExpiresAt time.Time `json:"access_token_expires_at"`
+}
+
+// TokenVerifier checks tokens signed by the account service.
+// It only needs the public key, so it can be used by any service that accepts tokens.
+type TokenVerifier struct {
+	publicKey *rsa.PublicKey
+}
+
+// NewTokenVerifier loads a PEM encoded RSA public key from publicKeyFile
+func NewTokenVerifier(publicKeyFile string) (*TokenVerifier, error) {
+	pem, err := os.ReadFile(publicKeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read public key: %w", err)
+	}
+	key, err := jwt.ParseRSAPublicKeyFromPEM(pem)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse public key: %w", err)
+	}
+	return &TokenVerifier{publicKey: key}, nil
+}
+
+// Verify checks the signature, expiry and type of the token and returns its claims
+func (v *TokenVerifier) Verify(token string, tokenType string) (*Claims, error) {
+	claims := &Claims{}
+	_, err := jwt.ParseWithClaims(
+		token,
+		claims,
+		func(t *jwt.Token) (interface{}, error) {
+			return v.publicKey, nil
+		},
+		jwt.WithValidMethods([]string{jwt.SigningMethodRS256.Alg()}),
+		jwt.WithIssuer(tokenIssuer),
+		jwt.WithExpirationRequired(),
+	)
+	if err != nil || claims.TokenType != tokenType || claims.Subject == "" {
+		return nil, ErrInvalidToken
+	}
+	return claims, nil
+}
+
+// Authenticate verifies an access token and returns the identity of its account.
+// It implements auth.Authenticator, so the gRPC servers can check forwarded tokens.
+func (v *TokenVerifier) Authenticate(token string) (*auth.Identity, error) {
+	claims, err := v.Verify(token, TokenTypeAccess)
+	if err != nil {
+		return nil, err
+	}
+	return &auth.Identity{ID: claims.AccountID(), Roles: claims.Roles}, nil
+}
+
+// TokenIssuer signs access and refresh tokens with the private key of the account service.
+type TokenIssuer struct {
+	*TokenVerifier
+	privateKey *rsa.PrivateKey
+}
+
+// NewTokenIssuer loads a PEM encoded RSA private key from privateKeyFile.
+// The public key used to verify refresh tokens is derived from it.
+func NewTokenIssuer(privateKeyFile string) (*TokenIssuer, error) {
+	pem, err := os.ReadFile(privateKeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read private key: %w", err)
+	}
+	key, err := jwt.ParseRSAPrivateKeyFromPEM(pem)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse private key: %w", err)
+	}
+	return NewTokenIssuerFromKey(key), nil
+}
+
+// NewTokenIssuerFromKey signs tokens with a key that isn't read from a file,
+// like one generated when a development server starts.
+func NewTokenIssuerFromKey(key *rsa.PrivateKey) *TokenIssuer {
+	return &TokenIssuer{
+		TokenVerifier: &TokenVerifier{publicKey: &key.PublicKey},
+		privateKey:    key,
+	}
+}
+
+// Issue creates a new access and refresh token for the account
+func (i *TokenIssuer) Issue(a *Account) (*Tokens, error) {
+	now := time.Now()
+	accessToken, err := i.sign(a, TokenTypeAccess, now, AccessTokenTTL)
+	if err != nil {
+		return nil, err
+	}
+	refreshToken, err := i.sign(a, TokenTypeRefresh, now, RefreshTokenTTL)
+	if err != nil {
+		return nil, err
+	}
+	return &Tokens{
+		AccessToken:          accessToken,
+		RefreshToken:         refreshToken,
+		AccessTokenExpiresAt: now.Add(AccessTokenTTL),
+	}, nil
+}
+
+func (i *TokenIssuer) sign(a *Account, tokenType string, now time.Time, ttl time.Duration) (string, error) {
+	claims := Claims{
+		Roles:     a.Roles,
+		TokenType: tokenType,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    tokenIssuer,
+			Subject:   a.ID,
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(ttl)),
+		},
+	}
+	return jwt.NewWithClaims(jwt.SigningMethodRS256, claims).SignedString(i.privateKey)
+}
@@ -1,4 +1,20 @@
 CREATE TABLE IF NOT EXISTS accounts (
     id CHAR(27) PRIMARY KEY,
-    name VARCHAR(24) NOT NULL
+    name VARCHAR(24) NOT NULL,
+    -- email and password_hash are only set for accounts that can log in
+    email VARCHAR(255) UNIQUE,
+    password_hash VARCHAR(60),
+    roles TEXT[] NOT NULL DEFAULT '{user}',
+    -- accounts are soft deleted so orders referencing them keep working
+    deleted_at TIMESTAMP WITH TIME ZONE
 );
+
+-- tables created before accounts could be deleted
+ALTER TABLE accounts ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMP WITH TIME ZONE;
+
+-- tables created before accounts could log in, their accounts have no credentials and the user role.
+-- The index has the name of the UNIQUE constraint above, PutAccount recognizes taken emails by it
+ALTER TABLE accounts ADD COLUMN IF NOT EXISTS email VARCHAR(255);
+ALTER TABLE accounts ADD COLUMN IF NOT EXISTS password_hash VARCHAR(60);
+ALTER TABLE accounts ADD COLUMN IF NOT EXISTS roles TEXT[] NOT NULL DEFAULT '{user}';
+CREATE UNIQUE INDEX IF NOT EXISTS accounts_email_key ON accounts (email);
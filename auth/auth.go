@@ -0,0 +1,81 @@
+// Package auth carries the identity of the caller between the services.
+// The graphql gateway forwards the access token of the user in gRPC metadata,
+// services calling each other authenticate with a shared service token.
+// Every gRPC server checks the caller with UnaryServerInterceptor.
+package auth
+
+import (
+	"context"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	RoleUser  = "user"
+	RoleAdmin = "admin"
+	// RoleService is given to other services calling with the service token
+	RoleService = "service"
+)
+
+// These are gRPC status errors so clients can tell them apart from other failures
+var (
+	ErrUnauthenticated  = status.Error(codes.Unauthenticated, "unauthenticated")
+	ErrPermissionDenied = status.Error(codes.PermissionDenied, "permission denied")
+)
+
+// Identity is the authenticated caller of a request.
+// For users ID is the account ID, for services it's the name of the service.
+type Identity struct {
+	ID    string
+	Roles []string
+}
+
+func (i *Identity) HasRole(role string) bool {
+	if i == nil {
+		return false
+	}
+	for _, r := range i.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// Authenticator turns an access token into the identity of the caller.
+// account.TokenVerifier implements it.
+type Authenticator interface {
+	Authenticate(token string) (*Identity, error)
+}
+
+type contextKey int
+
+const (
+	identityKey contextKey = iota
+	tokenKey
+	serviceKey
+)
+
+// NewContext returns a copy of ctx carrying the identity of the caller
+func NewContext(ctx context.Context, id *Identity) context.Context {
+	return context.WithValue(ctx, identityKey, id)
+}
+
+// FromContext returns the identity of the caller, or nil for anonymous requests
+func FromContext(ctx context.Context) *Identity {
+	id, _ := ctx.Value(identityKey).(*Identity)
+	return id
+}
+
+// AuthorizeAccount allows the owner of the account, admins and other services
+func AuthorizeAccount(ctx context.Context, accountID string) error {
+	id := FromContext(ctx)
+	if id == nil {
+		return ErrUnauthenticated
+	}
+	if id.ID == accountID || id.HasRole(RoleAdmin) || id.HasRole(RoleService) {
+		return nil
+	}
+	return ErrPermissionDenied
+}
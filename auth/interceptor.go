@@ -0,0 +1,185 @@
+package auth
+
+import (
+	"context"
+	"crypto/subtle"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// metadata keys, gRPC metadata keys are always lower case
+const (
+	authorizationKey = "authorization"
+	serviceNameKey   = "x-service-name"
+	serviceTokenKey  = "x-service-token"
+)
+
+// Rule is what a caller needs to call a method
+type Rule struct {
+	// Public methods can be called without authentication
+	Public bool
+	// Roles the caller needs one of, empty means any authenticated caller
+	Roles []string
+}
+
+var (
+	Public        = Rule{Public: true}
+	Authenticated = Rule{}
+)
+
+// RequireRole allows callers that have any of the roles
+func RequireRole(roles ...string) Rule {
+	return Rule{Roles: roles}
+}
+
+// Policy maps full gRPC method names, like pb.AccountService_GetAccount_FullMethodName, to rules.
+// Methods that aren't in the policy need an authenticated caller.
+// Rules only look at roles, checks that depend on the request, like ownership,
+// are done by the handlers with AuthorizeAccount.
+type Policy map[string]Rule
+
+func (p Policy) allows(method string, id *Identity) error {
+	rule, ok := p[method]
+	if !ok {
+		rule = Authenticated
+	}
+	if rule.Public {
+		return nil
+	}
+	if id == nil {
+		return ErrUnauthenticated
+	}
+	if len(rule.Roles) == 0 {
+		return nil
+	}
+	for _, role := range rule.Roles {
+		if id.HasRole(role) {
+			return nil
+		}
+	}
+	return ErrPermissionDenied
+}
+
+// UnaryServerInterceptor authenticates the caller from the request metadata,
+// puts its identity into the context and enforces the policy.
+// serviceToken is the secret shared by the services, an empty token disables service calls.
+func UnaryServerInterceptor(a Authenticator, serviceToken string, p Policy) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		id, err := authenticate(ctx, a, serviceToken)
+		if err != nil {
+			return nil, err
+		}
+		if err := p.allows(info.FullMethod, id); err != nil {
+			return nil, err
+		}
+		if id != nil {
+			ctx = NewContext(ctx, id)
+		}
+		return handler(ctx, req)
+	}
+}
+
+// StreamServerInterceptor is the streaming version of UnaryServerInterceptor
+func StreamServerInterceptor(a Authenticator, serviceToken string, p Policy) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		id, err := authenticate(ss.Context(), a, serviceToken)
+		if err != nil {
+			return err
+		}
+		if err := p.allows(info.FullMethod, id); err != nil {
+			return err
+		}
+		if id != nil {
+			ss = &serverStream{ServerStream: ss, ctx: NewContext(ss.Context(), id)}
+		}
+		return handler(srv, ss)
+	}
+}
+
+// serverStream replaces the context of a stream with one carrying the identity of the caller
+type serverStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *serverStream) Context() context.Context {
+	return s.ctx
+}
+
+// authenticate returns nil without an error for anonymous requests.
+// Credentials that are present but invalid are always rejected.
+func authenticate(ctx context.Context, a Authenticator, serviceToken string) (*Identity, error) {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return nil, nil
+	}
+
+	if token := first(md, serviceTokenKey); token != "" {
+		if serviceToken == "" || subtle.ConstantTimeCompare([]byte(token), []byte(serviceToken)) != 1 {
+			return nil, ErrUnauthenticated
+		}
+		return &Identity{ID: first(md, serviceNameKey), Roles: []string{RoleService}}, nil
+	}
+
+	if header := first(md, authorizationKey); header != "" {
+		token, ok := strings.CutPrefix(header, "Bearer ")
+		if !ok {
+			return nil, ErrUnauthenticated
+		}
+		id, err := a.Authenticate(token)
+		if err != nil {
+			return nil, ErrUnauthenticated
+		}
+		return id, nil
+	}
+
+	return nil, nil
+}
+
+func first(md metadata.MD, key string) string {
+	if v := md.Get(key); len(v) > 0 {
+		return v[0]
+	}
+	return ""
+}
+
+// WithToken returns a copy of ctx that makes the clients forward the access token of a user.
+// The graphql gateway uses it for the requests it makes on behalf of the user.
+func WithToken(ctx context.Context, token string) context.Context {
+	return context.WithValue(ctx, tokenKey, token)
+}
+
+// ServiceCredentials identify a service when it calls other services
+type ServiceCredentials struct {
+	Name  string
+	Token string
+}
+
+// Context returns a copy of ctx that makes the clients call as the service.
+// The service identity takes precedence over a user token in ctx.
+func (c ServiceCredentials) Context(ctx context.Context) context.Context {
+	return context.WithValue(ctx, serviceKey, c)
+}
+
+// UnaryClientInterceptor adds the credentials found in the context to the outgoing metadata.
+// All the service clients install it.
+func UnaryClientInterceptor(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	return invoker(outgoingContext(ctx), method, req, reply, cc, opts...)
+}
+
+// StreamClientInterceptor is the streaming version of UnaryClientInterceptor
+func StreamClientInterceptor(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+	return streamer(outgoingContext(ctx), desc, cc, method, opts...)
+}
+
+func outgoingContext(ctx context.Context) context.Context {
+	if c, ok := ctx.Value(serviceKey).(ServiceCredentials); ok {
+		return metadata.AppendToOutgoingContext(ctx, serviceNameKey, c.Name, serviceTokenKey, c.Token)
+	}
+	if token, ok := ctx.Value(tokenKey).(string); ok && token != "" {
+		return metadata.AppendToOutgoingContext(ctx, authorizationKey, "Bearer "+token)
+	}
+	return ctx
+}
@@ -5,8 +5,12 @@ import (
 	"errors"
 	"log"
 
+	"github.com/haroonalbar/go-grpc-graphql-microservices/auth"
 	"github.com/haroonalbar/go-grpc-graphql-microservices/catalog/pb"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
 )
 
 // NOTE: This will used from the graphql/Server struct
@@ -18,8 +22,18 @@ type Client struct {
 	service pb.CatalogServiceClient
 }
 
-func NewClient(url string) (*Client, error) {
-	conn, err := grpc.Dial(url, grpc.WithInsecure())
+// NewClient connects to the service at url.
+// opts are added to the dial options, like a dialer for in-process connections.
+func NewClient(url string, opts ...grpc.DialOption) (*Client, error) {
+	conn, err := grpc.Dial(
+		url,
+		append([]grpc.DialOption{
+			grpc.WithInsecure(),
+			// forwards the caller identity from the context, see auth.WithToken and auth.ServiceCredentials
+			grpc.WithUnaryInterceptor(auth.UnaryClientInterceptor),
+			grpc.WithStreamInterceptor(auth.StreamClientInterceptor),
+		}, opts...)...,
+	)
 	// conn, err := grpc.NewClient(url, grpc.WithTransportCredentials(insecure.NewCredentials()))
 	if err != nil {
 		return nil, err
@@ -37,25 +51,22 @@ func (c *Client) Close() {
 	c.conn.Close()
 }
 
-func (c *Client) PostProduct(ctx context.Context, name, description string, price float64) (*Product, error) {
+func (c *Client) PostProduct(ctx context.Context, name, description string, price float64, category string, stock uint64) (*Product, error) {
 	res, err := c.service.PostProduct(
 		ctx,
 		&pb.PostProductRequest{
 			Name:        name,
 			Description: description,
 			Price:       price,
+			Category:    category,
+			Stock:       stock,
 		})
 	if err != nil {
 		log.Println(err)
 		return nil, err
 	}
 	log.Println(res.Product)
-	return &Product{
-		ID:          res.Product.Id,
-		Name:        res.Product.Name,
-		Description: res.Product.Description,
-		Price:       res.Product.Price,
-	}, nil
+	return productFromProto(res.Product), nil
 }
 
 func (c *Client) GetProduct(ctx context.Context, id string) (*Product, error) {
@@ -67,12 +78,7 @@ func (c *Client) GetProduct(ctx context.Context, id string) (*Product, error) {
 	if err != nil {
 		return nil, err
 	}
-	return &Product{
-		ID:          res.Product.Id,
-		Name:        res.Product.Name,
-		Description: res.Product.Description,
-		Price:       res.Product.Price,
-	}, nil
+	return productFromProto(res.Product), nil
 }
 
 func (c *Client) GetProducts(ctx context.Context, skip, take uint64, ids []string, query string) ([]Product, error) {
@@ -89,19 +95,176 @@ func (c *Client) GetProducts(ctx context.Context, skip, take uint64, ids []strin
 		return nil, err
 	}
 	log.Printf("Received products response: %+v", res)
-	if res.Products == nil {
+	// IDs that don't exist are left out, so a lookup by IDs can match nothing
+	if res.Products == nil && len(ids) == 0 {
 		log.Println("No products returned from service")
 		return nil, errors.New("no products found")
 	}
 	var products []Product
 	for _, p := range res.Products {
-		products = append(products, Product{
-			ID:          p.Id,
-			Name:        p.Name,
-			Description: p.Description,
-			Price:       p.Price,
-		})
+		products = append(products, *productFromProto(p))
 	}
 	log.Printf("Successfully fetched %d products", len(products))
 	return products, nil
 }
+
+// ProductPage is one page of listed or searched products
+type ProductPage struct {
+	Products []Product
+	// NextPageToken continues after the last product, it's empty if the page is empty
+	NextPageToken string
+	// Facets count all the products matching the query and filters, not just the page
+	Facets *Facets
+}
+
+// GetProductsPage lists, or searches if query is set, one page of products using page tokens.
+// Pass the returned token to get the next page, an empty page means there are no more products.
+// Unlike skip, page tokens work at any depth, so this can walk the whole catalog.
+// q filters and sorts the products, a page token only works with the query and q it came from.
+// skip can be used instead of the page token.
+func (c *Client) GetProductsPage(ctx context.Context, skip, take uint64, query string, pageToken string, q ProductQuery) (*ProductPage, error) {
+	req := &pb.GetProductsRequest{
+		Skip:          skip,
+		Take:          take,
+		Query:         query,
+		PageToken:     pageToken,
+		Sort:          string(q.Sort),
+		PriceInterval: q.PriceInterval,
+		Filter: &pb.ProductFilter{
+			MinPrice:   q.Filter.MinPrice,
+			MaxPrice:   q.Filter.MaxPrice,
+			Categories: q.Filter.Categories,
+		},
+	}
+	res, err := c.service.GetProducts(ctx, req)
+	if err != nil {
+		log.Println("Error fetching products from service: ", err)
+		return nil, err
+	}
+	page := &ProductPage{
+		Products:      []Product{},
+		NextPageToken: res.NextPageToken,
+		Facets:        &Facets{Prices: []PriceBucket{}, Categories: []CategoryBucket{}},
+	}
+	for _, p := range res.Products {
+		page.Products = append(page.Products, *productFromProto(p))
+	}
+	for _, b := range res.Facets.GetPrices() {
+		page.Facets.Prices = append(page.Facets.Prices, PriceBucket{From: b.From, To: b.To, Count: b.Count})
+	}
+	for _, b := range res.Facets.GetCategories() {
+		page.Facets.Categories = append(page.Facets.Categories, CategoryBucket{Category: b.Category, Count: b.Count})
+	}
+	return page, nil
+}
+
+// SuggestProducts returns up to size products whose name, or a word of it, starts with prefix
+func (c *Client) SuggestProducts(ctx context.Context, prefix string, size uint64) ([]Product, error) {
+	res, err := c.service.SuggestProducts(ctx, &pb.SuggestProductsRequest{
+		Prefix: prefix,
+		Size:   size,
+	})
+	if err != nil {
+		log.Println("Error fetching product suggestions from service: ", err)
+		return nil, err
+	}
+	products := []Product{}
+	for _, p := range res.Products {
+		products = append(products, *productFromProto(p))
+	}
+	return products, nil
+}
+
+// UpdateProduct partially updates a product. Only the fields that are not nil are changed.
+func (c *Client) UpdateProduct(ctx context.Context, id string, name, description *string, price *float64, category *string, stock *uint64) (*Product, error) {
+	req := &pb.UpdateProductRequest{
+		Id:         id,
+		UpdateMask: &fieldmaskpb.FieldMask{},
+	}
+	if name != nil {
+		req.Name = *name
+		req.UpdateMask.Paths = append(req.UpdateMask.Paths, FieldName)
+	}
+	if description != nil {
+		req.Description = *description
+		req.UpdateMask.Paths = append(req.UpdateMask.Paths, FieldDescription)
+	}
+	if price != nil {
+		req.Price = *price
+		req.UpdateMask.Paths = append(req.UpdateMask.Paths, FieldPrice)
+	}
+	if category != nil {
+		req.Category = *category
+		req.UpdateMask.Paths = append(req.UpdateMask.Paths, FieldCategory)
+	}
+	if stock != nil {
+		req.Stock = *stock
+		req.UpdateMask.Paths = append(req.UpdateMask.Paths, FieldStock)
+	}
+	// an empty mask would update every field
+	if len(req.UpdateMask.Paths) == 0 {
+		return nil, ErrInvalidUpdateMask
+	}
+
+	res, err := c.service.UpdateProduct(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	return productFromProto(res.Product), nil
+}
+
+func (c *Client) DeleteProduct(ctx context.Context, id string) error {
+	_, err := c.service.DeleteProduct(
+		ctx,
+		&pb.DeleteProductRequest{
+			Id: id,
+		})
+	return err
+}
+
+// ReserveStock takes the quantities from stock, either all of them or none.
+// It's only allowed with the service token, the order service calls it when an order is placed.
+// ErrInsufficientStock is returned if a product doesn't have enough stock.
+// With a reservationID the catalog records what it took, calling it again with the ID takes nothing.
+func (c *Client) ReserveStock(ctx context.Context, reservationID string, items []StockItem) error {
+	_, err := c.service.ReserveStock(ctx, &pb.ReserveStockRequest{Items: stockItemsToProto(items), ReservationId: reservationID})
+	if status.Code(err) == codes.FailedPrecondition {
+		return ErrInsufficientStock
+	}
+	return err
+}
+
+// ReleaseStock puts reserved quantities back into stock, when an order is cancelled.
+// With a reservationID only what the reservation took is put back, which may be nothing.
+func (c *Client) ReleaseStock(ctx context.Context, reservationID string, items []StockItem) error {
+	_, err := c.service.ReleaseStock(ctx, &pb.ReleaseStockRequest{Items: stockItemsToProto(items), ReservationId: reservationID})
+	return err
+}
+
+// ConfirmStock tells the catalog the order of the reservation was saved, it drops its record of the reservation
+func (c *Client) ConfirmStock(ctx context.Context, reservationID string, items []StockItem) error {
+	_, err := c.service.ConfirmStock(ctx, &pb.ConfirmStockRequest{Items: stockItemsToProto(items), ReservationId: reservationID})
+	return err
+}
+
+func stockItemsToProto(items []StockItem) []*pb.StockItem {
+	stockItems := make([]*pb.StockItem, 0, len(items))
+	for _, item := range items {
+		stockItems = append(stockItems, &pb.StockItem{ProductId: item.ProductID, Quantity: item.Quantity})
+	}
+	return stockItems
+}
+
+func productFromProto(p *pb.Product) *Product {
+	return &Product{
+		ID:          p.Id,
+		Name:        p.Name,
+		Description: p.Description,
+		Price:       p.Price,
+		Category:    p.Category,
+		Stock:       p.Stock,
+		Deleted:     p.Deleted,
+		Score:       p.Score,
+		Cursor:      p.Cursor,
+	}
+}
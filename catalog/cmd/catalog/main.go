@@ -1,16 +1,34 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"log"
+	"net/url"
+	"os"
 	"time"
 
+	"github.com/haroonalbar/go-grpc-graphql-microservices/account"
 	"github.com/haroonalbar/go-grpc-graphql-microservices/catalog"
 	"github.com/kelseyhightower/envconfig"
 	"github.com/tinrab/retry"
 )
 
+// errUnknownBackend is returned by newRepository if SEARCH_BACKEND isn't one of the supported search engines.
+// Retrying doesn't help, like catalog.ErrUnsupportedVersion the config has to change.
+var errUnknownBackend = errors.New("unknown search backend")
+
 type Config struct {
+	// DatabaseURL is a postgres:// URL for PostgreSQL, otherwise the URL of the search engine
 	DatabaseURL string `envconfig:"DATABASE_URL"`
+	// SearchBackend is the search engine at DatabaseURL:
+	// elasticsearch6, elasticsearch for Elasticsearch 7 and 8, or opensearch
+	SearchBackend string `envconfig:"SEARCH_BACKEND" default:"elasticsearch6"`
+	// PEM encoded RSA public key of the account service, used to verify access tokens
+	JWTPublicKeyFile string `envconfig:"JWT_PUBLIC_KEY_FILE" default:"/keys/jwt.pub.pem"`
+	// Secret shared by the services to call each other
+	ServiceToken string `envconfig:"SERVICE_TOKEN"`
 }
 
 func main() {
@@ -21,13 +39,52 @@ func main() {
 	if err != nil {
 		log.Fatalf("error processing envconfig: %v", err)
 	}
+
+	// `catalog reindex` moves the products into a new index with the current mapping and exits
+	if len(os.Args) > 1 && os.Args[1] == "reindex" {
+		r, err := newRepository(cfg)
+		if err != nil {
+			log.Fatalf("error connecting to the catalog database: %v", err)
+		}
+		reindexer, ok := r.(catalog.Reindexer)
+		if !ok {
+			log.Fatalf("the catalog database has no index to reindex")
+		}
+		index, err := reindexer.Reindex(context.Background())
+		if err != nil {
+			log.Fatalf("error reindexing the catalog: %v", err)
+		}
+		log.Println("Catalog reindexed into", index)
+		return
+	}
+
+	verifier, err := account.NewTokenVerifier(cfg.JWTPublicKeyFile)
+	if err != nil {
+		log.Fatalf("error loading jwt public key: %v", err)
+	}
+
 	var r catalog.Repository
 	// Retry forever with 2-second intervals until successfully connecting to the database
 	retry.ForeverSleep(2*time.Second, func(_ int) (err error) {
-		// Attempt to create new Elasticsearch repository connection
-		r, err = catalog.NewElasticRepository(cfg.DatabaseURL)
+		// Attempt to create new search engine repository connection
+		r, err = newRepository(cfg)
+		if errors.Is(err, catalog.ErrUnsupportedVersion) || errors.Is(err, errUnknownBackend) {
+			log.Fatal(err)
+		}
 		if err != nil {
 			log.Println(err)
+			return
+		}
+		// an index created by an older version gets the new fields, if it can't take them it isn't served
+		if reindexer, ok := r.(catalog.Reindexer); ok {
+			err = reindexer.UpgradeIndex(context.Background())
+			if errors.Is(err, catalog.ErrIndexOutdated) {
+				log.Fatal(err)
+			}
+			if err != nil {
+				log.Println(err)
+				r.Close()
+			}
 		}
 		return
 	})
@@ -38,5 +95,23 @@ func main() {
 	// Create new catalog service with the repository
 	s := catalog.NewService(r)
 	// Start gRPC server on port 8080
-	log.Fatal(catalog.ListenGRPC(s, 8080))
+	log.Fatal(catalog.ListenGRPC(s, verifier, cfg.ServiceToken, 8080))
+}
+
+// newRepository connects to PostgreSQL if DATABASE_URL is a postgres URL,
+// otherwise to the search engine selected by SEARCH_BACKEND
+func newRepository(cfg Config) (catalog.Repository, error) {
+	if u, err := url.Parse(cfg.DatabaseURL); err == nil && (u.Scheme == "postgres" || u.Scheme == "postgresql") {
+		return catalog.NewPostgresRepository(cfg.DatabaseURL)
+	}
+	switch cfg.SearchBackend {
+	case "elasticsearch6":
+		return catalog.NewElasticRepository(cfg.DatabaseURL)
+	case "elasticsearch":
+		return catalog.NewElasticsearchRepository(cfg.DatabaseURL)
+	case "opensearch":
+		return catalog.NewOpenSearchRepository(cfg.DatabaseURL)
+	default:
+		return nil, fmt.Errorf("%w %q, use elasticsearch6, elasticsearch or opensearch", errUnknownBackend, cfg.SearchBackend)
+	}
 }
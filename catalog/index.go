@@ -0,0 +1,372 @@
+package catalog
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"gopkg.in/olivere/elastic.v5"
+)
+
+// Products are read and written through the catalog alias, which points at a versioned index like catalog_v2.
+// A mapping change goes into a new index that is filled by `catalog reindex`, which then swaps the alias,
+// so the service keeps running on the old index until the new one is complete.
+const (
+	indexAlias = "catalog"
+	// indexVersion is the version of indexBody, bump it whenever the mapping changes and run `catalog reindex`.
+	// Version 1 is the index elasticsearch created on its own with dynamic mappings.
+	// Version 3 added stock and version 4 the reservations of the stock,
+	// UpgradeIndex adds them to version 2 and 3 indices without a reindex.
+	indexVersion = 4
+)
+
+// ErrIndexOutdated is returned by UpgradeIndex if the catalog index can't take the current mapping,
+// its products have to be moved into a new index with `catalog reindex` before the service can use it.
+var ErrIndexOutdated = errors.New("catalog index is outdated, run catalog reindex")
+
+// indexBody returns the settings and mappings of the catalog index.
+// dynamic strict rejects documents with fields that aren't mapped here,
+// instead of letting elasticsearch guess their types.
+// Elasticsearch 7 removed mapping types, typeless indices map the properties directly
+// and have an id field to sort by, sorting by _id isn't allowed anymore.
+func indexBody(typeless bool) map[string]interface{} {
+	var mappings interface{} = map[string]interface{}{"product": indexMapping(false)}
+	if typeless {
+		mappings = indexMapping(true)
+	}
+	return map[string]interface{}{
+		"settings": map[string]interface{}{
+			"number_of_shards": 1,
+		},
+		"mappings": mappings,
+	}
+}
+
+// indexMapping returns the mapping of the products, for the product type or a typeless index
+func indexMapping(typeless bool) map[string]interface{} {
+	properties := map[string]interface{}{
+		"name": map[string]interface{}{
+			"type": "text",
+			// for sorting by name
+			"fields": map[string]interface{}{
+				"keyword": map[string]interface{}{
+					"type":         "keyword",
+					"ignore_above": 256,
+				},
+			},
+		},
+		"description": map[string]interface{}{
+			"type": "text",
+		},
+		"price": map[string]interface{}{
+			"type": "double",
+		},
+		// categories are filtered and aggregated on as a whole, they aren't searched
+		"category": map[string]interface{}{
+			"type": "keyword",
+		},
+		// stock is only updated by scripts and read by ID, it isn't searched
+		"stock": map[string]interface{}{
+			"type":  "long",
+			"index": false,
+		},
+		"deleted": map[string]interface{}{
+			"type": "boolean",
+		},
+		// reservation IDs to quantities, only the stock scripts read them, so they aren't parsed
+		"reservations": map[string]interface{}{
+			"type":    "object",
+			"enabled": false,
+		},
+		"suggest": map[string]interface{}{
+			"type": "completion",
+		},
+	}
+	if typeless {
+		properties["id"] = map[string]interface{}{"type": "keyword"}
+	}
+	return map[string]interface{}{
+		"dynamic":    "strict",
+		"properties": properties,
+	}
+}
+
+func indexName(version int) string {
+	return fmt.Sprintf("%s_v%d", indexAlias, version)
+}
+
+// indexVersionOf returns the version of a versioned index, the index without a version is 1
+func indexVersionOf(index string) int {
+	version, err := strconv.Atoi(strings.TrimPrefix(index, indexAlias+"_v"))
+	if err != nil {
+		return 1
+	}
+	return version
+}
+
+// Reindexer is implemented by the repositories that keep products in a versioned index
+type Reindexer interface {
+	// Reindex copies the products into a new index with the current mapping and points the alias at it.
+	// It returns the name of the new index.
+	Reindex(ctx context.Context) (string, error)
+	// UpgradeIndex adds the fields of the current mapping to an index created by an older version.
+	// ErrIndexOutdated is returned if the index can't take the mapping and has to be reindexed,
+	// the service must not serve it then, writes of the new fields would be rejected.
+	UpgradeIndex(ctx context.Context) error
+}
+
+// indexAdmin are the index operations ensureIndex and reindex are built on,
+// each search engine repository implements them with its client.
+type indexAdmin interface {
+	// indexExists reports if there is an index or alias with the name
+	indexExists(ctx context.Context, name string) (bool, error)
+	// aliasedIndex returns the index behind the catalog alias.
+	// If catalog is an index and not an alias, which is how elasticsearch created it before
+	// the indices were versioned, that index is returned.
+	aliasedIndex(ctx context.Context) (string, error)
+	createIndex(ctx context.Context, name string) error
+	// putMapping sets the current mapping on an existing index, which adds the fields it doesn't have yet.
+	// ErrIndexOutdated is returned if the index rejects it, e.g. because a field changed its type.
+	putMapping(ctx context.Context, index string) error
+	// swapAlias points the alias from one index to another in one atomic request.
+	// If from is the unversioned index it is deleted by the same request.
+	swapAlias(ctx context.Context, from, to string) error
+	// copyProducts copies every product from one index to another and returns how many were written.
+	// Copies use the version of the source document as external version, a document that already
+	// has the same or a newer version in the target is skipped.
+	copyProducts(ctx context.Context, from, to string) (int, error)
+}
+
+// ensureIndex creates the first versioned index and the alias if there is no catalog index yet.
+// An existing index is left as it is, its mapping is only changed by upgradeIndex and reindex.
+func ensureIndex(ctx context.Context, a indexAdmin) error {
+	exists, err := a.indexExists(ctx, indexAlias)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return nil
+	}
+
+	index := indexName(indexVersion)
+	if err := a.createIndex(ctx, index); err != nil {
+		return err
+	}
+	return a.swapAlias(ctx, "", index)
+}
+
+// upgradeIndex puts the current mapping on the index behind the alias if it's older.
+// A strict mapping can't change its fields, but it can get new ones, so versions that only add fields,
+// like stock in version 3 and reservations in version 4, are applied in place. The index keeps its name,
+// the next reindex still moves it to a new version. Every other change is rejected by the search engine
+// and returned as ErrIndexOutdated.
+func upgradeIndex(ctx context.Context, a indexAdmin) error {
+	current, err := a.aliasedIndex(ctx)
+	if err != nil {
+		return err
+	}
+	if indexVersionOf(current) >= indexVersion {
+		return nil
+	}
+	if err := a.putMapping(ctx, current); err != nil {
+		return err
+	}
+	log.Printf("Added the fields of mapping version %d to catalog index %s", indexVersion, current)
+	return nil
+}
+
+// reindex copies the products into a new index with the current mapping and points the alias at it.
+// Writes keep going to the old index while the products are copied. After the swap the old index
+// is copied again to catch up on them, documents keep their versions so a product changed in
+// the new index isn't overwritten by its older copy. The old index is kept to roll back to.
+// The unversioned index is deleted by the swap instead, writes to it during the copy are lost.
+func reindex(ctx context.Context, a indexAdmin) (string, error) {
+	exists, err := a.indexExists(ctx, indexAlias)
+	if err != nil {
+		return "", err
+	}
+	if !exists {
+		// nothing to copy
+		return indexName(indexVersion), ensureIndex(ctx, a)
+	}
+
+	current, err := a.aliasedIndex(ctx)
+	if err != nil {
+		return "", err
+	}
+	next := indexName(max(indexVersionOf(current)+1, indexVersion))
+	log.Printf("Reindexing %s into %s", current, next)
+
+	if err := a.createIndex(ctx, next); err != nil {
+		return "", err
+	}
+	n, err := a.copyProducts(ctx, current, next)
+	if err != nil {
+		return "", err
+	}
+	log.Printf("Copied %d products", n)
+
+	if err := a.swapAlias(ctx, current, next); err != nil {
+		return "", err
+	}
+	log.Printf("Alias %s points at %s", indexAlias, next)
+
+	if current != indexAlias {
+		n, err := a.copyProducts(ctx, current, next)
+		if err != nil {
+			return "", err
+		}
+		log.Printf("Caught up on %d products, %s can be deleted once %s is verified", n, current, next)
+	}
+	return next, nil
+}
+
+// reindexedDocument rebuilds a document copied into a new index,
+// so fields derived from others, like suggest, are filled in.
+func reindexedDocument(p productDocument) productDocument {
+	p.Suggest = nil
+	if !p.Deleted {
+		p.Suggest = newSuggestField(p.Name)
+	}
+	return p
+}
+
+// indexAliasActions are the actions of the atomic alias swap of swapAlias, in the format of the _aliases API
+func indexAliasActions(from, to string) []map[string]interface{} {
+	actions := []map[string]interface{}{
+		map[string]interface{}{"add": map[string]interface{}{"index": to, "alias": indexAlias}},
+	}
+	switch from {
+	case "":
+	case indexAlias:
+		actions = append(actions, map[string]interface{}{"remove_index": map[string]interface{}{"index": from}})
+	default:
+		actions = append(actions, map[string]interface{}{"remove": map[string]interface{}{"index": from, "alias": indexAlias}})
+	}
+	return actions
+}
+
+// aliasAction is an action of the _aliases API for the elastic.v5 client
+type aliasAction map[string]interface{}
+
+func (a aliasAction) Source() (interface{}, error) {
+	return map[string]interface{}(a), nil
+}
+
+func (r *elasticRepository) Reindex(ctx context.Context) (string, error) {
+	return reindex(ctx, r)
+}
+
+func (r *elasticRepository) UpgradeIndex(ctx context.Context) error {
+	return upgradeIndex(ctx, r)
+}
+
+func (r *elasticRepository) indexExists(ctx context.Context, name string) (bool, error) {
+	return r.clientdep.IndexExists(name).Do(ctx)
+}
+
+func (r *elasticRepository) aliasedIndex(ctx context.Context) (string, error) {
+	res, err := r.clientdep.Aliases().Index(indexAlias).Do(ctx)
+	if err != nil {
+		return "", err
+	}
+	return singleIndex(res.IndicesByAlias(indexAlias))
+}
+
+// singleIndex returns the index the catalog alias points at, or the unversioned index if there is no alias
+func singleIndex(indices []string) (string, error) {
+	switch len(indices) {
+	case 0:
+		return indexAlias, nil
+	case 1:
+		return indices[0], nil
+	default:
+		return "", fmt.Errorf("alias %s points at more than one index: %v", indexAlias, indices)
+	}
+}
+
+func (r *elasticRepository) createIndex(ctx context.Context, name string) error {
+	_, err := r.clientdep.CreateIndex(name).BodyJson(indexBody(false)).Do(ctx)
+	return err
+}
+
+func (r *elasticRepository) putMapping(ctx context.Context, index string) error {
+	_, err := r.clientdep.PutMapping().Index(index).Type("product").BodyJson(indexMapping(false)).Do(ctx)
+	if e, ok := err.(*elastic.Error); ok && e.Status == http.StatusBadRequest {
+		return fmt.Errorf("%w: %v", ErrIndexOutdated, err)
+	}
+	return err
+}
+
+func (r *elasticRepository) swapAlias(ctx context.Context, from, to string) error {
+	swap := r.clientdep.Alias()
+	for _, action := range indexAliasActions(from, to) {
+		swap = swap.Action(aliasAction(action))
+	}
+	_, err := swap.Do(ctx)
+	return err
+}
+
+func (r *elasticRepository) copyProducts(ctx context.Context, from, to string) (int, error) {
+	scroll := r.clientdep.Scroll(from).Type("product").Version(true).Size(500)
+	defer scroll.Clear(context.Background())
+
+	written := 0
+	for {
+		res, err := scroll.Do(ctx)
+		if err == io.EOF {
+			return written, nil
+		}
+		if err != nil {
+			return written, err
+		}
+
+		bulk := r.clientdep.Bulk()
+		for _, hit := range res.Hits.Hits {
+			var p productDocument
+			if err := json.Unmarshal(*hit.Source, &p); err != nil {
+				return written, err
+			}
+			req := elastic.NewBulkIndexRequest().Index(to).Type("product").Id(hit.Id).Doc(reindexedDocument(p))
+			if hit.Version != nil {
+				req = req.Version(*hit.Version).VersionType("external")
+			}
+			bulk.Add(req)
+		}
+		if bulk.NumberOfActions() == 0 {
+			continue
+		}
+
+		bres, err := bulk.Do(ctx)
+		if err != nil {
+			return written, err
+		}
+		n, err := countIndexed(bres)
+		written += n
+		if err != nil {
+			return written, err
+		}
+	}
+}
+
+// countIndexed returns how many documents of a bulk request were written,
+// version conflicts mean the target already has the document and aren't errors.
+func countIndexed(res *elastic.BulkResponse) (int, error) {
+	written := 0
+	for _, item := range res.Indexed() {
+		switch {
+		case item.Status == http.StatusConflict:
+		case item.Error != nil:
+			return written, fmt.Errorf("copying product %s: %s", item.Id, item.Error.Reason)
+		default:
+			written++
+		}
+	}
+	return written, nil
+}
@@ -0,0 +1,489 @@
+package catalog
+
+import (
+	"context"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// memoryRepository keeps products in memory, for tests and local demos.
+// It behaves like the other repositories: listings are newest first, page tokens hold the
+// sort values of the last product, deleted products only resolve by ID and facets ignore
+// their own filter. Search is simpler, words match whole or, for the last word of the
+// query, by prefix. There is no stemming or typo tolerance.
+// Everything is lost when the process exits.
+type memoryRepository struct {
+	mu       sync.RWMutex
+	products map[string]Product
+	// reservations are the quantities reservations took, by reservation ID and product ID
+	reservations map[string]map[string]uint64
+}
+
+// NewMemoryRepository returns an empty in-memory repository, it's safe for concurrent use
+func NewMemoryRepository() Repository {
+	return &memoryRepository{
+		products:     map[string]Product{},
+		reservations: map[string]map[string]uint64{},
+	}
+}
+
+func (r *memoryRepository) Close() {}
+
+// PutProduct inserts the product, or replaces it if it exists
+func (r *memoryRepository) PutProduct(ctx context.Context, p Product) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.products[p.ID] = storedProduct(p, false)
+	return nil
+}
+
+func (r *memoryRepository) GetProductByID(ctx context.Context, id string) (*Product, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	p, ok := r.products[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &p, nil
+}
+
+func (r *memoryRepository) ListProducts(ctx context.Context, skip uint64, take uint64, pageToken string, q ProductQuery) ([]Product, *Facets, error) {
+	// a listing has no scores, relevance falls back to newest
+	if q.Sort == SortRelevance {
+		q.Sort = SortNewest
+	}
+	return r.search(nil, skip, take, pageToken, q)
+}
+
+// ListProductsWithIDs returns the products in the order of ids, IDs that don't exist are left out
+func (r *memoryRepository) ListProductsWithIDs(ctx context.Context, ids []string) ([]Product, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var products []Product
+	for _, id := range ids {
+		if p, ok := r.products[id]; ok {
+			products = append(products, p)
+		}
+	}
+	return products, nil
+}
+
+// SearchProducts matches products containing all words of the query in the name or description,
+// the last word can be incomplete. Words found in the name score three times as much.
+func (r *memoryRepository) SearchProducts(ctx context.Context, query string, skip uint64, take uint64, pageToken string, q ProductQuery) ([]Product, *Facets, error) {
+	words := searchWords(query)
+	if len(words) == 0 {
+		return []Product{}, &Facets{Prices: []PriceBucket{}, Categories: []CategoryBucket{}}, nil
+	}
+	return r.search(words, skip, take, pageToken, q)
+}
+
+// SuggestProducts matches products with a word of the name starting with the prefix.
+// Names starting with the prefix come first, like in postgresRepository.
+func (r *memoryRepository) SuggestProducts(ctx context.Context, prefix string, size int) ([]Product, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	prefix = strings.ToLower(prefix)
+	var matches []Product
+	for _, p := range r.products {
+		if p.Deleted {
+			continue
+		}
+		if s := newSuggestField(strings.ToLower(p.Name)); s != nil {
+			for _, input := range s.Input {
+				if strings.HasPrefix(input, prefix) {
+					matches = append(matches, p)
+					break
+				}
+			}
+		}
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		a, b := matches[i], matches[j]
+		aFirst := strings.HasPrefix(strings.ToLower(a.Name), prefix)
+		bFirst := strings.HasPrefix(strings.ToLower(b.Name), prefix)
+		if aFirst != bFirst {
+			return aFirst
+		}
+		if a.Name != b.Name {
+			return a.Name < b.Name
+		}
+		return a.ID > b.ID
+	})
+
+	products := []Product{}
+	seen := map[string]bool{}
+	for _, p := range matches {
+		if len(products) == size {
+			break
+		}
+		if seen[p.Name] {
+			continue
+		}
+		seen[p.Name] = true
+		products = append(products, p)
+	}
+	return products, nil
+}
+
+// UpdateProduct replaces name, description, price and category of an existing product,
+// the stock and the deleted flag are kept. ErrNotFound is returned if there is no product with the ID.
+func (r *memoryRepository) UpdateProduct(ctx context.Context, p Product) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	existing, ok := r.products[p.ID]
+	if !ok {
+		return ErrNotFound
+	}
+	// the stock is only changed by SetStock and reservations, the deleted flag only by DeleteProduct
+	p.Stock = existing.Stock
+	r.products[p.ID] = storedProduct(p, existing.Deleted)
+	return nil
+}
+
+// DeleteProduct is a soft delete, the product still resolves by ID for orders that reference it
+func (r *memoryRepository) DeleteProduct(ctx context.Context, id string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	p, ok := r.products[id]
+	if !ok {
+		return ErrNotFound
+	}
+	p.Deleted = true
+	r.products[id] = p
+	return nil
+}
+
+// SetStock overwrites the stock level of the product
+func (r *memoryRepository) SetStock(ctx context.Context, id string, quantity uint64) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	p, ok := r.products[id]
+	if !ok {
+		return ErrNotFound
+	}
+	p.Stock = quantity
+	r.products[id] = p
+	return nil
+}
+
+// ReserveStock checks all items before taking any stock, the lock makes it atomic.
+// Products the reservation has taken stock of already are skipped.
+func (r *memoryRepository) ReserveStock(ctx context.Context, reservationID string, items []StockItem) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	reserved := r.reservations[reservationID]
+	for _, item := range items {
+		p, ok := r.products[item.ProductID]
+		if !ok {
+			return ErrNotFound
+		}
+		if _, ok := reserved[item.ProductID]; ok {
+			continue
+		}
+		if p.Deleted || p.Stock < item.Quantity {
+			return ErrInsufficientStock
+		}
+	}
+	if reservationID != "" && reserved == nil {
+		reserved = map[string]uint64{}
+		r.reservations[reservationID] = reserved
+	}
+	for _, item := range items {
+		if _, ok := reserved[item.ProductID]; ok {
+			continue
+		}
+		p := r.products[item.ProductID]
+		p.Stock -= item.Quantity
+		r.products[item.ProductID] = p
+		if reservationID != "" {
+			reserved[item.ProductID] = item.Quantity
+		}
+	}
+	return nil
+}
+
+// ReleaseStock puts back the quantities, or with a reservationID what the reservation took of the items
+func (r *memoryRepository) ReleaseStock(ctx context.Context, reservationID string, items []StockItem) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, item := range items {
+		if _, ok := r.products[item.ProductID]; !ok {
+			return ErrNotFound
+		}
+	}
+	reserved := r.reservations[reservationID]
+	for _, item := range items {
+		quantity := item.Quantity
+		if reservationID != "" {
+			var ok bool
+			if quantity, ok = reserved[item.ProductID]; !ok {
+				continue
+			}
+			delete(reserved, item.ProductID)
+		}
+		p := r.products[item.ProductID]
+		p.Stock += quantity
+		r.products[item.ProductID] = p
+	}
+	if reservationID != "" && len(reserved) == 0 {
+		delete(r.reservations, reservationID)
+	}
+	return nil
+}
+
+// ConfirmStock forgets what the reservation took of the items
+func (r *memoryRepository) ConfirmStock(ctx context.Context, reservationID string, items []StockItem) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	reserved := r.reservations[reservationID]
+	for _, item := range items {
+		delete(reserved, item.ProductID)
+	}
+	if len(reserved) == 0 {
+		delete(r.reservations, reservationID)
+	}
+	return nil
+}
+
+// storedProduct drops the fields that only belong to results
+func storedProduct(p Product, deleted bool) Product {
+	p.Deleted = deleted
+	p.Score = 0
+	p.Cursor = ""
+	return p
+}
+
+// searchWords splits text into lower case words, everything but letters and digits separates them
+func searchWords(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// matchScore returns the score of the product for the words of a query, 0 if it doesn't match
+func matchScore(p Product, words []string) float64 {
+	name, description := searchWords(p.Name), searchWords(p.Description)
+	score := 0.0
+	for i, w := range words {
+		prefix := i == len(words)-1
+		switch {
+		case containsWord(name, w, prefix):
+			score += 3
+		case containsWord(description, w, prefix):
+			score++
+		default:
+			return 0
+		}
+	}
+	return score
+}
+
+func containsWord(words []string, w string, prefix bool) bool {
+	for _, word := range words {
+		if word == w || (prefix && strings.HasPrefix(word, w)) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchesPrice(p Product, f ProductFilter) bool {
+	return (f.MinPrice == nil || p.Price >= *f.MinPrice) && (f.MaxPrice == nil || p.Price <= *f.MaxPrice)
+}
+
+func matchesCategory(p Product, f ProductFilter) bool {
+	if len(f.Categories) == 0 {
+		return true
+	}
+	for _, c := range f.Categories {
+		if p.Category == c {
+			return true
+		}
+	}
+	return false
+}
+
+// sortValue is the value a sort orders by before the ID, and if it's ascending.
+// It's nil for SortNewest, which only orders by ID.
+func sortValue(p Product, sort ProductSort) (value interface{}, asc bool) {
+	switch sort {
+	case SortNewest:
+		return nil, false
+	case SortPriceAsc:
+		return p.Price, true
+	case SortPriceDesc:
+		return p.Price, false
+	case SortName:
+		return p.Name, true
+	default:
+		return p.Score, false
+	}
+}
+
+// compareSortValues compares two values of the same sort, they are both float64 or both string
+func compareSortValues(a, b interface{}) int {
+	switch a := a.(type) {
+	case float64:
+		b := b.(float64)
+		switch {
+		case a < b:
+			return -1
+		case a > b:
+			return 1
+		}
+	case string:
+		return strings.Compare(a, b.(string))
+	}
+	return 0
+}
+
+// before reports if a product with value and id comes before one with otherValue and otherID,
+// the ID descending breaks ties
+func before(value interface{}, id string, otherValue interface{}, otherID string, asc bool) bool {
+	if value != nil {
+		if c := compareSortValues(value, otherValue); c != 0 {
+			return (c < 0) == asc
+		}
+	}
+	return id > otherID
+}
+
+// afterPageToken reports if the product comes after the product the page token was taken from
+func afterPageToken(p Product, sortValues []interface{}, q ProductQuery) (bool, error) {
+	value, asc := sortValue(p, q.Sort)
+	if value == nil {
+		id, ok := sortValues[0].(string)
+		if len(sortValues) != 1 || !ok {
+			return false, ErrInvalidPageToken
+		}
+		return before(nil, id, nil, p.ID, asc), nil
+	}
+
+	id, ok := sortValues[len(sortValues)-1].(string)
+	if len(sortValues) != 2 || !ok {
+		return false, ErrInvalidPageToken
+	}
+	tokenValue := sortValues[0]
+	switch value.(type) {
+	case float64:
+		_, ok = tokenValue.(float64)
+	case string:
+		_, ok = tokenValue.(string)
+	}
+	if !ok {
+		return false, ErrInvalidPageToken
+	}
+	return before(tokenValue, id, value, p.ID, asc), nil
+}
+
+// search lists products, or searches them if there are words.
+// The filters of q are applied to the products, the facets apply all filters but their own,
+// like the post filter and the filter aggregations of the elasticsearch repositories.
+func (r *memoryRepository) search(words []string, skip uint64, take uint64, pageToken string, q ProductQuery) ([]Product, *Facets, error) {
+	sortValues, err := decodePageToken(pageToken)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	r.mu.RLock()
+	var matches []Product
+	for _, p := range r.products {
+		if p.Deleted {
+			continue
+		}
+		if words != nil {
+			if p.Score = matchScore(p, words); p.Score == 0 {
+				continue
+			}
+		}
+		matches = append(matches, p)
+	}
+	r.mu.RUnlock()
+
+	facets := memoryFacets(matches, q)
+
+	var page []Product
+	for _, p := range matches {
+		if !matchesPrice(p, q.Filter) || !matchesCategory(p, q.Filter) {
+			continue
+		}
+		if sortValues != nil {
+			after, err := afterPageToken(p, sortValues, q)
+			if err != nil {
+				return nil, nil, err
+			}
+			if !after {
+				continue
+			}
+		}
+		page = append(page, p)
+	}
+	sort.Slice(page, func(i, j int) bool {
+		a, asc := sortValue(page[i], q.Sort)
+		b, _ := sortValue(page[j], q.Sort)
+		return before(a, page[i].ID, b, page[j].ID, asc)
+	})
+
+	// the offset only applies without a page token, like From and search_after
+	if sortValues == nil {
+		page = page[min(skip, uint64(len(page))):]
+	}
+	page = page[:min(take, uint64(len(page)))]
+
+	products := make([]Product, 0, len(page))
+	for _, p := range page {
+		value, _ := sortValue(p, q.Sort)
+		if value == nil {
+			p.Cursor = encodePageToken([]interface{}{p.ID})
+		} else {
+			p.Cursor = encodePageToken([]interface{}{value, p.ID})
+		}
+		products = append(products, p)
+	}
+	return products, facets, nil
+}
+
+// memoryFacets counts the matching products like postgresRepository.facets
+func memoryFacets(matches []Product, q ProductQuery) *Facets {
+	interval := priceInterval(q)
+	prices := map[int64]uint64{}
+	categories := map[string]uint64{}
+	for _, p := range matches {
+		if matchesCategory(p, q.Filter) {
+			prices[int64(math.Floor(p.Price/interval))]++
+		}
+		if matchesPrice(p, q.Filter) && p.Category != "" {
+			categories[p.Category]++
+		}
+	}
+
+	facets := &Facets{Prices: histogram(prices, interval), Categories: []CategoryBucket{}}
+	for c, n := range categories {
+		facets.Categories = append(facets.Categories, CategoryBucket{Category: c, Count: n})
+	}
+	sort.Slice(facets.Categories, func(i, j int) bool {
+		a, b := facets.Categories[i], facets.Categories[j]
+		if a.Count != b.Count {
+			return a.Count > b.Count
+		}
+		return a.Category < b.Category
+	})
+	if len(facets.Categories) > 50 {
+		facets.Categories = facets.Categories[:50]
+	}
+	return facets
+}
@@ -0,0 +1,40 @@
+package catalog
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+)
+
+var ErrInvalidPageToken = errors.New("invalid page token")
+
+// Page tokens are the sort values of the last hit of a page, which is what search_after needs
+// to continue after it. They are base64 encoded JSON, clients should treat them as opaque.
+// A token is only valid for the same kind of request that returned it, listing or searching.
+
+func encodePageToken(sortValues []interface{}) string {
+	if len(sortValues) == 0 {
+		return ""
+	}
+	b, err := json.Marshal(sortValues)
+	if err != nil {
+		return ""
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// decodePageToken returns nil for an empty token, that's the first page
+func decodePageToken(token string) ([]interface{}, error) {
+	if token == "" {
+		return nil, nil
+	}
+	b, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, ErrInvalidPageToken
+	}
+	var sortValues []interface{}
+	if err := json.Unmarshal(b, &sortValues); err != nil || len(sortValues) == 0 {
+		return nil, ErrInvalidPageToken
+	}
+	return sortValues, nil
+}
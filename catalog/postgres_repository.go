@@ -0,0 +1,604 @@
+package catalog
+
+import (
+	"context"
+	"database/sql"
+	_ "embed"
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/lib/pq"
+)
+
+// schema creates the products table if it doesn't exist,
+// like the search engine repositories create their index.
+//
+//go:embed up.sql
+var schema string
+
+// postgresRepository stores products in PostgreSQL and searches them with its full-text search,
+// for deployments that don't want to run a search cluster.
+// Search is less forgiving than elasticsearch, there is no typo tolerance.
+type postgresRepository struct {
+	db *sql.DB
+}
+
+// searchVector and suggestVector are the values of the search_vector and suggest_vector columns
+// for the name and description parameters of a statement
+const (
+	searchVector  = `setweight(to_tsvector('english', %[1]s), 'A') || setweight(to_tsvector('english', %[2]s), 'B')`
+	suggestVector = `to_tsvector('simple', %[1]s)`
+)
+
+// selectProducts selects products in the column order expected by scanProduct
+const selectProducts = `SELECT id, name, description, price::numeric::float8, category, stock, deleted FROM products `
+
+// NewPostgresRepository connects to the database and creates the products table if it doesn't exist
+func NewPostgresRepository(url string) (Repository, error) {
+	db, err := sql.Open("postgres", url)
+	if err != nil {
+		return nil, err
+	}
+	if err := db.Ping(); err != nil {
+		return nil, err
+	}
+	if _, err := db.Exec(schema); err != nil {
+		return nil, err
+	}
+	return &postgresRepository{db}, nil
+}
+
+func (r *postgresRepository) Close() {
+	r.db.Close()
+}
+
+// PutProduct inserts the product, or replaces it if it exists
+func (r *postgresRepository) PutProduct(ctx context.Context, p Product) error {
+	_, err := r.db.ExecContext(
+		ctx,
+		`INSERT INTO products (id, name, description, price, category, stock, deleted, search_vector, suggest_vector)
+		VALUES ($1, $2, $3, $4::numeric::money, $5, $6, FALSE, `+fmt.Sprintf(searchVector, "$2", "$3")+`, `+fmt.Sprintf(suggestVector, "$2")+`)
+		ON CONFLICT (id) DO UPDATE SET
+			name = EXCLUDED.name,
+			description = EXCLUDED.description,
+			price = EXCLUDED.price,
+			category = EXCLUDED.category,
+			stock = EXCLUDED.stock,
+			deleted = EXCLUDED.deleted,
+			search_vector = EXCLUDED.search_vector,
+			suggest_vector = EXCLUDED.suggest_vector`,
+		p.ID,
+		p.Name,
+		p.Description,
+		p.Price,
+		p.Category,
+		p.Stock,
+	)
+	return err
+}
+
+func (r *postgresRepository) GetProductByID(ctx context.Context, id string) (*Product, error) {
+	p, err := scanProduct(r.db.QueryRowContext(ctx, selectProducts+`WHERE id = $1`, id))
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// scanner is a *sql.Row or *sql.Rows
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanProduct(row scanner, extra ...interface{}) (*Product, error) {
+	p := &Product{}
+	dest := append([]interface{}{&p.ID, &p.Name, &p.Description, &p.Price, &p.Category, &p.Stock, &p.Deleted}, extra...)
+	if err := row.Scan(dest...); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// ListProducts pages with keyset pagination if there is a page token, the tokens hold
+// the sort values of the last product like the search_after tokens of elasticsearch.
+func (r *postgresRepository) ListProducts(ctx context.Context, skip uint64, take uint64, pageToken string, q ProductQuery) ([]Product, *Facets, error) {
+	// a listing has no scores, relevance falls back to newest
+	if q.Sort == SortRelevance {
+		q.Sort = SortNewest
+	}
+	return r.search(ctx, "", skip, take, pageToken, q)
+}
+
+// ListProductsWithIDs returns the products in the order of ids, IDs that don't exist are left out
+func (r *postgresRepository) ListProductsWithIDs(ctx context.Context, ids []string) ([]Product, error) {
+	rows, err := r.db.QueryContext(ctx, selectProducts+`WHERE id = ANY($1)`, pq.Array(ids))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	byID := map[string]Product{}
+	for rows.Next() {
+		p, err := scanProduct(rows)
+		if err != nil {
+			return nil, err
+		}
+		byID[p.ID] = *p
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var products []Product
+	for _, id := range ids {
+		if p, ok := byID[id]; ok {
+			products = append(products, p)
+		}
+	}
+	return products, nil
+}
+
+// SearchProducts matches products containing all words of the query, the last word can be incomplete.
+// They are ranked by ts_rank, matches in the name rank higher.
+func (r *postgresRepository) SearchProducts(ctx context.Context, query string, skip uint64, take uint64, pageToken string, q ProductQuery) ([]Product, *Facets, error) {
+	tsquery := prefixTSQuery(query)
+	if tsquery == "" {
+		return []Product{}, &Facets{Prices: []PriceBucket{}, Categories: []CategoryBucket{}}, nil
+	}
+	return r.search(ctx, tsquery, skip, take, pageToken, q)
+}
+
+// prefixTSQuery turns user input into a tsquery matching all its words, with a prefix match for the last one.
+// Everything but letters and digits is dropped, so the input can't contain tsquery operators.
+func prefixTSQuery(input string) string {
+	words := strings.FieldsFunc(input, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	if len(words) == 0 {
+		return ""
+	}
+	words[len(words)-1] += ":*"
+	return strings.Join(words, " & ")
+}
+
+// SuggestProducts matches products with a word of the name starting with the prefix.
+// Names starting with the prefix come first.
+func (r *postgresRepository) SuggestProducts(ctx context.Context, prefix string, size int) ([]Product, error) {
+	tsquery := prefixTSQuery(prefix)
+	if tsquery == "" {
+		return []Product{}, nil
+	}
+	rows, err := r.db.QueryContext(
+		ctx,
+		selectProducts+`WHERE NOT deleted AND suggest_vector @@ to_tsquery('simple', $1)
+		ORDER BY left(lower(name), length($2::text)) = lower($2::text) DESC, name COLLATE "C", id DESC
+		LIMIT $3`,
+		tsquery,
+		prefix,
+		size,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	products := []Product{}
+	seen := map[string]bool{}
+	for rows.Next() {
+		p, err := scanProduct(rows)
+		if err != nil {
+			return nil, err
+		}
+		if seen[p.Name] {
+			continue
+		}
+		seen[p.Name] = true
+		products = append(products, *p)
+	}
+	return products, rows.Err()
+}
+
+// sqlQuery builds the WHERE clause of a listing or a search, it numbers the parameters it adds
+type sqlQuery struct {
+	conditions []string
+	args       []interface{}
+}
+
+// arg adds a parameter and returns its placeholder
+func (b *sqlQuery) arg(v interface{}) string {
+	b.args = append(b.args, v)
+	return fmt.Sprintf("$%d", len(b.args))
+}
+
+func (b *sqlQuery) where(condition string) {
+	b.conditions = append(b.conditions, condition)
+}
+
+func (b *sqlQuery) clause() string {
+	if len(b.conditions) == 0 {
+		return ""
+	}
+	return "WHERE " + strings.Join(b.conditions, " AND ") + " "
+}
+
+// clone copies the conditions and the parameters, so the facets can add different filters
+func (b *sqlQuery) clone() *sqlQuery {
+	return &sqlQuery{
+		conditions: append([]string{}, b.conditions...),
+		args:       append([]interface{}{}, b.args...),
+	}
+}
+
+func (b *sqlQuery) priceFilter(f ProductFilter) {
+	if f.MinPrice != nil {
+		b.where("price >= " + b.arg(*f.MinPrice) + "::numeric::money")
+	}
+	if f.MaxPrice != nil {
+		b.where("price <= " + b.arg(*f.MaxPrice) + "::numeric::money")
+	}
+}
+
+func (b *sqlQuery) categoryFilter(f ProductFilter) {
+	if len(f.Categories) > 0 {
+		b.where("category = ANY(" + b.arg(pq.Array(f.Categories)) + ")")
+	}
+}
+
+// sortColumn is the expression a sort orders by before the ID, and if it's ascending.
+// The expressions are selected too, they are the values in the page tokens.
+func sortColumn(sort ProductSort, rank string) (expr string, asc bool) {
+	switch sort {
+	case SortNewest:
+		return "", false
+	case SortPriceAsc:
+		return "price::numeric::float8", true
+	case SortPriceDesc:
+		return "price::numeric::float8", false
+	case SortName:
+		// byte order like the keyword sort of elasticsearch
+		return `name COLLATE "C"`, true
+	default:
+		return rank, false
+	}
+}
+
+// search lists products, or searches them if tsquery isn't empty.
+// The filters of q are applied to the products, the facets apply all filters but their own,
+// like the post filter and the filter aggregations of the elasticsearch repositories.
+func (r *postgresRepository) search(ctx context.Context, tsquery string, skip uint64, take uint64, pageToken string, q ProductQuery) ([]Product, *Facets, error) {
+	base := &sqlQuery{}
+	base.where("NOT deleted")
+	rank := "0::float8"
+	if tsquery != "" {
+		query := "to_tsquery('english', " + base.arg(tsquery) + ")"
+		base.where("search_vector @@ " + query)
+		rank = "ts_rank(search_vector, " + query + ")::float8"
+	}
+
+	products, err := r.page(ctx, base.clone(), rank, skip, take, pageToken, q)
+	if err != nil {
+		return nil, nil, err
+	}
+	facets, err := r.facets(ctx, base, q)
+	if err != nil {
+		return nil, nil, err
+	}
+	return products, facets, nil
+}
+
+func (r *postgresRepository) page(ctx context.Context, b *sqlQuery, rank string, skip uint64, take uint64, pageToken string, q ProductQuery) ([]Product, error) {
+	b.priceFilter(q.Filter)
+	b.categoryFilter(q.Filter)
+
+	expr, asc := sortColumn(q.Sort, rank)
+	sortValues, err := decodePageToken(pageToken)
+	if err != nil {
+		return nil, err
+	}
+	if sortValues != nil {
+		// the page continues after the product with the sort values of the token,
+		// in the sort order, with the ID descending to break ties
+		if expr == "" {
+			id, ok := sortValues[0].(string)
+			if len(sortValues) != 1 || !ok {
+				return nil, ErrInvalidPageToken
+			}
+			b.where("id < " + b.arg(id))
+		} else {
+			id, ok := sortValues[len(sortValues)-1].(string)
+			if len(sortValues) != 2 || !ok {
+				return nil, ErrInvalidPageToken
+			}
+			value := b.arg(sortValues[0])
+			op := "<"
+			if asc {
+				op = ">"
+			}
+			b.where(fmt.Sprintf("(%[1]s %[2]s %[3]s OR (%[1]s = %[3]s AND id < %[4]s))", expr, op, value, b.arg(id)))
+		}
+	}
+
+	order := "id DESC"
+	if expr != "" {
+		direction := "DESC"
+		if asc {
+			direction = "ASC"
+		}
+		order = expr + " " + direction + ", " + order
+	}
+	offset := ""
+	if sortValues == nil && skip > 0 {
+		offset = " OFFSET " + b.arg(skip)
+	}
+	sortSelect := "''"
+	if expr != "" {
+		sortSelect = expr
+	}
+
+	rows, err := r.db.QueryContext(
+		ctx,
+		`SELECT id, name, description, price::numeric::float8, category, stock, deleted, `+rank+`, `+sortSelect+` FROM products `+
+			b.clause()+"ORDER BY "+order+" LIMIT "+b.arg(take)+offset,
+		b.args...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	products := []Product{}
+	for rows.Next() {
+		var score float64
+		var sortValue interface{}
+		p, err := scanProduct(rows, &score, &sortValue)
+		if err != nil {
+			return nil, err
+		}
+		p.Score = score
+		if expr == "" {
+			p.Cursor = encodePageToken([]interface{}{p.ID})
+		} else {
+			if b, ok := sortValue.([]byte); ok {
+				sortValue = string(b)
+			}
+			p.Cursor = encodePageToken([]interface{}{sortValue, p.ID})
+		}
+		products = append(products, *p)
+	}
+	return products, rows.Err()
+}
+
+func (r *postgresRepository) facets(ctx context.Context, base *sqlQuery, q ProductQuery) (*Facets, error) {
+	facets := &Facets{Prices: []PriceBucket{}, Categories: []CategoryBucket{}}
+	interval := priceInterval(q)
+
+	prices := base.clone()
+	prices.categoryFilter(q.Filter)
+	rows, err := r.db.QueryContext(
+		ctx,
+		`SELECT floor(price::numeric::float8 / `+prices.arg(interval)+`) AS bucket, count(*) FROM products `+
+			prices.clause()+`GROUP BY bucket ORDER BY bucket`,
+		prices.args...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	counts := map[int64]uint64{}
+	for rows.Next() {
+		var bucket float64
+		var count uint64
+		if err := rows.Scan(&bucket, &count); err != nil {
+			return nil, err
+		}
+		counts[int64(bucket)] = count
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	facets.Prices = histogram(counts, interval)
+
+	categories := base.clone()
+	categories.priceFilter(q.Filter)
+	categories.where("category <> ''")
+	rows, err = r.db.QueryContext(
+		ctx,
+		`SELECT category, count(*) FROM products `+categories.clause()+
+			`GROUP BY category ORDER BY count(*) DESC, category LIMIT 50`,
+		categories.args...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var b CategoryBucket
+		if err := rows.Scan(&b.Category, &b.Count); err != nil {
+			return nil, err
+		}
+		facets.Categories = append(facets.Categories, b)
+	}
+	return facets, rows.Err()
+}
+
+// maxHistogramBuckets is how many buckets histogram fills in at most, elasticsearch has a similar limit
+const maxHistogramBuckets = 10000
+
+// histogram turns the counts of the bucket numbers into price buckets.
+// The buckets between the lowest and highest price are all returned, empty ones with a count of 0,
+// the same as the histogram aggregation of elasticsearch. If that's too many buckets for the interval
+// only the ones with products are returned.
+func histogram(counts map[int64]uint64, interval float64) []PriceBucket {
+	buckets := []PriceBucket{}
+	if len(counts) == 0 {
+		return buckets
+	}
+	keys := make([]int64, 0, len(counts))
+	for k := range counts {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+
+	if keys[len(keys)-1]-keys[0] >= maxHistogramBuckets {
+		for _, k := range keys {
+			buckets = append(buckets, PriceBucket{From: float64(k) * interval, To: float64(k+1) * interval, Count: counts[k]})
+		}
+		return buckets
+	}
+	for k := keys[0]; k <= keys[len(keys)-1]; k++ {
+		from := float64(k) * interval
+		buckets = append(buckets, PriceBucket{
+			From:  from,
+			To:    from + interval,
+			Count: counts[k],
+		})
+	}
+	return buckets
+}
+
+// UpdateProduct replaces name, description, price and category of an existing product,
+// the stock and the deleted flag are kept. ErrNotFound is returned if there is no product with the ID.
+func (r *postgresRepository) UpdateProduct(ctx context.Context, p Product) error {
+	res, err := r.db.ExecContext(
+		ctx,
+		`UPDATE products SET
+			name = $2,
+			description = $3,
+			price = $4::numeric::money,
+			category = $5,
+			search_vector = `+fmt.Sprintf(searchVector, "$2", "$3")+`,
+			suggest_vector = `+fmt.Sprintf(suggestVector, "$2")+`
+		WHERE id = $1`,
+		p.ID,
+		p.Name,
+		p.Description,
+		p.Price,
+		p.Category,
+	)
+	return notFoundIfNoRows(res, err)
+}
+
+// DeleteProduct is a soft delete, the product still resolves by ID for orders that reference it
+func (r *postgresRepository) DeleteProduct(ctx context.Context, id string) error {
+	res, err := r.db.ExecContext(ctx, `UPDATE products SET deleted = TRUE WHERE id = $1`, id)
+	return notFoundIfNoRows(res, err)
+}
+
+// SetStock overwrites the stock level of the product
+func (r *postgresRepository) SetStock(ctx context.Context, id string, quantity uint64) error {
+	res, err := r.db.ExecContext(ctx, `UPDATE products SET stock = $2 WHERE id = $1`, id, quantity)
+	return notFoundIfNoRows(res, err)
+}
+
+// ReserveStock takes the quantities in one transaction. The update only matches if there is enough stock,
+// and the row lock it takes makes concurrent reservations of the product wait for this one to commit.
+// items are sorted by ID, so transactions lock products in the same order and can't deadlock.
+// With a reservationID every product is recorded in stock_reservations first, a product the reservation
+// has a row for already is skipped. A concurrent reservation with the same ID waits for the row of the other.
+func (r *postgresRepository) ReserveStock(ctx context.Context, reservationID string, items []StockItem) error {
+	return r.updateStock(ctx, items, func(tx *sql.Tx, item StockItem) error {
+		if reservationID != "" {
+			res, err := tx.ExecContext(
+				ctx,
+				`INSERT INTO stock_reservations (reservation_id, product_id, quantity) VALUES ($1, $2, $3)
+				ON CONFLICT DO NOTHING`,
+				reservationID,
+				item.ProductID,
+				item.Quantity,
+			)
+			if err != nil {
+				return err
+			}
+			n, err := res.RowsAffected()
+			if err != nil {
+				return err
+			}
+			if n == 0 {
+				// reserved by an earlier call
+				return nil
+			}
+		}
+		res, err := tx.ExecContext(
+			ctx,
+			`UPDATE products SET stock = stock - $2 WHERE id = $1 AND NOT deleted AND stock >= $2`,
+			item.ProductID,
+			item.Quantity,
+		)
+		if err := notFoundIfNoRows(res, err); err != ErrNotFound {
+			return err
+		}
+		var exists bool
+		if err := tx.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM products WHERE id = $1)`, item.ProductID).Scan(&exists); err != nil {
+			return err
+		}
+		if exists {
+			return ErrInsufficientStock
+		}
+		return ErrNotFound
+	})
+}
+
+// ReleaseStock puts the quantities back in one transaction, deleted products get them back too.
+// With a reservationID it deletes the rows of the reservation and puts back their quantities.
+func (r *postgresRepository) ReleaseStock(ctx context.Context, reservationID string, items []StockItem) error {
+	return r.updateStock(ctx, items, func(tx *sql.Tx, item StockItem) error {
+		quantity := item.Quantity
+		if reservationID != "" {
+			err := tx.QueryRowContext(
+				ctx,
+				`DELETE FROM stock_reservations WHERE reservation_id = $1 AND product_id = $2 RETURNING quantity`,
+				reservationID,
+				item.ProductID,
+			).Scan(&quantity)
+			if err == sql.ErrNoRows {
+				// the reservation took nothing of the product
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+		}
+		res, err := tx.ExecContext(ctx, `UPDATE products SET stock = stock + $2 WHERE id = $1`, item.ProductID, quantity)
+		return notFoundIfNoRows(res, err)
+	})
+}
+
+// ConfirmStock deletes the rows of the reservation
+func (r *postgresRepository) ConfirmStock(ctx context.Context, reservationID string, items []StockItem) error {
+	_, err := r.db.ExecContext(ctx, `DELETE FROM stock_reservations WHERE reservation_id = $1`, reservationID)
+	return err
+}
+
+// updateStock runs update for every item in a transaction, it's rolled back if one fails
+func (r *postgresRepository) updateStock(ctx context.Context, items []StockItem, update func(tx *sql.Tx, item StockItem) error) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	for _, item := range items {
+		if err := update(tx, item); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func notFoundIfNoRows(res sql.Result, err error) error {
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
@@ -0,0 +1,79 @@
+package catalog
+
+import "errors"
+
+var (
+	ErrInvalidSort   = errors.New("invalid product sort")
+	ErrInvalidFilter = errors.New("invalid product filter")
+)
+
+// ProductSort is the order of listed and searched products.
+type ProductSort string
+
+const (
+	// SortRelevance orders search results by score. It's the default, listings without
+	// a search query have no score and fall back to SortNewest.
+	SortRelevance ProductSort = ""
+	SortNewest    ProductSort = "newest"
+	SortPriceAsc  ProductSort = "price_asc"
+	SortPriceDesc ProductSort = "price_desc"
+	SortName      ProductSort = "name"
+)
+
+// ParseProductSort converts a string to a ProductSort, returning ErrInvalidSort if it
+// is not one of the known sorts.
+func ParseProductSort(s string) (ProductSort, error) {
+	switch sort := ProductSort(s); sort {
+	case SortRelevance, SortNewest, SortPriceAsc, SortPriceDesc, SortName:
+		return sort, nil
+	}
+	return "", ErrInvalidSort
+}
+
+// ProductFilter narrows down listed and searched products.
+// The zero value matches every product.
+type ProductFilter struct {
+	// MinPrice and MaxPrice are inclusive, nil means unbounded
+	MinPrice *float64
+	MaxPrice *float64
+	// Categories matches products in any of them, empty matches all categories
+	Categories []string
+}
+
+func (f ProductFilter) validate() error {
+	if f.MinPrice != nil && f.MaxPrice != nil && *f.MinPrice > *f.MaxPrice {
+		return ErrInvalidFilter
+	}
+	return nil
+}
+
+// DefaultPriceInterval is the width of the price histogram buckets if the query doesn't set one
+const DefaultPriceInterval = 10.0
+
+// ProductQuery is how listed and searched products are filtered, sorted and faceted
+type ProductQuery struct {
+	Filter ProductFilter
+	Sort   ProductSort
+	// PriceInterval is the width of the price histogram buckets, DefaultPriceInterval if 0
+	PriceInterval float64
+}
+
+// Facets are aggregation buckets over all the products matching a search or listing,
+// not just the returned page. Each facet ignores its own filter but applies the others,
+// so a storefront can show how many products the other options of a facet would match.
+type Facets struct {
+	Prices     []PriceBucket    `json:"prices"`
+	Categories []CategoryBucket `json:"categories"`
+}
+
+// PriceBucket counts the products priced from From up to, but not including, To
+type PriceBucket struct {
+	From  float64 `json:"from"`
+	To    float64 `json:"to"`
+	Count uint64  `json:"count"`
+}
+
+type CategoryBucket struct {
+	Category string `json:"category"`
+	Count    uint64 `json:"count"`
+}
@@ -6,6 +6,7 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"strings"
 
 	"gopkg.in/olivere/elastic.v5"
 )
@@ -14,7 +15,61 @@ type productDocument struct {
 	Name        string  `json:"name"`
 	Description string  `json:"description"`
 	Price       float64 `json:"price"`
+	Category    string  `json:"category,omitempty"`
+	// Stock is left out of partial updates when it's 0, it's only changed by SetStock and the stock scripts
+	Stock uint64 `json:"stock,omitempty"`
 	// WARN: why is price string?
+	// Deleted products are kept for historical orders but left out of listings and search
+	Deleted bool `json:"deleted"`
+	// Suggest feeds the completion suggester, it's removed when the product is deleted
+	Suggest *suggestField `json:"suggest,omitempty"`
+	// Reservations are the quantities stock reservations took, by reservation ID. Only the stock scripts
+	// change them, they're kept here so a reindex copies them.
+	Reservations map[string]uint64 `json:"reservations,omitempty"`
+}
+
+// productUpdate is the partial document UpdateProduct writes. Unlike productDocument nothing is omitted,
+// so clearing the category or the suggestions reaches the index, null removes the field.
+// The deleted flag isn't part of it, an update must not bring back a product deleted in the meantime.
+type productUpdate struct {
+	Name        string        `json:"name"`
+	Description string        `json:"description"`
+	Price       float64       `json:"price"`
+	Category    *string       `json:"category"`
+	Suggest     *suggestField `json:"suggest"`
+}
+
+func newProductUpdate(p Product) productUpdate {
+	u := productUpdate{
+		Name:        p.Name,
+		Description: p.Description,
+		Price:       p.Price,
+		Suggest:     newSuggestField(p.Name),
+	}
+	// an empty category is stored as a missing field, like PutProduct does
+	if p.Category != "" {
+		u.Category = &p.Category
+	}
+	return u
+}
+
+// suggestField is the value of a completion field.
+// The suggester only matches the beginning of an input, so every word of the name
+// starts an input, that way "head" suggests "Wireless Headphones" too.
+type suggestField struct {
+	Input []string `json:"input"`
+}
+
+func newSuggestField(name string) *suggestField {
+	words := strings.Fields(name)
+	if len(words) == 0 {
+		return nil
+	}
+	inputs := make([]string, 0, len(words))
+	for i := range words {
+		inputs = append(inputs, strings.Join(words[i:], " "))
+	}
+	return &suggestField{Input: inputs}
 }
 
 // TODO: using depricated elastic search client
@@ -23,20 +78,41 @@ type productDocument struct {
 
 var ErrNotFound = errors.New("Entity not found")
 
+// ErrUnsupportedVersion is returned when connecting to a search engine the repository doesn't work with.
+// Retrying doesn't help, the cluster or SEARCH_BACKEND has to change.
+var ErrUnsupportedVersion = errors.New("unsupported search engine version")
+
 type Repository interface {
 	Close()
 	PutProduct(ctx context.Context, p Product) error
 	GetProductByID(ctx context.Context, id string) (*Product, error)
-	ListProducts(ctx context.Context, skip uint64, take uint64) ([]Product, error)
+	// ListProducts and SearchProducts page with skip, or with pageToken if it's set.
+	// Every returned product has its Cursor set, which is the page token to continue after it.
+	// They also return the facets of all matching products.
+	ListProducts(ctx context.Context, skip uint64, take uint64, pageToken string, q ProductQuery) ([]Product, *Facets, error)
 	ListProductsWithIDs(ctx context.Context, ids []string) ([]Product, error)
-	SearchProducts(ctx context.Context, query string, skip uint64, take uint64) ([]Product, error)
+	SearchProducts(ctx context.Context, query string, skip uint64, take uint64, pageToken string, q ProductQuery) ([]Product, *Facets, error)
+	// SuggestProducts returns up to size products with a name starting with prefix, or with a word of it
+	SuggestProducts(ctx context.Context, prefix string, size int) ([]Product, error)
+	// UpdateProduct replaces the product but keeps its stock, which is only changed by the stock methods,
+	// and its deleted flag, so it can't undo a concurrent DeleteProduct
+	UpdateProduct(ctx context.Context, p Product) error
+	DeleteProduct(ctx context.Context, id string) error
+	SetStock(ctx context.Context, id string, quantity uint64) error
+	// ReserveStock takes the quantities of all items or none of them. It returns ErrInsufficientStock
+	// if a product doesn't have enough stock or is deleted, and ErrNotFound if one doesn't exist.
+	// Every product is in items once and items are sorted by product ID, see mergeStockItems.
+	// With a reservationID what's taken is recorded, products the reservation has taken stock of already
+	// are skipped, see stock.go.
+	ReserveStock(ctx context.Context, reservationID string, items []StockItem) error
+	// ReleaseStock puts the quantities back, items are like in ReserveStock.
+	// With a reservationID it puts back what the reservation took instead and drops its record.
+	ReleaseStock(ctx context.Context, reservationID string, items []StockItem) error
+	// ConfirmStock drops the record of the reservation of the items, the stock stays taken
+	ConfirmStock(ctx context.Context, reservationID string, items []StockItem) error
 }
 
 type elasticRepository struct {
-	// client *elasticsearch.Client
-	// tes    *elasticsearch.TypedClient
-	// // // depricated
-	// // renamed to clientdep for continuation with the vid
 	clientdep *elastic.Client
 }
 
@@ -76,13 +152,16 @@ func (r *elasticRepository) PutProduct(ctx context.Context, p Product) error {
 	// and set body of document from productDocument as fields and formatted as json
 	// and execute the request through Do() ctx used for controlling the lifetime of the req
 	_, err := r.clientdep.Index().
-		Index("catalog").
+		Index(indexAlias).
 		Type("product").
 		Id(p.ID).
 		BodyJson(productDocument{
 			Name:        p.Name,
 			Description: p.Description,
 			Price:       p.Price,
+			Category:    p.Category,
+			Stock:       p.Stock,
+			Suggest:     newSuggestField(p.Name),
 		}).Do(ctx)
 	return err
 }
@@ -145,7 +224,7 @@ func (r *elasticRepository) GetProductByID(ctx context.Context, id string) (*Pro
 	// }, nil
 
 	// Depricated
-	res, err := r.clientdep.Get().Index("catalog").Type("product").Id(id).Do(ctx)
+	res, err := r.clientdep.Get().Index(indexAlias).Type("product").Id(id).Do(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -162,10 +241,17 @@ func (r *elasticRepository) GetProductByID(ctx context.Context, id string) (*Pro
 		Name:        p.Name,
 		Description: p.Description,
 		Price:       p.Price,
+		Category:    p.Category,
+		Stock:       p.Stock,
+		Deleted:     p.Deleted,
 	}, nil
 }
 
-func (r *elasticRepository) ListProducts(ctx context.Context, skip uint64, take uint64) ([]Product, error) {
+// ListProducts returns products sorted by ID descending, IDs are KSUIDs so that's newest first.
+// q can filter them and sort them differently.
+// If pageToken is set, the page starts after the product it came from using search_after instead of From.
+// Unlike From it doesn't get slower with depth and isn't capped at 10,000 hits.
+func (r *elasticRepository) ListProducts(ctx context.Context, skip uint64, take uint64, pageToken string, q ProductQuery) ([]Product, *Facets, error) {
 	// // Create the search query
 	// // SearchRequest configures the Search API request.
 	// query := map[string]interface{}{
@@ -226,31 +312,18 @@ func (r *elasticRepository) ListProducts(ctx context.Context, skip uint64, take
 
 	// return products, nil
 
-	// Depricated
-	res, err := r.clientdep.Search().
-		Index("catalog").
-		Type("product").
-		Query(elastic.NewMatchAllQuery()).
-		From(int(skip)).
-		Size(int(take)).Do(ctx)
-	if err != nil {
-		return nil, err
-	}
-
-	products := []Product{}
-	for _, hit := range res.Hits.Hits {
-		p := productDocument{}
-		if err = json.Unmarshal(*hit.Source, &p); err == nil {
-			products = append(products, Product{
-				ID:          hit.Id,
-				Name:        p.Name,
-				Description: p.Description,
-				Price:       p.Price,
-			})
-		}
+	// a listing has no scores, relevance falls back to newest
+	if q.Sort == SortRelevance {
+		q.Sort = SortNewest
 	}
-
-	return products, nil
+	return r.search(
+		ctx,
+		elastic.NewBoolQuery().MustNot(elastic.NewTermQuery("deleted", true)),
+		skip,
+		take,
+		pageToken,
+		q,
+	)
 }
 
 func (r *elasticRepository) ListProductsWithIDs(ctx context.Context, ids []string) ([]Product, error) {
@@ -311,7 +384,7 @@ func (r *elasticRepository) ListProductsWithIDs(ctx context.Context, ids []strin
 		items = append(
 			items,
 			elastic.NewMultiGetItem().
-				Index("catalog").
+				Index(indexAlias).
 				Type("product").
 				Id(id),
 		)
@@ -328,6 +401,10 @@ func (r *elasticRepository) ListProductsWithIDs(ctx context.Context, ids []strin
 	var products []Product
 
 	for _, doc := range resp.Docs {
+		// IDs that don't exist are left out
+		if !doc.Found {
+			continue
+		}
 		var p productDocument
 		if err := json.Unmarshal(*doc.Source, &p); err != nil {
 			return nil, fmt.Errorf("Error deconding response: %w", err)
@@ -337,6 +414,9 @@ func (r *elasticRepository) ListProductsWithIDs(ctx context.Context, ids []strin
 				Name:        p.Name,
 				Description: p.Description,
 				Price:       p.Price,
+				Category:    p.Category,
+				Stock:       p.Stock,
+				Deleted:     p.Deleted,
 			})
 		}
 	}
@@ -344,7 +424,33 @@ func (r *elasticRepository) ListProductsWithIDs(ctx context.Context, ids []strin
 	return products, nil
 }
 
-func (r *elasticRepository) SearchProducts(ctx context.Context, query string, skip uint64, take uint64) ([]Product, error) {
+// searchQuery is the full-text query behind SearchProducts.
+// Every clause searches name and description, matches in the name count three times as much.
+//   - a fuzzy match finds products despite typos, all words of the query have to match
+//   - a phrase match ranks products that contain the query as is above the fuzzy matches
+//   - a phrase prefix match lets the last word be incomplete, for search as you type
+//
+// The query is analyzed like the fields, so matching isn't case-sensitive.
+func searchQuery(query string) elastic.Query {
+	fields := []string{"name^3", "description"}
+	return elastic.NewBoolQuery().
+		Should(
+			elastic.NewMultiMatchQuery(query, fields...).
+				Type("best_fields").
+				Operator("and").
+				Fuzziness("AUTO").
+				PrefixLength(1),
+			elastic.NewMultiMatchQuery(query, fields...).
+				Type("phrase").
+				Boost(2),
+			elastic.NewMultiMatchQuery(query, fields...).
+				Type("phrase_prefix"),
+		).
+		MinimumNumberShouldMatch(1).
+		MustNot(elastic.NewTermQuery("deleted", true))
+}
+
+func (r *elasticRepository) SearchProducts(ctx context.Context, query string, skip uint64, take uint64, pageToken string, q ProductQuery) ([]Product, *Facets, error) {
 	// // official
 	// searchQuery := map[string]interface{}{
 	// 	"query": map[string]interface{}{
@@ -411,22 +517,217 @@ func (r *elasticRepository) SearchProducts(ctx context.Context, query string, sk
 	// 	Query(elastic.NewMultiMatchQuery(query, "name", "description")).
 	// 	From(int(skip)).Size(int(take)).
 	// 	Do(ctx)
+	return r.search(ctx, searchQuery(query), skip, take, pageToken, q)
+}
+
+// SuggestProducts uses the completion suggester, it's served from an in memory structure
+// so it's much faster than a search and fits the latency budget of a search box.
+// A document is suggested at most once, products with the same name are merged.
+func (r *elasticRepository) SuggestProducts(ctx context.Context, prefix string, size int) ([]Product, error) {
 	res, err := r.clientdep.Search().
-		Index("catalog").
+		Index(indexAlias).
 		Type("product").
-		Query(elastic.NewBoolQuery().Should(
-			elastic.NewWildcardQuery("description", query+"*"),
-			elastic.NewWildcardQuery("name", query+"*"),
-		)).
-		From(int(skip)).Size(int(take)).
+		SearchSource(suggestSource(prefix, size)).
 		Do(ctx)
 	if err != nil {
 		return nil, err
 	}
+	return productsFromSuggestions(res)
+}
 
-	var products []Product
+// suggestSource is the search of SuggestProducts, it only runs the completion suggester
+func suggestSource(prefix string, size int) *elastic.SearchSource {
+	return elastic.NewSearchSource().
+		Suggester(elastic.NewCompletionSuggester("products").
+			Field("suggest").
+			Prefix(prefix).
+			Size(size)).
+		Size(0)
+}
+
+// productsFromSuggestions converts the options of the completion suggester to products
+func productsFromSuggestions(res *elastic.SearchResult) ([]Product, error) {
+	products := []Product{}
+	seen := map[string]bool{}
+	for _, suggestion := range res.Suggest["products"] {
+		for _, option := range suggestion.Options {
+			if option.Source == nil {
+				continue
+			}
+			var p productDocument
+			if err := json.Unmarshal(*option.Source, &p); err != nil {
+				return nil, err
+			}
+			if p.Deleted || seen[p.Name] {
+				continue
+			}
+			seen[p.Name] = true
+			products = append(products, Product{
+				ID:          option.Id,
+				Name:        p.Name,
+				Description: p.Description,
+				Price:       p.Price,
+				Category:    p.Category,
+				Stock:       p.Stock,
+				Score:       option.ScoreUnderscore,
+			})
+		}
+	}
+	return products, nil
+}
 
-	for _, hit := range res.Hits.Hits {
+// search runs a listing or a search query.
+// The filters of q are applied as post filter, after the facets are aggregated. Each facet is
+// aggregated with the filters of the other facets only, so selecting a category doesn't hide the
+// other categories, but does narrow down the price histogram.
+func (r *elasticRepository) search(ctx context.Context, query elastic.Query, skip uint64, take uint64, pageToken string, q ProductQuery) ([]Product, *Facets, error) {
+	src, err := searchSource(query, skip, take, pageToken, q, "_id")
+	if err != nil {
+		return nil, nil, err
+	}
+	res, err := r.clientdep.Search().
+		Index(indexAlias).
+		Type("product").
+		SearchSource(src).
+		Do(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return productsFromHits(res.Hits.Hits), facetsFromAggregations(res.Aggregations, priceInterval(q)), nil
+}
+
+// searchSource builds the search of a listing or a search query, it's the same for every version of elasticsearch.
+// idField is the field with the product ID, it breaks ties when sorting.
+func searchSource(query elastic.Query, skip uint64, take uint64, pageToken string, q ProductQuery, idField string) (*elastic.SearchSource, error) {
+	priceFilter, categoryFilter := filterQueries(q.Filter)
+
+	src := elastic.NewSearchSource().
+		Query(query).
+		PostFilter(elastic.NewBoolQuery().Filter(priceFilter, categoryFilter)).
+		Aggregation("prices", elastic.NewFilterAggregation().
+			Filter(categoryFilter).
+			SubAggregation("buckets", elastic.NewHistogramAggregation().Field("price").Interval(priceInterval(q)))).
+		Aggregation("categories", elastic.NewFilterAggregation().
+			Filter(priceFilter).
+			SubAggregation("buckets", elastic.NewTermsAggregation().Field("category").Size(50))).
+		SortBy(sorters(q.Sort, idField)...).
+		Size(int(take))
+	return paginate(src, skip, pageToken)
+}
+
+// priceInterval is the width of the price histogram buckets of q
+func priceInterval(q ProductQuery) float64 {
+	if q.PriceInterval <= 0 {
+		return DefaultPriceInterval
+	}
+	return q.PriceInterval
+}
+
+// filterQueries returns the price and the category filter, match all if they aren't set
+func filterQueries(f ProductFilter) (price elastic.Query, category elastic.Query) {
+	price, category = elastic.NewMatchAllQuery(), elastic.NewMatchAllQuery()
+	if f.MinPrice != nil || f.MaxPrice != nil {
+		price = priceRange{min: f.MinPrice, max: f.MaxPrice}
+	}
+	if len(f.Categories) > 0 {
+		categories := make([]interface{}, len(f.Categories))
+		for i, c := range f.Categories {
+			categories[i] = c
+		}
+		category = elastic.NewTermsQuery("category", categories...)
+	}
+	return price, category
+}
+
+// priceRange is a range query on the price with gte and lte.
+// elastic.RangeQuery writes from and to, which newer versions of elasticsearch deprecated.
+type priceRange struct {
+	min, max *float64
+}
+
+func (q priceRange) Source() (interface{}, error) {
+	bounds := map[string]interface{}{}
+	if q.min != nil {
+		bounds["gte"] = *q.min
+	}
+	if q.max != nil {
+		bounds["lte"] = *q.max
+	}
+	return map[string]interface{}{
+		"range": map[string]interface{}{"price": bounds},
+	}, nil
+}
+
+// sorters returns the sort of the search, the ID is always last to break ties,
+// so the order is stable across pages.
+func sorters(sort ProductSort, idField string) []elastic.Sorter {
+	id := elastic.NewFieldSort(idField).Desc()
+	switch sort {
+	case SortNewest:
+		return []elastic.Sorter{id}
+	case SortPriceAsc:
+		return []elastic.Sorter{elastic.NewFieldSort("price").Asc(), id}
+	case SortPriceDesc:
+		return []elastic.Sorter{elastic.NewFieldSort("price").Desc(), id}
+	case SortName:
+		return []elastic.Sorter{elastic.NewFieldSort("name.keyword").Asc(), id}
+	default:
+		return []elastic.Sorter{elastic.NewScoreSort(), id}
+	}
+}
+
+func facetsFromAggregations(aggs elastic.Aggregations, interval float64) *Facets {
+	facets := &Facets{Prices: []PriceBucket{}, Categories: []CategoryBucket{}}
+	if prices, ok := aggs.Filter("prices"); ok {
+		if histogram, ok := prices.Histogram("buckets"); ok {
+			for _, b := range histogram.Buckets {
+				facets.Prices = append(facets.Prices, PriceBucket{
+					From:  b.Key,
+					To:    b.Key + interval,
+					Count: uint64(b.DocCount),
+				})
+			}
+		}
+	}
+	if categories, ok := aggs.Filter("categories"); ok {
+		if terms, ok := categories.Terms("buckets"); ok {
+			for _, b := range terms.Buckets {
+				facets.Categories = append(facets.Categories, CategoryBucket{
+					Category: fmt.Sprint(b.Key),
+					Count:    uint64(b.DocCount),
+				})
+			}
+		}
+	}
+	return facets
+}
+
+// score is the relevance score of a hit, 0 when the search didn't compute scores
+func score(hit *elastic.SearchHit) float64 {
+	if hit.Score == nil {
+		return 0
+	}
+	return *hit.Score
+}
+
+// paginate pages the search with search_after if there is a page token, otherwise with From.
+// search_after can't be combined with From.
+func paginate(search *elastic.SearchSource, skip uint64, pageToken string) (*elastic.SearchSource, error) {
+	sortValues, err := decodePageToken(pageToken)
+	if err != nil {
+		return nil, err
+	}
+	if sortValues != nil {
+		return search.SearchAfter(sortValues...), nil
+	}
+	return search.From(int(skip)), nil
+}
+
+// productsFromHits converts the hits of a sorted search to products with their cursors
+func productsFromHits(hits []*elastic.SearchHit) []Product {
+	products := []Product{}
+	for _, hit := range hits {
 		var p productDocument
 		if err := json.Unmarshal(*hit.Source, &p); err == nil {
 			products = append(products, Product{
@@ -434,48 +735,95 @@ func (r *elasticRepository) SearchProducts(ctx context.Context, query string, sk
 				Name:        p.Name,
 				Description: p.Description,
 				Price:       p.Price,
+				Category:    p.Category,
+				Stock:       p.Stock,
+				Score:       score(hit),
+				Cursor:      encodePageToken(hit.Sort),
 			})
 		}
 	}
+	return products
+}
 
-	return products, nil
+// UpdateProduct replaces name, description, price and category of an existing product,
+// the stock and the deleted flag are kept. ErrNotFound is returned if there is no product with the ID.
+func (r *elasticRepository) UpdateProduct(ctx context.Context, p Product) error {
+	_, err := r.clientdep.Update().
+		Index(indexAlias).
+		Type("product").
+		Id(p.ID).
+		Doc(newProductUpdate(p)).
+		Do(ctx)
+	if elastic.IsNotFound(err) {
+		return ErrNotFound
+	}
+	return err
 }
 
-func NewElasticRepository(url string) (Repository, error) {
-	log.Print("Elasticsearch url:", url)
+// DeleteProduct is a soft delete. The document is only marked as deleted,
+// so the product still resolves by ID for orders that reference it.
+func (r *elasticRepository) DeleteProduct(ctx context.Context, id string) error {
+	_, err := r.clientdep.Update().
+		Index(indexAlias).
+		Type("product").
+		Id(id).
+		// null removes the product from the suggestions
+		Doc(map[string]interface{}{"deleted": true, "suggest": nil}).
+		Do(ctx)
+	if elastic.IsNotFound(err) {
+		return ErrNotFound
+	}
+	return err
+}
 
-	// official
-	// by default will use port 9200
-	// and [http.DefaultTransport]
+// SetStock overwrites the stock level of the product
+func (r *elasticRepository) SetStock(ctx context.Context, id string, quantity uint64) error {
+	_, err := r.clientdep.Update().
+		Index(indexAlias).
+		Type("product").
+		Id(id).
+		Doc(map[string]interface{}{"stock": quantity}).
+		RetryOnConflict(stockRetries).
+		Do(ctx)
+	if elastic.IsNotFound(err) {
+		return ErrNotFound
+	}
+	return err
+}
 
-	// es, err := elasticsearch.NewClient(elasticsearch.Config{
-	// 	Addresses: []string{url},
-	// })
-	// if err != nil {
-	// 	log.Print("Elasticsearch  error getting client:", err)
-	// 	return nil, err
-	// }
+// ReserveStock, ReleaseStock and ConfirmStock run the stock scripts one product at a time, see reserveStock
+func (r *elasticRepository) ReserveStock(ctx context.Context, reservationID string, items []StockItem) error {
+	return reserveStock(ctx, r, reservationID, items)
+}
 
-	// res, err := es.Info()
-	// if err != nil {
-	// 	log.Print("Elasticsearch error info:", err)
-	// 	return nil, err
-	// }
+func (r *elasticRepository) ReleaseStock(ctx context.Context, reservationID string, items []StockItem) error {
+	return releaseStock(ctx, r, reservationID, items)
+}
 
-	// log.Print("Elasticserch status :", res.Status())
+func (r *elasticRepository) ConfirmStock(ctx context.Context, reservationID string, items []StockItem) error {
+	return confirmStock(ctx, r, reservationID, items)
+}
+
+func (r *elasticRepository) updateStock(ctx context.Context, id string, script string, params map[string]interface{}) (bool, error) {
+	res, err := r.clientdep.Update().
+		Index(indexAlias).
+		Type("product").
+		Id(id).
+		Script(elastic.NewScript(script).Lang("painless").Params(params)).
+		RetryOnConflict(stockRetries).
+		Do(ctx)
+	if elastic.IsNotFound(err) {
+		return false, ErrNotFound
+	}
+	if err != nil {
+		return false, err
+	}
+	return res.Result != "noop", nil
+}
+
+func NewElasticRepository(url string) (Repository, error) {
+	log.Print("Elasticsearch url:", url)
 
-	// // not fully stable but close to olivere/elastic unofficial implementation.
-	// 	Addresses: []string{url},
-	// })
-	//
-	// fmt.Print(res)
-	//
-	// // return &elasticRepository{
-	// // 	client: es,
-	//
-	// // }, nil
-	//
-	// // not official
 	client, err := elastic.NewClient(
 		// SetURL defines the URL endpoints of the Elasticsearch nodes.
 		elastic.SetURL(url),
@@ -488,9 +836,12 @@ func NewElasticRepository(url string) (Repository, error) {
 		return nil, err
 	}
 
-	return &elasticRepository{
-		// client: es,
-		// tes:       tes,
+	r := &elasticRepository{
 		clientdep: client,
-	}, nil
+	}
+	if err := ensureIndex(context.Background(), r); err != nil {
+		log.Println("Error creating the catalog index: ", err)
+		return nil, err
+	}
+	return r, nil
 }
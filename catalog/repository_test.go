@@ -0,0 +1,673 @@
+package catalog
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+
+	"github.com/segmentio/ksuid"
+	"gopkg.in/olivere/elastic.v5"
+)
+
+// The conformance suite runs against every Repository implementation.
+// The others than memory are only tested if their URL is set, every test deletes all their products:
+//   - TEST_DATABASE_URL for PostgreSQL
+//   - TEST_ELASTICSEARCH6_URL for Elasticsearch 6
+//   - TEST_ELASTICSEARCH_URL for Elasticsearch 7 or 8
+//   - TEST_OPENSEARCH_URL for OpenSearch
+func TestRepositories(t *testing.T) {
+	implementations := map[string]func(t *testing.T) Repository{
+		"memory": func(t *testing.T) Repository {
+			return NewMemoryRepository()
+		},
+		"postgres":       newTestPostgresRepository,
+		"elasticsearch6": newTestElasticRepository,
+		"elasticsearch": func(t *testing.T) Repository {
+			return newTestRESTRepository(t, "TEST_ELASTICSEARCH_URL", NewElasticsearchRepository)
+		},
+		"opensearch": func(t *testing.T) Repository {
+			return newTestRESTRepository(t, "TEST_OPENSEARCH_URL", NewOpenSearchRepository)
+		},
+	}
+	for name, newRepository := range implementations {
+		t.Run(name, func(t *testing.T) {
+			testRepository(t, newRepository)
+		})
+	}
+}
+
+func testURL(t *testing.T, env string) string {
+	u := os.Getenv(env)
+	if u == "" {
+		t.Skip(env + " is not set")
+	}
+	return u
+}
+
+func newTestPostgresRepository(t *testing.T) Repository {
+	r, err := NewPostgresRepository(testURL(t, "TEST_DATABASE_URL"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.(*postgresRepository).db.Exec("TRUNCATE products, stock_reservations"); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(r.Close)
+	return r
+}
+
+func newTestElasticRepository(t *testing.T) Repository {
+	r, err := NewElasticRepository(testURL(t, "TEST_ELASTICSEARCH6_URL"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := r.(*elasticRepository).clientdep
+	_, err = client.DeleteByQuery(indexAlias).Query(elastic.NewMatchAllQuery()).Refresh("true").Do(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return refreshedRepository{r, func() error {
+		_, err := client.Refresh(indexAlias).Do(context.Background())
+		return err
+	}}
+}
+
+func newTestRESTRepository(t *testing.T, env string, connect func(url string) (Repository, error)) Repository {
+	r, err := connect(testURL(t, env))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rest := r.(*restRepository)
+	err = rest.do(context.Background(), http.MethodPost, "/"+indexAlias+"/_delete_by_query", url.Values{"refresh": {"true"}}, map[string]interface{}{
+		"query": map[string]interface{}{"match_all": map[string]interface{}{}},
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return refreshedRepository{r, func() error {
+		return rest.do(context.Background(), http.MethodPost, "/"+indexAlias+"/_refresh", nil, nil, nil)
+	}}
+}
+
+// refreshedRepository refreshes the index after every write. Search engines only
+// make changes searchable on the next refresh, the suite expects them right away.
+type refreshedRepository struct {
+	Repository
+	refresh func() error
+}
+
+func (r refreshedRepository) PutProduct(ctx context.Context, p Product) error {
+	if err := r.Repository.PutProduct(ctx, p); err != nil {
+		return err
+	}
+	return r.refresh()
+}
+
+func (r refreshedRepository) UpdateProduct(ctx context.Context, p Product) error {
+	if err := r.Repository.UpdateProduct(ctx, p); err != nil {
+		return err
+	}
+	return r.refresh()
+}
+
+func (r refreshedRepository) DeleteProduct(ctx context.Context, id string) error {
+	if err := r.Repository.DeleteProduct(ctx, id); err != nil {
+		return err
+	}
+	return r.refresh()
+}
+
+// testProducts are the products most tests start with
+type testProducts struct {
+	wireless, wired, coffeeMug, teaMug, lamp Product
+}
+
+func (p testProducts) all() []Product {
+	return []Product{p.wireless, p.wired, p.coffeeMug, p.teaMug, p.lamp}
+}
+
+func putTestProducts(t *testing.T, r Repository) testProducts {
+	t.Helper()
+	p := testProducts{
+		wireless:  Product{ID: ksuid.New().String(), Name: "Wireless Headphones", Description: "Noise cancelling over-ear headphones", Price: 99.99, Category: "audio", Stock: 5},
+		wired:     Product{ID: ksuid.New().String(), Name: "Wired Headphones", Description: "Studio headphones with a cable", Price: 49.5, Category: "audio", Stock: 3},
+		coffeeMug: Product{ID: ksuid.New().String(), Name: "Coffee Mug", Description: "A mug for coffee lovers", Price: 12, Category: "kitchen", Stock: 10},
+		teaMug:    Product{ID: ksuid.New().String(), Name: "Tea Mug", Description: "Ceramic mug", Price: 12, Category: "kitchen"},
+		lamp:      Product{ID: ksuid.New().String(), Name: "Desk Lamp", Description: "A lamp with a wireless charger", Price: 35, Stock: 2},
+	}
+	for _, product := range p.all() {
+		if err := r.PutProduct(context.Background(), product); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return p
+}
+
+// sorted returns the IDs of the products in the order of less, the ID descending breaks ties
+func sorted(products []Product, less func(a, b Product) (bool, bool)) []string {
+	products = append([]Product{}, products...)
+	sort.Slice(products, func(i, j int) bool {
+		if before, ok := less(products[i], products[j]); ok {
+			return before
+		}
+		return products[i].ID > products[j].ID
+	})
+	return productIDs(products)
+}
+
+func productIDs(products []Product) []string {
+	ids := []string{}
+	for _, p := range products {
+		ids = append(ids, p.ID)
+	}
+	return ids
+}
+
+func sortedIDs(products []Product) []string {
+	ids := productIDs(products)
+	sort.Strings(ids)
+	return ids
+}
+
+func testRepository(t *testing.T, newRepository func(t *testing.T) Repository) {
+	ctx := context.Background()
+
+	t.Run("get", func(t *testing.T) {
+		r := newRepository(t)
+		p := putTestProducts(t, r)
+
+		got, err := r.GetProductByID(ctx, p.wireless.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(*got, p.wireless) {
+			t.Errorf("GetProductByID = %+v, want %+v", *got, p.wireless)
+		}
+		if _, err := r.GetProductByID(ctx, ksuid.New().String()); err != ErrNotFound {
+			t.Errorf("GetProductByID of unknown product: %v, want ErrNotFound", err)
+		}
+
+		// putting a product again replaces it
+		replaced := p.wireless
+		replaced.Price = 89.99
+		if err := r.PutProduct(ctx, replaced); err != nil {
+			t.Fatal(err)
+		}
+		if got, err := r.GetProductByID(ctx, replaced.ID); err != nil || got.Price != replaced.Price {
+			t.Errorf("GetProductByID after PutProduct = %+v, %v, want price %v", got, err, replaced.Price)
+		}
+
+		products, err := r.ListProductsWithIDs(ctx, []string{p.lamp.ID, ksuid.New().String(), p.wired.ID})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(products, []Product{p.lamp, p.wired}) {
+			t.Errorf("ListProductsWithIDs = %+v, want the lamp and the wired headphones", products)
+		}
+	})
+
+	t.Run("sort", func(t *testing.T) {
+		r := newRepository(t)
+		p := putTestProducts(t, r)
+
+		for _, tc := range []struct {
+			sort ProductSort
+			want []string
+		}{
+			{SortRelevance, sorted(p.all(), func(a, b Product) (bool, bool) { return false, false })},
+			{SortNewest, sorted(p.all(), func(a, b Product) (bool, bool) { return false, false })},
+			{SortPriceAsc, sorted(p.all(), func(a, b Product) (bool, bool) { return a.Price < b.Price, a.Price != b.Price })},
+			{SortPriceDesc, sorted(p.all(), func(a, b Product) (bool, bool) { return a.Price > b.Price, a.Price != b.Price })},
+			{SortName, sorted(p.all(), func(a, b Product) (bool, bool) { return a.Name < b.Name, a.Name != b.Name })},
+		} {
+			q := ProductQuery{Sort: tc.sort}
+			products, _, err := r.ListProducts(ctx, 0, 10, "", q)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := productIDs(products); !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("ListProducts sorted by %q = %v, want %v", tc.sort, got, tc.want)
+			}
+
+			products, _, err = r.ListProducts(ctx, 1, 2, "", q)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := productIDs(products); !reflect.DeepEqual(got, tc.want[1:3]) {
+				t.Errorf("ListProducts sorted by %q with skip 1 and take 2 = %v, want %v", tc.sort, got, tc.want[1:3])
+			}
+
+			// paging with the cursors returns every product once, in order
+			var paged []string
+			token := ""
+			for i := 0; i < 10; i++ {
+				products, _, err := r.ListProducts(ctx, 0, 2, token, q)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if len(products) == 0 {
+					break
+				}
+				paged = append(paged, productIDs(products)...)
+				token = products[len(products)-1].Cursor
+			}
+			if !reflect.DeepEqual(paged, tc.want) {
+				t.Errorf("paging sorted by %q = %v, want %v", tc.sort, paged, tc.want)
+			}
+		}
+
+		if _, _, err := r.ListProducts(ctx, 0, 10, "not a token", ProductQuery{}); err != ErrInvalidPageToken {
+			t.Errorf("ListProducts with an invalid page token: %v, want ErrInvalidPageToken", err)
+		}
+	})
+
+	t.Run("filter and facets", func(t *testing.T) {
+		r := newRepository(t)
+		p := putTestProducts(t, r)
+		minPrice, maxPrice := 12.0, 49.5
+
+		products, _, err := r.ListProducts(ctx, 0, 10, "", ProductQuery{Filter: ProductFilter{MinPrice: &minPrice, MaxPrice: &maxPrice}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := sortedIDs(products), sortedIDs([]Product{p.wired, p.coffeeMug, p.teaMug, p.lamp}); !reflect.DeepEqual(got, want) {
+			t.Errorf("ListProducts in price range = %v, want %v", got, want)
+		}
+
+		products, facets, err := r.ListProducts(ctx, 0, 10, "", ProductQuery{
+			Filter: ProductFilter{MaxPrice: &maxPrice, Categories: []string{"kitchen"}},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := sortedIDs(products), sortedIDs([]Product{p.coffeeMug, p.teaMug}); !reflect.DeepEqual(got, want) {
+			t.Errorf("ListProducts in category = %v, want %v", got, want)
+		}
+		// each facet ignores its own filter
+		want := &Facets{
+			Prices:     []PriceBucket{{From: 10, To: 20, Count: 2}},
+			Categories: []CategoryBucket{{Category: "kitchen", Count: 2}, {Category: "audio", Count: 1}},
+		}
+		if !reflect.DeepEqual(facets, want) {
+			t.Errorf("facets = %+v, want %+v", facets, want)
+		}
+
+		_, facets, err = r.ListProducts(ctx, 0, 1, "", ProductQuery{PriceInterval: 50})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := []PriceBucket{{From: 0, To: 50, Count: 4}, {From: 50, To: 100, Count: 1}}; !reflect.DeepEqual(facets.Prices, want) {
+			t.Errorf("price facet = %+v, want %+v", facets.Prices, want)
+		}
+	})
+
+	t.Run("search", func(t *testing.T) {
+		r := newRepository(t)
+		p := putTestProducts(t, r)
+
+		for _, tc := range []struct {
+			query string
+			want  []Product
+		}{
+			{"headphones", []Product{p.wireless, p.wired}},
+			{"HEADPH", []Product{p.wireless, p.wired}},
+			{"mug coffee", []Product{p.coffeeMug}},
+			{"bicycle", nil},
+		} {
+			products, _, err := r.SearchProducts(ctx, tc.query, 0, 10, "", ProductQuery{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, want := sortedIDs(products), sortedIDs(tc.want); !reflect.DeepEqual(got, want) {
+				t.Errorf("SearchProducts(%q) = %v, want %v", tc.query, got, want)
+			}
+		}
+
+		// a match in the name ranks above one in the description, the second page continues after the first
+		want := []string{p.wireless.ID, p.lamp.ID}
+		var got []string
+		token := ""
+		for i := 0; i < 3; i++ {
+			products, _, err := r.SearchProducts(ctx, "wireless", 0, 1, token, ProductQuery{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(products) == 0 {
+				break
+			}
+			got = append(got, products[0].ID)
+			token = products[0].Cursor
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("paging SearchProducts(\"wireless\") = %v, want %v", got, want)
+		}
+
+		products, _, err := r.SearchProducts(ctx, "mug", 0, 10, "", ProductQuery{Filter: ProductFilter{Categories: []string{"audio"}}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(products) != 0 {
+			t.Errorf("SearchProducts filtered by category = %v, want none", productIDs(products))
+		}
+	})
+
+	t.Run("suggest", func(t *testing.T) {
+		r := newRepository(t)
+		p := putTestProducts(t, r)
+		// products with the same name are suggested once
+		if err := r.PutProduct(ctx, Product{ID: ksuid.New().String(), Name: p.coffeeMug.Name, Description: "Another mug", Price: 15}); err != nil {
+			t.Fatal(err)
+		}
+
+		for _, tc := range []struct {
+			prefix string
+			size   int
+			want   int
+		}{
+			{"head", 5, 2},
+			{"wire", 5, 2},
+			{"Coffee", 5, 1},
+			{"mug", 5, 2},
+			{"mug", 1, 1},
+			{"bicycle", 5, 0},
+		} {
+			products, err := r.SuggestProducts(ctx, tc.prefix, tc.size)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(products) != tc.want {
+				t.Errorf("SuggestProducts(%q, %d) returned %d products, want %d", tc.prefix, tc.size, len(products), tc.want)
+			}
+		}
+	})
+
+	t.Run("update", func(t *testing.T) {
+		r := newRepository(t)
+		p := putTestProducts(t, r)
+
+		updated := p.lamp
+		updated.Name = "Floor Lamp"
+		updated.Category = "lighting"
+		updated.Stock = 0
+		if err := r.UpdateProduct(ctx, updated); err != nil {
+			t.Fatal(err)
+		}
+		got, err := r.GetProductByID(ctx, updated.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// the stock is only changed by the stock methods
+		updated.Stock = p.lamp.Stock
+		if !reflect.DeepEqual(*got, updated) {
+			t.Errorf("updated product = %+v, want %+v", *got, updated)
+		}
+		if products, err := r.SuggestProducts(ctx, "floor", 5); err != nil || len(products) != 1 {
+			t.Errorf("SuggestProducts of the new name = %v, %v, want the product", products, err)
+		}
+
+		// an empty category clears it, the product leaves the category facet
+		updated.Category = ""
+		if err := r.UpdateProduct(ctx, updated); err != nil {
+			t.Fatal(err)
+		}
+		if got, err := r.GetProductByID(ctx, updated.ID); err != nil || got.Category != "" {
+			t.Errorf("GetProductByID after clearing the category = %+v, %v", got, err)
+		}
+		_, facets, err := r.ListProducts(ctx, 0, 10, "", ProductQuery{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := []CategoryBucket{{Category: "audio", Count: 2}, {Category: "kitchen", Count: 2}}; !reflect.DeepEqual(facets.Categories, want) {
+			t.Errorf("category facet = %+v, want %+v", facets.Categories, want)
+		}
+
+		// updating doesn't bring back a deleted product
+		if err := r.DeleteProduct(ctx, updated.ID); err != nil {
+			t.Fatal(err)
+		}
+		if err := r.UpdateProduct(ctx, updated); err != nil {
+			t.Fatal(err)
+		}
+		if got, err := r.GetProductByID(ctx, updated.ID); err != nil || !got.Deleted {
+			t.Errorf("GetProductByID of deleted product after an update = %+v, %v, want it deleted", got, err)
+		}
+
+		if err := r.UpdateProduct(ctx, Product{ID: ksuid.New().String(), Name: "Chair"}); err != ErrNotFound {
+			t.Errorf("UpdateProduct of unknown product: %v, want ErrNotFound", err)
+		}
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		r := newRepository(t)
+		p := putTestProducts(t, r)
+
+		if err := r.DeleteProduct(ctx, p.teaMug.ID); err != nil {
+			t.Fatal(err)
+		}
+
+		// deleted products still resolve by ID, for the orders that reference them
+		got, err := r.GetProductByID(ctx, p.teaMug.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !got.Deleted {
+			t.Errorf("deleted product isn't marked as deleted")
+		}
+		products, err := r.ListProductsWithIDs(ctx, []string{p.teaMug.ID})
+		if err != nil || len(products) != 1 || !products[0].Deleted {
+			t.Errorf("ListProductsWithIDs of deleted product = %+v, %v", products, err)
+		}
+
+		// but they aren't listed, searched or suggested
+		products, facets, err := r.ListProducts(ctx, 0, 10, "", ProductQuery{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := sortedIDs(products), sortedIDs([]Product{p.wireless, p.wired, p.coffeeMug, p.lamp}); !reflect.DeepEqual(got, want) {
+			t.Errorf("ListProducts = %v, want %v", got, want)
+		}
+		if want := []CategoryBucket{{Category: "audio", Count: 2}, {Category: "kitchen", Count: 1}}; !reflect.DeepEqual(facets.Categories, want) {
+			t.Errorf("category facet = %+v, want %+v", facets.Categories, want)
+		}
+		if products, _, err := r.SearchProducts(ctx, "tea", 0, 10, "", ProductQuery{}); err != nil || len(products) != 0 {
+			t.Errorf("SearchProducts of deleted product = %v, %v, want none", productIDs(products), err)
+		}
+		if products, err := r.SuggestProducts(ctx, "tea", 5); err != nil || len(products) != 0 {
+			t.Errorf("SuggestProducts of deleted product = %v, %v, want none", productIDs(products), err)
+		}
+
+		if err := r.DeleteProduct(ctx, ksuid.New().String()); err != ErrNotFound {
+			t.Errorf("DeleteProduct of unknown product: %v, want ErrNotFound", err)
+		}
+	})
+
+	t.Run("stock", func(t *testing.T) {
+		r := newRepository(t)
+		p := putTestProducts(t, r)
+
+		assertStock := func(t *testing.T, want map[string]uint64) {
+			t.Helper()
+			for id, quantity := range want {
+				got, err := r.GetProductByID(ctx, id)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got.Stock != quantity {
+					t.Errorf("stock of %s = %d, want %d", got.Name, got.Stock, quantity)
+				}
+			}
+		}
+
+		if err := r.SetStock(ctx, p.lamp.ID, 4); err != nil {
+			t.Fatal(err)
+		}
+		assertStock(t, map[string]uint64{p.lamp.ID: 4})
+		if err := r.SetStock(ctx, ksuid.New().String(), 4); err != ErrNotFound {
+			t.Errorf("SetStock of unknown product: %v, want ErrNotFound", err)
+		}
+
+		err := r.ReserveStock(ctx, "", mergeStockItems([]StockItem{{p.wireless.ID, 2}, {p.wired.ID, 3}}))
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertStock(t, map[string]uint64{p.wireless.ID: 3, p.wired.ID: 0})
+
+		// nothing is reserved if one of the products is short
+		err = r.ReserveStock(ctx, "", mergeStockItems([]StockItem{{p.coffeeMug.ID, 1}, {p.lamp.ID, 1}, {p.wired.ID, 1}}))
+		if err != ErrInsufficientStock {
+			t.Errorf("ReserveStock of more than the stock: %v, want ErrInsufficientStock", err)
+		}
+		err = r.ReserveStock(ctx, "", mergeStockItems([]StockItem{{p.coffeeMug.ID, 1}, {ksuid.New().String(), 1}}))
+		if err != ErrNotFound {
+			t.Errorf("ReserveStock of unknown product: %v, want ErrNotFound", err)
+		}
+		assertStock(t, map[string]uint64{p.coffeeMug.ID: 10, p.lamp.ID: 4, p.wired.ID: 0})
+
+		// deleted products can't be ordered
+		if err := r.DeleteProduct(ctx, p.coffeeMug.ID); err != nil {
+			t.Fatal(err)
+		}
+		if err := r.ReserveStock(ctx, "", []StockItem{{p.coffeeMug.ID, 1}}); err != ErrInsufficientStock {
+			t.Errorf("ReserveStock of deleted product: %v, want ErrInsufficientStock", err)
+		}
+
+		if err := r.ReleaseStock(ctx, "", mergeStockItems([]StockItem{{p.wireless.ID, 2}, {p.wired.ID, 3}})); err != nil {
+			t.Fatal(err)
+		}
+		assertStock(t, map[string]uint64{p.wireless.ID: 5, p.wired.ID: 3, p.coffeeMug.ID: 10})
+	})
+
+	t.Run("stock reservations", func(t *testing.T) {
+		r := newRepository(t)
+		p := putTestProducts(t, r)
+
+		assertStock := func(t *testing.T, wireless, wired uint64) {
+			t.Helper()
+			for id, quantity := range map[string]uint64{p.wireless.ID: wireless, p.wired.ID: wired} {
+				got, err := r.GetProductByID(ctx, id)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got.Stock != quantity {
+					t.Errorf("stock of %s = %d, want %d", got.Name, got.Stock, quantity)
+				}
+			}
+		}
+		items := mergeStockItems([]StockItem{{p.wireless.ID, 2}, {p.wired.ID, 1}})
+
+		// reserving again with the same ID takes nothing
+		for i := 0; i < 2; i++ {
+			if err := r.ReserveStock(ctx, "order-1", items); err != nil {
+				t.Fatal(err)
+			}
+		}
+		assertStock(t, 3, 2)
+
+		// a release gives back what the reservation took, whatever the items say, and only once
+		for i := 0; i < 2; i++ {
+			if err := r.ReleaseStock(ctx, "order-1", mergeStockItems([]StockItem{{p.wireless.ID, 5}, {p.wired.ID, 5}})); err != nil {
+				t.Fatal(err)
+			}
+		}
+		assertStock(t, 5, 3)
+
+		// a reservation that failed took nothing, releasing it gives nothing back
+		err := r.ReserveStock(ctx, "order-2", mergeStockItems([]StockItem{{p.wireless.ID, 1}, {p.wired.ID, 4}}))
+		if err != ErrInsufficientStock {
+			t.Fatalf("ReserveStock of more than the stock: %v, want ErrInsufficientStock", err)
+		}
+		if err := r.ReleaseStock(ctx, "order-2", items); err != nil {
+			t.Fatal(err)
+		}
+		if err := r.ReleaseStock(ctx, "never-reserved", items); err != nil {
+			t.Fatal(err)
+		}
+		assertStock(t, 5, 3)
+
+		// a confirmed reservation keeps its stock, a late release with its ID gives nothing back
+		if err := r.ReserveStock(ctx, "order-3", items); err != nil {
+			t.Fatal(err)
+		}
+		if err := r.ConfirmStock(ctx, "order-3", items); err != nil {
+			t.Fatal(err)
+		}
+		if err := r.ReleaseStock(ctx, "order-3", items); err != nil {
+			t.Fatal(err)
+		}
+		assertStock(t, 3, 2)
+		// the order is cancelled, its stock is released without the ID
+		if err := r.ReleaseStock(ctx, "", items); err != nil {
+			t.Fatal(err)
+		}
+		assertStock(t, 5, 3)
+	})
+
+	t.Run("concurrent reservations", func(t *testing.T) {
+		r := newRepository(t)
+		p := putTestProducts(t, r)
+
+		// the wireless headphones have a stock of 5, only 5 orders of one can get them
+		var wg sync.WaitGroup
+		errs := make(chan error, 20)
+		for i := 0; i < 20; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				errs <- r.ReserveStock(ctx, "", []StockItem{{p.wireless.ID, 1}})
+			}()
+		}
+		wg.Wait()
+		close(errs)
+
+		reserved := 0
+		for err := range errs {
+			switch err {
+			case nil:
+				reserved++
+			case ErrInsufficientStock:
+			default:
+				t.Error(err)
+			}
+		}
+		if reserved != 5 {
+			t.Errorf("%d reservations succeeded, want 5", reserved)
+		}
+		if got, err := r.GetProductByID(ctx, p.wireless.ID); err != nil || got.Stock != 0 {
+			t.Errorf("stock after the reservations = %+v, %v, want 0", got, err)
+		}
+	})
+
+	t.Run("concurrent", func(t *testing.T) {
+		r := newRepository(t)
+		var wg sync.WaitGroup
+		for i := 0; i < 20; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				p := Product{ID: ksuid.New().String(), Name: fmt.Sprint("Product ", i), Description: "A product", Price: float64(i)}
+				if err := r.PutProduct(ctx, p); err != nil {
+					t.Error(err)
+					return
+				}
+				if _, _, err := r.SearchProducts(ctx, "product", 0, 10, "", ProductQuery{}); err != nil {
+					t.Error(err)
+				}
+			}(i)
+		}
+		wg.Wait()
+
+		products, _, err := r.ListProducts(ctx, 0, 100, "", ProductQuery{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(products) != 20 {
+			t.Errorf("ListProducts returned %d products, want 20", len(products))
+		}
+	})
+}
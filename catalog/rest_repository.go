@@ -0,0 +1,494 @@
+package catalog
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v8"
+	"github.com/opensearch-project/opensearch-go/v2"
+	"gopkg.in/olivere/elastic.v5"
+)
+
+// restRepository stores products in Elasticsearch 7 and 8 or OpenSearch.
+// These don't have mapping types anymore, but their REST API is the same otherwise,
+// so one implementation serves all of them with the client of each.
+// The query DSL hasn't changed either, the searches are built like for elasticRepository
+// and the responses are decoded into the same result types.
+type restRepository struct {
+	client transport
+}
+
+// transport sends requests to the cluster.
+// Both clients implement it, they fill in the address of a node and handle retries.
+type transport interface {
+	Perform(req *http.Request) (*http.Response, error)
+}
+
+// restDocument is a product in a typeless index, it has the ID as a field to sort by
+type restDocument struct {
+	ID string `json:"id"`
+	productDocument
+}
+
+// responseError is an error response of the cluster
+type responseError struct {
+	Status int
+	Body   string
+}
+
+func (e *responseError) Error() string {
+	return fmt.Sprintf("search engine responded with %d: %s", e.Status, e.Body)
+}
+
+func isNotFound(err error) bool {
+	e, ok := err.(*responseError)
+	return ok && e.Status == http.StatusNotFound
+}
+
+// minElasticsearchVersion is the oldest Elasticsearch NewElasticsearchRepository works with.
+// The client only talks to clusters that send the X-Elastic-Product header, which 7.14 added.
+var minElasticsearchVersion = [2]int{7, 14}
+
+// NewElasticsearchRepository connects to an Elasticsearch 7.14 or newer cluster, including 8.
+// Use NewElasticRepository for Elasticsearch 6. Older 7.x releases are rejected with ErrUnsupportedVersion.
+func NewElasticsearchRepository(url string) (Repository, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := checkElasticsearchVersion(ctx, url); err != nil {
+		return nil, err
+	}
+
+	client, err := elasticsearch.NewClient(elasticsearch.Config{
+		Addresses: []string{url},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return newRESTRepository(client)
+}
+
+// NewOpenSearchRepository connects to an OpenSearch cluster
+func NewOpenSearchRepository(url string) (Repository, error) {
+	client, err := opensearch.NewClient(opensearch.Config{
+		Addresses: []string{url},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return newRESTRepository(client)
+}
+
+// checkElasticsearchVersion reads the version of the cluster at addr without the client,
+// so an unsupported version gets a clear error instead of the client's product check failing every request
+func checkElasticsearchVersion(ctx context.Context, addr string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, addr, nil)
+	if err != nil {
+		return err
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode >= 300 {
+		msg, _ := io.ReadAll(res.Body)
+		return &responseError{Status: res.StatusCode, Body: string(msg)}
+	}
+
+	var info struct {
+		Version struct {
+			Number       string `json:"number"`
+			Distribution string `json:"distribution"`
+		} `json:"version"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&info); err != nil {
+		return err
+	}
+	if info.Version.Distribution == "opensearch" {
+		return fmt.Errorf("%w: the cluster is OpenSearch %s, set SEARCH_BACKEND to opensearch", ErrUnsupportedVersion, info.Version.Number)
+	}
+	var major, minor int
+	if _, err := fmt.Sscanf(info.Version.Number, "%d.%d", &major, &minor); err != nil {
+		return fmt.Errorf("%w: can't read Elasticsearch version %q", ErrUnsupportedVersion, info.Version.Number)
+	}
+	if major < minElasticsearchVersion[0] || (major == minElasticsearchVersion[0] && minor < minElasticsearchVersion[1]) {
+		return fmt.Errorf(
+			"%w: the cluster is Elasticsearch %s, SEARCH_BACKEND=elasticsearch needs %d.%d or newer, use elasticsearch6 for 6.x",
+			ErrUnsupportedVersion, info.Version.Number, minElasticsearchVersion[0], minElasticsearchVersion[1],
+		)
+	}
+	return nil
+}
+
+func newRESTRepository(client transport) (Repository, error) {
+	r := &restRepository{client: client}
+	if err := ensureIndex(context.Background(), r); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *restRepository) Close() {}
+
+// do sends a request and decodes the JSON response into out if it's not nil.
+// body is encoded as JSON, except for []byte which is sent as newline delimited JSON for bulk requests.
+// Responses with an error status are returned as *responseError.
+func (r *restRepository) do(ctx context.Context, method, path string, query url.Values, body interface{}, out interface{}) error {
+	var reader io.Reader
+	contentType := "application/json"
+	switch b := body.(type) {
+	case nil:
+	case []byte:
+		reader = bytes.NewReader(b)
+		contentType = "application/x-ndjson"
+	default:
+		buf, err := json.Marshal(b)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(buf)
+	}
+
+	u := &url.URL{Path: path, RawQuery: query.Encode()}
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), reader)
+	if err != nil {
+		return err
+	}
+	if reader != nil {
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	res, err := r.client.Perform(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode >= 300 {
+		msg, _ := io.ReadAll(res.Body)
+		return &responseError{Status: res.StatusCode, Body: string(msg)}
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(res.Body).Decode(out)
+}
+
+func (r *restRepository) PutProduct(ctx context.Context, p Product) error {
+	return r.do(ctx, http.MethodPut, "/"+indexAlias+"/_doc/"+url.PathEscape(p.ID), nil, restDocument{
+		ID: p.ID,
+		productDocument: productDocument{
+			Name:        p.Name,
+			Description: p.Description,
+			Price:       p.Price,
+			Category:    p.Category,
+			Stock:       p.Stock,
+			Suggest:     newSuggestField(p.Name),
+		},
+	}, nil)
+}
+
+func (r *restRepository) GetProductByID(ctx context.Context, id string) (*Product, error) {
+	var res elastic.GetResult
+	err := r.do(ctx, http.MethodGet, "/"+indexAlias+"/_doc/"+url.PathEscape(id), nil, nil, &res)
+	if isNotFound(err) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	p := productDocument{}
+	if err := json.Unmarshal(*res.Source, &p); err != nil {
+		return nil, err
+	}
+	return &Product{
+		ID:          id,
+		Name:        p.Name,
+		Description: p.Description,
+		Price:       p.Price,
+		Category:    p.Category,
+		Stock:       p.Stock,
+		Deleted:     p.Deleted,
+	}, nil
+}
+
+// ListProducts works like elasticRepository.ListProducts
+func (r *restRepository) ListProducts(ctx context.Context, skip uint64, take uint64, pageToken string, q ProductQuery) ([]Product, *Facets, error) {
+	// a listing has no scores, relevance falls back to newest
+	if q.Sort == SortRelevance {
+		q.Sort = SortNewest
+	}
+	return r.search(
+		ctx,
+		elastic.NewBoolQuery().MustNot(elastic.NewTermQuery("deleted", true)),
+		skip,
+		take,
+		pageToken,
+		q,
+	)
+}
+
+// ListProductsWithIDs returns the products in the order of ids, IDs that don't exist are left out
+func (r *restRepository) ListProductsWithIDs(ctx context.Context, ids []string) ([]Product, error) {
+	var res elastic.MgetResponse
+	if err := r.do(ctx, http.MethodPost, "/"+indexAlias+"/_mget", nil, map[string]interface{}{"ids": ids}, &res); err != nil {
+		return nil, fmt.Errorf("Error calling muti get: %w", err)
+	}
+
+	var products []Product
+	for _, doc := range res.Docs {
+		if !doc.Found {
+			continue
+		}
+		var p productDocument
+		if err := json.Unmarshal(*doc.Source, &p); err != nil {
+			return nil, fmt.Errorf("Error deconding response: %w", err)
+		}
+		products = append(products, Product{
+			ID:          doc.Id,
+			Name:        p.Name,
+			Description: p.Description,
+			Price:       p.Price,
+			Category:    p.Category,
+			Stock:       p.Stock,
+			Deleted:     p.Deleted,
+		})
+	}
+	return products, nil
+}
+
+// SearchProducts works like elasticRepository.SearchProducts
+func (r *restRepository) SearchProducts(ctx context.Context, query string, skip uint64, take uint64, pageToken string, q ProductQuery) ([]Product, *Facets, error) {
+	return r.search(ctx, searchQuery(query), skip, take, pageToken, q)
+}
+
+func (r *restRepository) SuggestProducts(ctx context.Context, prefix string, size int) ([]Product, error) {
+	res, err := r.searchSource(ctx, indexAlias, nil, suggestSource(prefix, size))
+	if err != nil {
+		return nil, err
+	}
+	return productsFromSuggestions(res)
+}
+
+func (r *restRepository) search(ctx context.Context, query elastic.Query, skip uint64, take uint64, pageToken string, q ProductQuery) ([]Product, *Facets, error) {
+	src, err := searchSource(query, skip, take, pageToken, q, "id")
+	if err != nil {
+		return nil, nil, err
+	}
+	res, err := r.searchSource(ctx, indexAlias, nil, src)
+	if err != nil {
+		return nil, nil, err
+	}
+	return productsFromHits(res.Hits.Hits), facetsFromAggregations(res.Aggregations, priceInterval(q)), nil
+}
+
+// searchSource runs a search on the index.
+// Since Elasticsearch 7 the total of the hits is an object, rest_total_hits_as_int
+// returns it as the number elastic.SearchResult expects.
+func (r *restRepository) searchSource(ctx context.Context, index string, query url.Values, src *elastic.SearchSource) (*elastic.SearchResult, error) {
+	body, err := src.Source()
+	if err != nil {
+		return nil, err
+	}
+	if query == nil {
+		query = url.Values{}
+	}
+	query.Set("rest_total_hits_as_int", "true")
+
+	var res elastic.SearchResult
+	if err := r.do(ctx, http.MethodPost, "/"+index+"/_search", query, body, &res); err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// UpdateProduct replaces name, description, price and category of an existing product,
+// the stock and the deleted flag are kept. ErrNotFound is returned if there is no product with the ID.
+func (r *restRepository) UpdateProduct(ctx context.Context, p Product) error {
+	err := r.do(ctx, http.MethodPost, "/"+indexAlias+"/_update/"+url.PathEscape(p.ID), nil, map[string]interface{}{
+		"doc": newProductUpdate(p),
+	}, nil)
+	if isNotFound(err) {
+		return ErrNotFound
+	}
+	return err
+}
+
+// DeleteProduct is a soft delete like elasticRepository.DeleteProduct
+func (r *restRepository) DeleteProduct(ctx context.Context, id string) error {
+	err := r.do(ctx, http.MethodPost, "/"+indexAlias+"/_update/"+url.PathEscape(id), nil, map[string]interface{}{
+		// null removes the product from the suggestions
+		"doc": map[string]interface{}{"deleted": true, "suggest": nil},
+	}, nil)
+	if isNotFound(err) {
+		return ErrNotFound
+	}
+	return err
+}
+
+// SetStock overwrites the stock level of the product
+func (r *restRepository) SetStock(ctx context.Context, id string, quantity uint64) error {
+	err := r.do(ctx, http.MethodPost, "/"+indexAlias+"/_update/"+url.PathEscape(id), stockQuery(), map[string]interface{}{
+		"doc": map[string]interface{}{"stock": quantity},
+	}, nil)
+	if isNotFound(err) {
+		return ErrNotFound
+	}
+	return err
+}
+
+// ReserveStock, ReleaseStock and ConfirmStock work like the ones of elasticRepository
+func (r *restRepository) ReserveStock(ctx context.Context, reservationID string, items []StockItem) error {
+	return reserveStock(ctx, r, reservationID, items)
+}
+
+func (r *restRepository) ReleaseStock(ctx context.Context, reservationID string, items []StockItem) error {
+	return releaseStock(ctx, r, reservationID, items)
+}
+
+func (r *restRepository) ConfirmStock(ctx context.Context, reservationID string, items []StockItem) error {
+	return confirmStock(ctx, r, reservationID, items)
+}
+
+func (r *restRepository) updateStock(ctx context.Context, id string, script string, params map[string]interface{}) (bool, error) {
+	var res struct {
+		Result string `json:"result"`
+	}
+	err := r.do(ctx, http.MethodPost, "/"+indexAlias+"/_update/"+url.PathEscape(id), stockQuery(), map[string]interface{}{
+		"script": map[string]interface{}{
+			"source": script,
+			"lang":   "painless",
+			"params": params,
+		},
+	}, &res)
+	if isNotFound(err) {
+		return false, ErrNotFound
+	}
+	if err != nil {
+		return false, err
+	}
+	return res.Result != "noop", nil
+}
+
+func stockQuery() url.Values {
+	return url.Values{"retry_on_conflict": []string{fmt.Sprint(stockRetries)}}
+}
+
+func (r *restRepository) Reindex(ctx context.Context) (string, error) {
+	return reindex(ctx, r)
+}
+
+func (r *restRepository) UpgradeIndex(ctx context.Context) error {
+	return upgradeIndex(ctx, r)
+}
+
+func (r *restRepository) indexExists(ctx context.Context, name string) (bool, error) {
+	err := r.do(ctx, http.MethodHead, "/"+url.PathEscape(name), nil, nil, nil)
+	if isNotFound(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (r *restRepository) aliasedIndex(ctx context.Context) (string, error) {
+	// index name to its aliases
+	var res map[string]struct {
+		Aliases map[string]interface{} `json:"aliases"`
+	}
+	err := r.do(ctx, http.MethodGet, "/_alias/"+indexAlias, nil, nil, &res)
+	if isNotFound(err) {
+		// catalog is an index
+		return indexAlias, nil
+	}
+	if err != nil {
+		return "", err
+	}
+	var indices []string
+	for index := range res {
+		indices = append(indices, index)
+	}
+	return singleIndex(indices)
+}
+
+func (r *restRepository) createIndex(ctx context.Context, name string) error {
+	return r.do(ctx, http.MethodPut, "/"+url.PathEscape(name), nil, indexBody(true), nil)
+}
+
+func (r *restRepository) putMapping(ctx context.Context, index string) error {
+	err := r.do(ctx, http.MethodPut, "/"+url.PathEscape(index)+"/_mapping", nil, indexMapping(true), nil)
+	if e, ok := err.(*responseError); ok && e.Status == http.StatusBadRequest {
+		return fmt.Errorf("%w: %v", ErrIndexOutdated, err)
+	}
+	return err
+}
+
+func (r *restRepository) swapAlias(ctx context.Context, from, to string) error {
+	return r.do(ctx, http.MethodPost, "/_aliases", nil, map[string]interface{}{
+		"actions": indexAliasActions(from, to),
+	}, nil)
+}
+
+func (r *restRepository) copyProducts(ctx context.Context, from, to string) (int, error) {
+	src := elastic.NewSearchSource().Version(true).Size(500).SortBy(elastic.NewFieldSort("_doc"))
+	res, err := r.searchSource(ctx, from, url.Values{"scroll": {"1m"}}, src)
+	if err != nil {
+		return 0, err
+	}
+	scrollID := res.ScrollId
+	defer func() {
+		r.do(context.Background(), http.MethodDelete, "/_search/scroll", nil, map[string]interface{}{
+			"scroll_id": []string{scrollID},
+		}, nil)
+	}()
+
+	written := 0
+	for len(res.Hits.Hits) > 0 {
+		var bulk strings.Builder
+		enc := json.NewEncoder(&bulk)
+		for _, hit := range res.Hits.Hits {
+			var p productDocument
+			if err := json.Unmarshal(*hit.Source, &p); err != nil {
+				return written, err
+			}
+			action := map[string]interface{}{"_index": to, "_id": hit.Id}
+			if hit.Version != nil {
+				action["version"] = *hit.Version
+				action["version_type"] = "external"
+			}
+			if err := enc.Encode(map[string]interface{}{"index": action}); err != nil {
+				return written, err
+			}
+			if err := enc.Encode(restDocument{ID: hit.Id, productDocument: reindexedDocument(p)}); err != nil {
+				return written, err
+			}
+		}
+
+		var bres elastic.BulkResponse
+		if err := r.do(ctx, http.MethodPost, "/_bulk", nil, []byte(bulk.String()), &bres); err != nil {
+			return written, err
+		}
+		n, err := countIndexed(&bres)
+		written += n
+		if err != nil {
+			return written, err
+		}
+
+		res = &elastic.SearchResult{}
+		err = r.do(ctx, http.MethodPost, "/_search/scroll", url.Values{"rest_total_hits_as_int": {"true"}}, map[string]interface{}{
+			"scroll":    "1m",
+			"scroll_id": scrollID,
+		}, res)
+		if err != nil {
+			return written, err
+		}
+		scrollID = res.ScrollId
+	}
+	return written, nil
+}
@@ -6,9 +6,12 @@ import (
 	"fmt"
 	"net"
 
+	"github.com/haroonalbar/go-grpc-graphql-microservices/auth"
 	"github.com/haroonalbar/go-grpc-graphql-microservices/catalog/pb"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/status"
 )
 
 type grpcServer struct {
@@ -16,14 +19,43 @@ type grpcServer struct {
 	service Service
 }
 
-func ListenGRPC(s Service, port int) error {
+// policy is what callers need for every method.
+// Anyone can browse products, only admins can change them.
+// Stock is reserved and released by the order service.
+var policy = auth.Policy{
+	pb.CatalogService_PostProduct_FullMethodName:     auth.RequireRole(auth.RoleAdmin),
+	pb.CatalogService_GetProduct_FullMethodName:      auth.Public,
+	pb.CatalogService_GetProducts_FullMethodName:     auth.Public,
+	pb.CatalogService_SuggestProducts_FullMethodName: auth.Public,
+	pb.CatalogService_UpdateProduct_FullMethodName:   auth.RequireRole(auth.RoleAdmin),
+	pb.CatalogService_DeleteProduct_FullMethodName:   auth.RequireRole(auth.RoleAdmin),
+	pb.CatalogService_ReserveStock_FullMethodName:    auth.RequireRole(auth.RoleService),
+	pb.CatalogService_ReleaseStock_FullMethodName:    auth.RequireRole(auth.RoleService),
+	pb.CatalogService_ConfirmStock_FullMethodName:    auth.RequireRole(auth.RoleService),
+}
+
+// ListenGRPC starts the gRPC server.
+// a checks the access tokens forwarded by the graphql gateway,
+// serviceToken is shared with the other services so they can call this one.
+func ListenGRPC(s Service, a auth.Authenticator, serviceToken string, port int) error {
 	// It creates a TCP listener on the specified port.
 	lis, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
 	if err != nil {
 		return err
 	}
+	// Starts serving gRPC requests
+	return NewGRPCServer(s, a, serviceToken).Serve(lis)
+}
+
+// NewGRPCServer returns the gRPC server of the catalog service without listening,
+// so it can serve any listener, like an in-memory one.
+func NewGRPCServer(s Service, a auth.Authenticator, serviceToken string) *grpc.Server {
 	// Initializes a new gRPC server.
-	serv := grpc.NewServer()
+	// Every request goes through the auth interceptor before it reaches a handler
+	serv := grpc.NewServer(
+		grpc.UnaryInterceptor(auth.UnaryServerInterceptor(a, serviceToken, policy)),
+		grpc.StreamInterceptor(auth.StreamServerInterceptor(a, serviceToken, policy)),
+	)
 	// Registers the server for reflection (useful for debugging and service discovery).
 	reflection.Register(serv)
 
@@ -34,25 +66,16 @@ func ListenGRPC(s Service, port int) error {
 			service: s,
 		},
 	)
-
-	// Starts serving gRPC requests
-	return serv.Serve(lis)
+	return serv
 }
 
 func (s *grpcServer) PostProduct(ctx context.Context, r *pb.PostProductRequest) (*pb.PostProductResponse, error) {
-	p, err := s.service.PostProduct(ctx, r.Name, r.Description, r.Price)
+	p, err := s.service.PostProduct(ctx, r.Name, r.Description, r.Price, r.Category, r.Stock)
 	if err != nil {
 		return nil, err
 	}
 
-	return &pb.PostProductResponse{
-		Product: &pb.Product{
-			Id:          p.ID,
-			Name:        p.Name,
-			Description: p.Description,
-			Price:       p.Price,
-		},
-	}, nil
+	return &pb.PostProductResponse{Product: productToProto(*p)}, nil
 }
 
 func (s *grpcServer) GetProduct(ctx context.Context, r *pb.GetProductRequest) (*pb.GetProductResponse, error) {
@@ -60,25 +83,31 @@ func (s *grpcServer) GetProduct(ctx context.Context, r *pb.GetProductRequest) (*
 	if err != nil {
 		return nil, err
 	}
-	return &pb.GetProductResponse{
-		Product: &pb.Product{
-			Id:          p.ID,
-			Name:        p.Name,
-			Description: p.Description,
-			Price:       p.Price,
-		},
-	}, nil
+	return &pb.GetProductResponse{Product: productToProto(*p)}, nil
 }
 
 func (s *grpcServer) GetProducts(ctx context.Context, r *pb.GetProductsRequest) (*pb.GetProductsResponse, error) {
+	sort, err := ParseProductSort(r.Sort)
+	if err != nil {
+		return nil, err
+	}
+	q := ProductQuery{Sort: sort, PriceInterval: r.PriceInterval}
+	if r.Filter != nil {
+		q.Filter = ProductFilter{
+			MinPrice:   r.Filter.MinPrice,
+			MaxPrice:   r.Filter.MaxPrice,
+			Categories: r.Filter.Categories,
+		}
+	}
+
 	var ps []Product
-	var err error
+	var facets *Facets
 	if r.Query != "" {
-		ps, err = s.service.SearchProducts(ctx, r.Query, r.Skip, r.Take)
+		ps, facets, err = s.service.SearchProducts(ctx, r.Query, r.Skip, r.Take, r.PageToken, q)
 	} else if len(r.Ids) > 0 {
 		ps, err = s.service.GetProductsByIDs(ctx, r.Ids)
 	} else {
-		ps, err = s.service.GetProducts(ctx, r.Skip, r.Take)
+		ps, facets, err = s.service.GetProducts(ctx, r.Skip, r.Take, r.PageToken, q)
 	}
 	if err != nil {
 		return nil, err
@@ -86,14 +115,122 @@ func (s *grpcServer) GetProducts(ctx context.Context, r *pb.GetProductsRequest)
 
 	var products []*pb.Product
 	for _, p := range ps {
-		products = append(products, &pb.Product{
-			Id:          p.ID,
-			Name:        p.Name,
-			Description: p.Description,
-			Price:       p.Price,
-		})
+		products = append(products, productToProto(p))
 	}
-	return &pb.GetProductsResponse{
+	res := &pb.GetProductsResponse{
 		Products: products,
-	}, nil
+		Facets:   facetsToProto(facets),
+	}
+	if len(ps) > 0 {
+		res.NextPageToken = ps[len(ps)-1].Cursor
+	}
+	return res, nil
+}
+
+func (s *grpcServer) SuggestProducts(ctx context.Context, r *pb.SuggestProductsRequest) (*pb.SuggestProductsResponse, error) {
+	ps, err := s.service.SuggestProducts(ctx, r.Prefix, r.Size)
+	if err != nil {
+		return nil, err
+	}
+
+	products := []*pb.Product{}
+	for _, p := range ps {
+		products = append(products, productToProto(p))
+	}
+	return &pb.SuggestProductsResponse{Products: products}, nil
+}
+
+func (s *grpcServer) UpdateProduct(ctx context.Context, r *pb.UpdateProductRequest) (*pb.UpdateProductResponse, error) {
+	p, err := s.service.UpdateProduct(
+		ctx,
+		r.Id,
+		Product{
+			Name:        r.Name,
+			Description: r.Description,
+			Price:       r.Price,
+			Category:    r.Category,
+			Stock:       r.Stock,
+		},
+		r.UpdateMask.GetPaths(),
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &pb.UpdateProductResponse{Product: productToProto(*p)}, nil
+}
+
+func (s *grpcServer) DeleteProduct(ctx context.Context, r *pb.DeleteProductRequest) (*pb.DeleteProductResponse, error) {
+	if err := s.service.DeleteProduct(ctx, r.Id); err != nil {
+		return nil, err
+	}
+	return &pb.DeleteProductResponse{}, nil
+}
+
+func (s *grpcServer) ReserveStock(ctx context.Context, r *pb.ReserveStockRequest) (*pb.ReserveStockResponse, error) {
+	err := s.service.ReserveStock(ctx, r.ReservationId, stockItemsFromProto(r.Items))
+	if err == ErrInsufficientStock {
+		// the client turns it back into ErrInsufficientStock
+		return nil, status.Error(codes.FailedPrecondition, err.Error())
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &pb.ReserveStockResponse{}, nil
+}
+
+func (s *grpcServer) ReleaseStock(ctx context.Context, r *pb.ReleaseStockRequest) (*pb.ReleaseStockResponse, error) {
+	if err := s.service.ReleaseStock(ctx, r.ReservationId, stockItemsFromProto(r.Items)); err != nil {
+		return nil, err
+	}
+	return &pb.ReleaseStockResponse{}, nil
+}
+
+func (s *grpcServer) ConfirmStock(ctx context.Context, r *pb.ConfirmStockRequest) (*pb.ConfirmStockResponse, error) {
+	if err := s.service.ConfirmStock(ctx, r.ReservationId, stockItemsFromProto(r.Items)); err != nil {
+		return nil, err
+	}
+	return &pb.ConfirmStockResponse{}, nil
+}
+
+func stockItemsFromProto(items []*pb.StockItem) []StockItem {
+	stockItems := make([]StockItem, 0, len(items))
+	for _, item := range items {
+		stockItems = append(stockItems, StockItem{ProductID: item.ProductId, Quantity: item.Quantity})
+	}
+	return stockItems
+}
+
+func productToProto(p Product) *pb.Product {
+	return &pb.Product{
+		Id:          p.ID,
+		Name:        p.Name,
+		Description: p.Description,
+		Price:       p.Price,
+		Category:    p.Category,
+		Stock:       p.Stock,
+		Deleted:     p.Deleted,
+		Score:       p.Score,
+		Cursor:      p.Cursor,
+	}
+}
+
+func facetsToProto(f *Facets) *pb.Facets {
+	if f == nil {
+		return nil
+	}
+	facets := &pb.Facets{}
+	for _, b := range f.Prices {
+		facets.Prices = append(facets.Prices, &pb.Facets_PriceBucket{
+			From:  b.From,
+			To:    b.To,
+			Count: b.Count,
+		})
+	}
+	for _, b := range f.Categories {
+		facets.Categories = append(facets.Categories, &pb.Facets_CategoryBucket{
+			Category: b.Category,
+			Count:    b.Count,
+		})
+	}
+	return facets
 }
@@ -2,16 +2,48 @@ package catalog
 
 import (
 	"context"
+	"errors"
+	"strings"
 
 	"github.com/segmentio/ksuid"
 )
 
+// Product fields that can be used in the update mask of UpdateProduct
+const (
+	FieldName        = "name"
+	FieldDescription = "description"
+	FieldPrice       = "price"
+	FieldCategory    = "category"
+	// FieldStock sets the stock level, it has to be in the mask explicitly
+	FieldStock = "stock"
+)
+
+var ErrInvalidUpdateMask = errors.New("invalid update mask")
+
 type Service interface {
-	PostProduct(ctx context.Context, name, description string, price float64) (*Product, error)
+	PostProduct(ctx context.Context, name, description string, price float64, category string, stock uint64) (*Product, error)
 	GetProduct(ctx context.Context, id string) (*Product, error)
-	GetProducts(ctx context.Context, skip uint64, take uint64) ([]Product, error)
+	// GetProducts lists products newest first, unless q sorts them differently.
+	// pageToken is the Cursor of the last product of the previous page, it's used instead of skip.
+	// The facets count all the products matching the filters of q, not just the page.
+	GetProducts(ctx context.Context, skip uint64, take uint64, pageToken string, q ProductQuery) ([]Product, *Facets, error)
 	GetProductsByIDs(ctx context.Context, ids []string) ([]Product, error)
-	SearchProducts(ctx context.Context, query string, skip uint64, take uint64) ([]Product, error)
+	// SearchProducts finds products matching the query, pageToken and q work like in GetProducts
+	SearchProducts(ctx context.Context, query string, skip uint64, take uint64, pageToken string, q ProductQuery) ([]Product, *Facets, error)
+	// SuggestProducts autocompletes a partial product name, for search as you type.
+	// size defaults to 5 and is capped at 10.
+	SuggestProducts(ctx context.Context, prefix string, size uint64) ([]Product, error)
+	UpdateProduct(ctx context.Context, id string, patch Product, mask []string) (*Product, error)
+	DeleteProduct(ctx context.Context, id string) error
+	// ReserveStock takes the quantities from the stock of the products, either all of them or none.
+	// It returns ErrInsufficientStock if a product doesn't have enough stock or is deleted.
+	// With a reservationID what it took is recorded, see stock.go, calling it again with the ID takes nothing.
+	ReserveStock(ctx context.Context, reservationID string, items []StockItem) error
+	// ReleaseStock puts reserved quantities back into stock, e.g. when an order is cancelled.
+	// With a reservationID only what the reservation took is put back.
+	ReleaseStock(ctx context.Context, reservationID string, items []StockItem) error
+	// ConfirmStock drops the record of the reservation once its order is saved, the stock stays taken
+	ConfirmStock(ctx context.Context, reservationID string, items []StockItem) error
 }
 
 type Product struct {
@@ -19,6 +51,16 @@ type Product struct {
 	Name        string  `json:"name"`
 	Description string  `json:"description"`
 	Price       float64 `json:"price"`
+	Category    string  `json:"category,omitempty"`
+	Deleted     bool    `json:"deleted"`
+	// Stock is the quantity available to order
+	Stock uint64 `json:"stock"`
+	// Score is the relevance of the product to the query, only set by SearchProducts.
+	// Search results are ordered by it, highest first.
+	Score float64 `json:"score,omitempty"`
+	// Cursor is the page token to continue listing or searching after this product.
+	// It's only set on products returned by GetProducts and SearchProducts.
+	Cursor string `json:"-"`
 }
 
 type catalogService struct {
@@ -29,12 +71,14 @@ func NewService(r Repository) Service {
 	return &catalogService{r}
 }
 
-func (s *catalogService) PostProduct(ctx context.Context, name, description string, price float64) (*Product, error) {
+func (s *catalogService) PostProduct(ctx context.Context, name, description string, price float64, category string, stock uint64) (*Product, error) {
 	p := &Product{
 		ID:          ksuid.New().String(),
 		Name:        name,
 		Description: description,
 		Price:       price,
+		Category:    category,
+		Stock:       stock,
 	}
 
 	if err := s.repository.PutProduct(ctx, *p); err != nil {
@@ -47,20 +91,118 @@ func (s *catalogService) GetProduct(ctx context.Context, id string) (*Product, e
 	return s.repository.GetProductByID(ctx, id)
 }
 
-func (s *catalogService) GetProducts(ctx context.Context, skip uint64, take uint64) ([]Product, error) {
+func (s *catalogService) GetProducts(ctx context.Context, skip uint64, take uint64, pageToken string, q ProductQuery) ([]Product, *Facets, error) {
 	if take > 100 || (skip == 0 && take == 0) {
 		take = 100
 	}
-	return s.repository.ListProducts(ctx, skip, take)
+	if err := q.Filter.validate(); err != nil {
+		return nil, nil, err
+	}
+	return s.repository.ListProducts(ctx, skip, take, pageToken, q)
 }
 
 func (s *catalogService) GetProductsByIDs(ctx context.Context, ids []string) ([]Product, error) {
 	return s.repository.ListProductsWithIDs(ctx, ids)
 }
 
-func (s *catalogService) SearchProducts(ctx context.Context, query string, skip uint64, take uint64) ([]Product, error) {
+func (s *catalogService) SearchProducts(ctx context.Context, query string, skip uint64, take uint64, pageToken string, q ProductQuery) ([]Product, *Facets, error) {
 	if take > 100 || (skip == 0 && take == 0) {
 		take = 100
 	}
-	return s.repository.SearchProducts(ctx, query, skip, take)
+	if err := q.Filter.validate(); err != nil {
+		return nil, nil, err
+	}
+	return s.repository.SearchProducts(ctx, query, skip, take, pageToken, q)
+}
+
+func (s *catalogService) SuggestProducts(ctx context.Context, prefix string, size uint64) ([]Product, error) {
+	prefix = strings.TrimSpace(prefix)
+	if prefix == "" {
+		return []Product{}, nil
+	}
+	if size == 0 {
+		size = 5
+	}
+	if size > 10 {
+		size = 10
+	}
+	return s.repository.SuggestProducts(ctx, prefix, int(size))
+}
+
+// UpdateProduct copies the fields listed in mask from patch to the product.
+// An empty mask updates all fields but the stock, so a client that doesn't know about stock can't reset it.
+// The stock is set on its own, orders may be reserving it at the same time. Deleted products can't be updated.
+func (s *catalogService) UpdateProduct(ctx context.Context, id string, patch Product, mask []string) (*Product, error) {
+	p, err := s.repository.GetProductByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if p.Deleted {
+		return nil, ErrNotFound
+	}
+
+	if len(mask) == 0 {
+		mask = []string{FieldName, FieldDescription, FieldPrice, FieldCategory}
+	}
+	updateFields, setStock := false, false
+	for _, field := range mask {
+		switch field {
+		case FieldStock:
+			p.Stock = patch.Stock
+			setStock = true
+			continue
+		case FieldName:
+			p.Name = patch.Name
+		case FieldDescription:
+			p.Description = patch.Description
+		case FieldPrice:
+			p.Price = patch.Price
+		case FieldCategory:
+			p.Category = patch.Category
+		default:
+			return nil, ErrInvalidUpdateMask
+		}
+		updateFields = true
+	}
+
+	if updateFields {
+		if err := s.repository.UpdateProduct(ctx, *p); err != nil {
+			return nil, err
+		}
+	}
+	if setStock {
+		if err := s.repository.SetStock(ctx, id, p.Stock); err != nil {
+			return nil, err
+		}
+	}
+	return p, nil
+}
+
+// DeleteProduct archives the product, see elasticRepository.DeleteProduct
+func (s *catalogService) DeleteProduct(ctx context.Context, id string) error {
+	return s.repository.DeleteProduct(ctx, id)
+}
+
+func (s *catalogService) ReserveStock(ctx context.Context, reservationID string, items []StockItem) error {
+	items = mergeStockItems(items)
+	if len(items) == 0 {
+		return nil
+	}
+	return s.repository.ReserveStock(ctx, reservationID, items)
+}
+
+func (s *catalogService) ReleaseStock(ctx context.Context, reservationID string, items []StockItem) error {
+	items = mergeStockItems(items)
+	if len(items) == 0 {
+		return nil
+	}
+	return s.repository.ReleaseStock(ctx, reservationID, items)
+}
+
+func (s *catalogService) ConfirmStock(ctx context.Context, reservationID string, items []StockItem) error {
+	items = mergeStockItems(items)
+	if len(items) == 0 || reservationID == "" {
+		return nil
+	}
+	return s.repository.ConfirmStock(ctx, reservationID, items)
 }
@@ -0,0 +1,133 @@
+package catalog
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sort"
+)
+
+var ErrInsufficientStock = errors.New("insufficient stock")
+
+// StockItem is a quantity of a product to reserve or release
+type StockItem struct {
+	ProductID string `json:"product_id"`
+	Quantity  uint64 `json:"quantity"`
+}
+
+// mergeStockItems adds up the quantities of the same product and sorts the items by product ID.
+// Repositories get every product once, and always lock them in the same order.
+func mergeStockItems(items []StockItem) []StockItem {
+	quantities := map[string]uint64{}
+	for _, item := range items {
+		quantities[item.ProductID] += item.Quantity
+	}
+	merged := make([]StockItem, 0, len(quantities))
+	for id, quantity := range quantities {
+		if quantity > 0 {
+			merged = append(merged, StockItem{ProductID: id, Quantity: quantity})
+		}
+	}
+	sort.Slice(merged, func(i, j int) bool { return merged[i].ProductID < merged[j].ProductID })
+	return merged
+}
+
+// Stock reservations of orders have an ID, the order service uses the order ID. The catalog records what
+// a reservation took, so reserving again with the ID doesn't take the stock twice, and releasing it only
+// gives back what it took, nothing if it never got any. Once the order is saved the order service
+// confirms the reservation, which drops the record, the stock of a cancelled order is released without an ID.
+// Reservations without an ID aren't recorded.
+
+// Search engines can't update several documents atomically, but a script updates one document atomically.
+// The reservations are recorded in the product document, reservation ID to quantity, so the stock and
+// the record change together. reserveScript only takes the stock if there is enough of it, otherwise it
+// leaves the product unchanged and the update reports a noop. A product the reservation has taken stock of
+// already is written back unchanged, which isn't a noop. Products indexed before stock was tracked have none.
+const (
+	reserveScript = `long stock = ctx._source.stock == null ? 0 : ctx._source.stock;
+boolean reserved = params.reservation != null && ctx._source.reservations != null
+  && ctx._source.reservations.containsKey(params.reservation);
+if (!reserved) {
+  if (ctx._source.deleted == true || stock < params.quantity) {
+    ctx.op = 'none';
+  } else {
+    ctx._source.stock = stock - params.quantity;
+    if (params.reservation != null) {
+      if (ctx._source.reservations == null) {
+        ctx._source.reservations = new HashMap();
+      }
+      ctx._source.reservations[params.reservation] = params.quantity;
+    }
+  }
+}`
+	releaseScript = `long stock = ctx._source.stock == null ? 0 : ctx._source.stock;
+if (params.reservation == null) {
+  ctx._source.stock = stock + params.quantity;
+} else if (ctx._source.reservations != null && ctx._source.reservations.containsKey(params.reservation)) {
+  ctx._source.stock = stock + ((Number) ctx._source.reservations.remove(params.reservation)).longValue();
+} else {
+  ctx.op = 'none';
+}`
+	confirmScript = `if (ctx._source.reservations != null && ctx._source.reservations.containsKey(params.reservation)) {
+  ctx._source.reservations.remove(params.reservation);
+} else {
+  ctx.op = 'none';
+}`
+)
+
+// stockRetries is how often a stock update is retried when another update of the product got in between
+const stockRetries = 5
+
+// stockScripter is implemented by the search engine repositories
+type stockScripter interface {
+	// updateStock runs a stock script with the params on the product.
+	// It returns false if the script left the product unchanged.
+	updateStock(ctx context.Context, id string, script string, params map[string]interface{}) (bool, error)
+}
+
+// stockParams are the params of the stock scripts, reservation is left out if there is no reservation ID
+func stockParams(reservationID string, quantity uint64) map[string]interface{} {
+	params := map[string]interface{}{"quantity": quantity}
+	if reservationID != "" {
+		params["reservation"] = reservationID
+	}
+	return params
+}
+
+// reserveStock reserves the items one product at a time. If a product doesn't have enough stock
+// the products reserved before it are released again, so either all items are reserved or none.
+// The stock never goes below 0, but other orders can see it taken for a moment.
+func reserveStock(ctx context.Context, s stockScripter, reservationID string, items []StockItem) error {
+	for i, item := range items {
+		updated, err := s.updateStock(ctx, item.ProductID, reserveScript, stockParams(reservationID, item.Quantity))
+		if err == nil && !updated {
+			err = ErrInsufficientStock
+		}
+		if err != nil {
+			// the release has to happen even if the request was cancelled
+			if releaseErr := releaseStock(context.WithoutCancel(ctx), s, reservationID, items[:i]); releaseErr != nil {
+				log.Println("Error releasing stock: ", releaseErr)
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+func releaseStock(ctx context.Context, s stockScripter, reservationID string, items []StockItem) error {
+	for _, item := range items {
+		if _, err := s.updateStock(ctx, item.ProductID, releaseScript, stockParams(reservationID, item.Quantity)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func confirmStock(ctx context.Context, s stockScripter, reservationID string, items []StockItem) error {
+	for _, item := range items {
+		if _, err := s.updateStock(ctx, item.ProductID, confirmScript, stockParams(reservationID, 0)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,32 @@
+-- products for the postgres catalog repository, the default one stores them in elasticsearch
+CREATE TABLE IF NOT EXISTS products (
+    id CHAR(27) PRIMARY KEY,
+    name VARCHAR(255) NOT NULL,
+    description TEXT NOT NULL,
+    price MONEY NOT NULL,
+    -- empty if the product has no category
+    category VARCHAR(255) NOT NULL DEFAULT '',
+    -- the quantity available to order, reservations can't take it below 0
+    stock BIGINT NOT NULL DEFAULT 0 CHECK (stock >= 0),
+    -- deleted products are kept for historical orders but left out of listings and search
+    deleted BOOLEAN NOT NULL DEFAULT FALSE,
+    -- search_vector is searched by SearchProducts, words of the name weigh more than the description
+    search_vector TSVECTOR NOT NULL,
+    -- suggest_vector holds the words of the name unstemmed, for prefix matches by SuggestProducts
+    suggest_vector TSVECTOR NOT NULL
+);
+
+-- tables created before stock was tracked
+ALTER TABLE products ADD COLUMN IF NOT EXISTS stock BIGINT NOT NULL DEFAULT 0 CHECK (stock >= 0);
+
+CREATE INDEX IF NOT EXISTS products_search_vector_idx ON products USING GIN (search_vector);
+CREATE INDEX IF NOT EXISTS products_suggest_vector_idx ON products USING GIN (suggest_vector);
+CREATE INDEX IF NOT EXISTS products_category_idx ON products (category);
+
+-- what the stock reservations of orders took, until the order service confirms or releases them
+CREATE TABLE IF NOT EXISTS stock_reservations (
+    reservation_id VARCHAR(64) NOT NULL,
+    product_id CHAR(27) NOT NULL,
+    quantity BIGINT NOT NULL,
+    PRIMARY KEY (reservation_id, product_id)
+);
@@ -0,0 +1,182 @@
+// shop runs the account, catalog and order services and the graphql gateway in one process,
+// for local development. The services talk gRPC over in-memory connections and keep their
+// data in memory, so nothing but Go is needed:
+//
+//	go run ./cmd/shop
+//
+// Everything is lost when the process exits.
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/haroonalbar/go-grpc-graphql-microservices/account"
+	"github.com/haroonalbar/go-grpc-graphql-microservices/catalog"
+	"github.com/haroonalbar/go-grpc-graphql-microservices/graphql"
+	"github.com/haroonalbar/go-grpc-graphql-microservices/order"
+	"github.com/kelseyhightower/envconfig"
+	"github.com/segmentio/ksuid"
+	"golang.org/x/crypto/bcrypt"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+type Config struct {
+	Port int `envconfig:"PORT" default:"8080"`
+	// PEM encoded RSA private key to sign tokens with, a new key is generated on every start if it's empty
+	JWTPrivateKeyFile string `envconfig:"JWT_PRIVATE_KEY_FILE"`
+	// The admin account created on start, it can create products and update orders
+	AdminEmail    string `envconfig:"ADMIN_EMAIL" default:"admin@example.com"`
+	AdminPassword string `envconfig:"ADMIN_PASSWORD" default:"admin-password"`
+	// StaticDir has the demo frontend
+	StaticDir string `envconfig:"STATIC_DIR" default:"./static"`
+}
+
+// bufferSize is the buffer of every in-memory connection
+const bufferSize = 1024 * 1024
+
+func main() {
+	var cfg Config
+	if err := envconfig.Process("", &cfg); err != nil {
+		log.Fatalf("Error processing env: %v", err)
+	}
+
+	tokens, err := newTokenIssuer(cfg.JWTPrivateKeyFile)
+	if err != nil {
+		log.Fatalf("Error loading jwt signing key: %v", err)
+	}
+	serviceToken, err := randomToken()
+	if err != nil {
+		log.Fatalf("Error generating service token: %v", err)
+	}
+
+	// Every service listens on an in-memory listener, the clients dial them by name
+	listeners := map[string]*bufconn.Listener{
+		"account": bufconn.Listen(bufferSize),
+		"catalog": bufconn.Listen(bufferSize),
+		"order":   bufconn.Listen(bufferSize),
+	}
+	dialer := grpc.WithContextDialer(func(ctx context.Context, name string) (net.Conn, error) {
+		lis, ok := listeners[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown service %q", name)
+		}
+		return lis.DialContext(ctx)
+	})
+
+	// account
+	accountRepository := account.NewMemoryRepository()
+	if err := createAdmin(accountRepository, cfg.AdminEmail, cfg.AdminPassword); err != nil {
+		log.Fatalf("Error creating admin account: %v", err)
+	}
+	serve("account", listeners, account.NewGRPCServer(
+		account.NewService(accountRepository, tokens),
+		tokens.TokenVerifier,
+		serviceToken,
+	))
+
+	// catalog
+	serve("catalog", listeners, catalog.NewGRPCServer(
+		catalog.NewService(catalog.NewMemoryRepository()),
+		tokens.TokenVerifier,
+		serviceToken,
+	))
+
+	// order, it looks up accounts and products and reserves stock like the standalone service.
+	// Its events go to an in-process bus, consumers subscribe to it like the logger below.
+	orderRepository := order.NewMemoryRepository()
+	bus := order.NewMemoryEventBus()
+	bus.Subscribe(context.Background(), "log", func(ctx context.Context, e order.Event) error {
+		log.Printf("Order event %s of order %s", e.Type, e.OrderID)
+		return nil
+	})
+	go order.NewRelay(orderRepository, bus, time.Second).Run(context.Background())
+	accountClient, err := account.NewClient("account", dialer)
+	if err != nil {
+		log.Fatalf("Error connecting to account service: %v", err)
+	}
+	defer accountClient.Close()
+	catalogClient, err := catalog.NewClient("catalog", dialer)
+	if err != nil {
+		log.Fatalf("Error connecting to catalog service: %v", err)
+	}
+	defer catalogClient.Close()
+	inventory := order.NewCatalogInventory(catalogClient, serviceToken)
+	go order.NewReconciler(orderRepository, inventory, 10*time.Minute, time.Minute).Run(context.Background())
+	orderService, err := order.NewService(orderRepository, inventory, bus)
+	if err != nil {
+		log.Fatalf("Error setting up order service: %v", err)
+	}
+	serve("order", listeners, order.NewGRPCServer(
+		orderService,
+		accountClient,
+		catalogClient,
+		tokens.TokenVerifier,
+		serviceToken,
+	))
+
+	// graphql gateway
+	s, err := graphql.NewGraphQLServer("account", "catalog", "order", dialer)
+	if err != nil {
+		log.Fatalf("Error setting Graphql server: %v", err)
+	}
+
+	log.Printf("Listening on port %d, playground at http://localhost:%d/playground", cfg.Port, cfg.Port)
+	log.Printf("Log in as admin with %s and %s", cfg.AdminEmail, cfg.AdminPassword)
+	log.Fatal(http.ListenAndServe(fmt.Sprintf(":%d", cfg.Port), s.Handler(tokens.TokenVerifier, cfg.StaticDir)))
+}
+
+// serve runs the gRPC server of a service on its in-memory listener
+func serve(name string, listeners map[string]*bufconn.Listener, s *grpc.Server) {
+	go func() {
+		if err := s.Serve(listeners[name]); err != nil {
+			log.Fatalf("Error serving %s service: %v", name, err)
+		}
+	}()
+}
+
+func newTokenIssuer(privateKeyFile string) (*account.TokenIssuer, error) {
+	if privateKeyFile != "" {
+		return account.NewTokenIssuer(privateKeyFile)
+	}
+	// tokens signed with the key stop working when the process exits, like the data
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, err
+	}
+	return account.NewTokenIssuerFromKey(key), nil
+}
+
+// randomToken is the secret the services share to call each other
+func randomToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// createAdmin adds an account with the admin role. Registered accounts only get the user role,
+// the standalone services need a database update to make one an admin.
+func createAdmin(r account.Repository, email, password string) error {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+	return r.PutAccount(context.Background(), account.Account{
+		ID:           ksuid.New().String(),
+		Name:         "Admin",
+		Email:        strings.ToLower(email),
+		Roles:        []string{account.RoleUser, account.RoleAdmin},
+		PasswordHash: string(hash),
+	})
+}
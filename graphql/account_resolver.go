@@ -1,4 +1,4 @@
-package main
+package graphql
 
 import (
 	"context"
@@ -11,33 +11,64 @@ type accountResolver struct {
 }
 
 // Orders are in accountResolver cause it's dependent on account
+// The orders of all accounts in a query are fetched with a single GetOrdersForAccounts call
 func (r *accountResolver) Orders(ctx context.Context, acc *Account) ([]*Order, error) {
 	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
+	if err := authorizeAccount(ctx, acc.ID); err != nil {
+		return nil, err
+	}
 
-	orderList, err := r.server.orderClient.GetOrderForAccount(ctx, acc.ID)
+	// batched with the other accounts of the request, see dataloader.go
+	orderList, err := loadersFromContext(ctx).ordersByAccount.Load(ctx, acc.ID)
 	if err != nil {
 		log.Println("Error getting orders for account from order client: ", err)
 		return nil, err
 	}
 	var orders []*Order
 	for _, o := range orderList {
-		var products []*OrderedProduct
-		for _, op := range o.Products {
-			products = append(products, &OrderedProduct{
-				ID:          op.ID,
-				Name:        op.Name,
-				Description: op.Description,
-				Price:       op.Price,
-				Quantity:    int(op.Quantity),
-			})
-		}
-		orders = append(orders, &Order{
-			ID:         o.ID,
-			CreatedAt:  o.CreatedAt,
-			TotalPrice: o.TotalPrice,
-			Products:   products,
-		})
+		orders = append(orders, toOrder(&o))
 	}
 	return orders, nil
 }
+
+// OrdersConnection pages through the orders of the account with order IDs as cursors.
+// It isn't batched like Orders, since every account is paged on its own.
+func (r *accountResolver) OrdersConnection(ctx context.Context, acc *Account, first *int, after *string) (*OrderConnection, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	if err := authorizeAccount(ctx, acc.ID); err != nil {
+		return nil, err
+	}
+
+	size, err := pageSize(first)
+	if err != nil {
+		return nil, err
+	}
+	afterID, err := decodeCursor(after)
+	if err != nil {
+		return nil, err
+	}
+
+	conn := &OrderConnection{Edges: []*OrderEdge{}, PageInfo: &PageInfo{}}
+	if size == 0 {
+		return conn, nil
+	}
+
+	// one more than requested to know if there is a next page
+	orderList, err := r.server.orderClient.GetOrderForAccount(ctx, acc.ID, afterID, uint64(size+1))
+	if err != nil {
+		log.Println("Error getting orders for account from order client: ", err)
+		return nil, err
+	}
+
+	var n int
+	conn.PageInfo, n = newPageInfo(len(orderList), size, func(i int) string { return encodeCursor(orderList[i].ID) })
+	for _, o := range orderList[:n] {
+		conn.Edges = append(conn.Edges, &OrderEdge{
+			Cursor: encodeCursor(o.ID),
+			Node:   toOrder(&o),
+		})
+	}
+	return conn, nil
+}
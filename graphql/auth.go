@@ -0,0 +1,138 @@
+package graphql
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/99designs/gqlgen/graphql"
+	"github.com/99designs/gqlgen/graphql/handler/transport"
+	"github.com/haroonalbar/go-grpc-graphql-microservices/account"
+	"github.com/haroonalbar/go-grpc-graphql-microservices/auth"
+)
+
+var (
+	ErrUnauthenticated = errors.New("unauthenticated")
+	ErrForbidden       = errors.New("forbidden")
+)
+
+type contextKey string
+
+const callerKey contextKey = "caller"
+
+// authMiddleware validates the bearer token of the request, if there is one,
+// and puts the claims of the caller into the request context.
+// Requests without a token pass through anonymously, the @auth and @hasRole
+// directives decide what they can access. Invalid tokens are rejected.
+func authMiddleware(verifier *account.TokenVerifier, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header := r.Header.Get("Authorization")
+		if header == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		ctx, err := authenticate(r.Context(), verifier, header)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// websocketInit does the same as authMiddleware for WebSocket connections.
+// Browsers can't set headers on WebSocket requests, so the token is sent
+// in the Authorization field of the connection_init payload instead.
+func websocketInit(verifier *account.TokenVerifier) transport.WebsocketInitFunc {
+	return func(ctx context.Context, payload transport.InitPayload) (context.Context, *transport.InitPayload, error) {
+		header := payload.Authorization()
+		if header == "" {
+			return ctx, nil, nil
+		}
+		ctx, err := authenticate(ctx, verifier, header)
+		return ctx, nil, err
+	}
+}
+
+// authenticate verifies the value of an Authorization header and returns a context carrying the caller
+func authenticate(ctx context.Context, verifier *account.TokenVerifier, header string) (context.Context, error) {
+	token, ok := strings.CutPrefix(header, "Bearer ")
+	if !ok {
+		return nil, errors.New("invalid authorization header")
+	}
+	claims, err := verifier.Verify(token, account.TokenTypeAccess)
+	if err != nil {
+		return nil, err
+	}
+	// the token is forwarded to the services by the clients, they check permissions themselves
+	return auth.WithToken(context.WithValue(ctx, callerKey, claims), token), nil
+}
+
+// callerFromContext returns the claims of the authenticated caller, or nil for anonymous requests
+func callerFromContext(ctx context.Context) *account.Claims {
+	claims, _ := ctx.Value(callerKey).(*account.Claims)
+	return claims
+}
+
+func hasRole(claims *account.Claims, role string) bool {
+	if claims == nil {
+		return false
+	}
+	for _, r := range claims.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// authorizeAccount allows the owner of the account and admins
+func authorizeAccount(ctx context.Context, accountID string) error {
+	caller := callerFromContext(ctx)
+	if caller == nil {
+		return ErrUnauthenticated
+	}
+	if caller.AccountID() != accountID && !hasRole(caller, account.RoleAdmin) {
+		return ErrForbidden
+	}
+	return nil
+}
+
+// targetAccount returns the account a request acts on, the caller's own account unless accountID is set.
+// Only admins can act on other accounts.
+func targetAccount(ctx context.Context, accountID *string) (string, error) {
+	caller := callerFromContext(ctx)
+	if caller == nil {
+		return "", ErrUnauthenticated
+	}
+	if accountID != nil && *accountID != caller.AccountID() {
+		if err := authorizeAccount(ctx, *accountID); err != nil {
+			return "", err
+		}
+		return *accountID, nil
+	}
+	return caller.AccountID(), nil
+}
+
+// authDirective implements @auth, the field requires an authenticated caller
+func authDirective(ctx context.Context, obj interface{}, next graphql.Resolver) (interface{}, error) {
+	if callerFromContext(ctx) == nil {
+		return nil, ErrUnauthenticated
+	}
+	return next(ctx)
+}
+
+// hasRoleDirective implements @hasRole(role), the caller needs to have the role
+func hasRoleDirective(ctx context.Context, obj interface{}, next graphql.Resolver, role Role) (interface{}, error) {
+	caller := callerFromContext(ctx)
+	if caller == nil {
+		return nil, ErrUnauthenticated
+	}
+	// account service uses lower case roles, graphql enums are upper case
+	if !hasRole(caller, strings.ToLower(string(role))) {
+		return nil, ErrForbidden
+	}
+	return next(ctx)
+}
@@ -4,9 +4,8 @@ import (
 	"log"
 	"net/http"
 
-	// "github.com/99designs/gqlgen/handler"
-
-	"github.com/99designs/gqlgen/handler"
+	"github.com/haroonalbar/go-grpc-graphql-microservices/account"
+	"github.com/haroonalbar/go-grpc-graphql-microservices/graphql"
 	"github.com/kelseyhightower/envconfig"
 )
 
@@ -23,6 +22,8 @@ type AppConfig struct {
 	AccountUrl string `envconfig:"ACCOUNT_SERVICE_URL"`
 	CatalogUrl string `envconfig:"CATALOG_SERVICE_URL"`
 	OrderUrl   string `envconfig:"ORDER_SERVICE_URL"`
+	// PEM encoded RSA public key of the account service, used to verify access tokens
+	JWTPublicKeyFile string `envconfig:"JWT_PUBLIC_KEY_FILE" default:"/keys/jwt.pub.pem"`
 }
 
 func main() {
@@ -54,31 +55,23 @@ func main() {
 		log.Println("OrderUrl is empty in configuration")
 	}
 
+	verifier, err := account.NewTokenVerifier(cfg.JWTPublicKeyFile)
+	if err != nil {
+		log.Fatalf("Error loading jwt public key: %v", err)
+	}
+
 	// graph.go
 	// Create a Graphql server
-	s, err := NewGraphQLServer(cfg.AccountUrl, cfg.CatalogUrl, cfg.OrderUrl)
+	s, err := graphql.NewGraphQLServer(cfg.AccountUrl, cfg.CatalogUrl, cfg.OrderUrl)
 	if err != nil {
 		log.Fatalf("Error setting Graphql server: %v", err)
 	}
 
-	// // NOTE: updated to new serve mux instead of default one
-	// mux := http.NewServeMux()
-
-	// // sets up the main GraphQL endpoint where clients can send queries and mutations.
-	// mux.Handle("/graphql", handler.New(s.ToExecutableSchema()))
-	// // provides a web-based GraphQL Playground interface for easy testing and exploration of the GraphQL API.
-	// mux.Handle("/playground", playground.Handler("play", "/graphql"))
-	//
-	http.Handle("/", http.FileServer(http.Dir("./static")))
-
-	http.Handle("/graphql", handler.GraphQL(s.ToExecutableSchema()))
-	http.Handle("/playground", handler.Playground("play", "/graphql"))
-
 	// // Replace deprecated handler.GraphQL with handler.New
 	// http.Handle("/graphql", handler.New(s.ToExecutableSchema()))
 	// http.Handle("/playground", playground.Handler("play", "/graphql"))
 
 	// Run server
 	log.Println("Listening on port 8080")
-	log.Fatal(http.ListenAndServe(":8080", nil))
+	log.Fatal(http.ListenAndServe(":8080", s.Handler(verifier, "./static")))
 }
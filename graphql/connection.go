@@ -0,0 +1,60 @@
+package graphql
+
+import (
+	"encoding/base64"
+	"errors"
+)
+
+// maxPageSize stays below the 100 results the services return at most,
+// the resolvers fetch one extra node to find out if there is a next page.
+const (
+	defaultPageSize = 20
+	maxPageSize     = 50
+)
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// pageSize returns how many nodes to return for the first argument of a connection field
+func pageSize(first *int) (int, error) {
+	if first == nil {
+		return defaultPageSize, nil
+	}
+	if *first < 0 {
+		return 0, errors.New("first can't be negative")
+	}
+	if *first > maxPageSize {
+		return maxPageSize, nil
+	}
+	return *first, nil
+}
+
+// Cursors of accounts and orders wrap the ID of the node, the services page with `id < cursor`.
+// They are base64 encoded so clients treat them as opaque.
+// Products use the page tokens of the catalog service as cursors, those are opaque already.
+func encodeCursor(id string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(id))
+}
+
+// decodeCursor returns the ID in the after argument, or an empty string for the first page
+func decodeCursor(after *string) (string, error) {
+	if after == nil || *after == "" {
+		return "", nil
+	}
+	id, err := base64.RawURLEncoding.DecodeString(*after)
+	if err != nil {
+		return "", ErrInvalidCursor
+	}
+	return string(id), nil
+}
+
+// newPageInfo builds the page info of a page that was fetched with one node more than size.
+// That extra node only tells if there is a next page, it returns the number of nodes to keep.
+func newPageInfo(fetched int, size int, cursorOf func(i int) string) (*PageInfo, int) {
+	info := &PageInfo{HasNextPage: fetched > size}
+	n := min(fetched, size)
+	if n > 0 {
+		endCursor := cursorOf(n - 1)
+		info.EndCursor = &endCursor
+	}
+	return info, n
+}
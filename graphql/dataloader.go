@@ -0,0 +1,132 @@
+package graphql
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/haroonalbar/go-grpc-graphql-microservices/catalog"
+	"github.com/haroonalbar/go-grpc-graphql-microservices/order"
+)
+
+const (
+	// how long a loader waits for more keys before it sends the batch
+	loaderWait = 2 * time.Millisecond
+	// batches are sent right away once they reach this size
+	loaderMaxBatch = 100
+)
+
+// dataLoader coalesces the Load calls made while resolving one request into batched fetches.
+// Resolvers of sibling fields run concurrently, so an `accounts { orders }` query calls Load
+// once per account at about the same time. Those keys are collected for loaderWait and then
+// fetched with a single RPC. Results are cached for the rest of the request.
+type dataLoader[K comparable, V any] struct {
+	// fetch returns the values of the keys it found, missing keys get the zero value
+	fetch func(ctx context.Context, keys []K) (map[K]V, error)
+	ctx   context.Context
+
+	mu      sync.Mutex
+	batch   *loaderBatch[K, V]
+	batches map[K]*loaderBatch[K, V]
+}
+
+type loaderBatch[K comparable, V any] struct {
+	keys   []K
+	values map[K]V
+	err    error
+	done   chan struct{}
+}
+
+func newDataLoader[K comparable, V any](ctx context.Context, fetch func(ctx context.Context, keys []K) (map[K]V, error)) *dataLoader[K, V] {
+	return &dataLoader[K, V]{
+		fetch:   fetch,
+		ctx:     ctx,
+		batches: map[K]*loaderBatch[K, V]{},
+	}
+}
+
+// Load adds the key to the current batch and waits for the batch to be fetched
+func (l *dataLoader[K, V]) Load(ctx context.Context, key K) (V, error) {
+	l.mu.Lock()
+	b, ok := l.batches[key]
+	if !ok {
+		if l.batch == nil {
+			next := &loaderBatch[K, V]{done: make(chan struct{})}
+			l.batch = next
+			time.AfterFunc(loaderWait, func() { l.dispatch(next) })
+		}
+		b = l.batch
+		b.keys = append(b.keys, key)
+		l.batches[key] = b
+		if len(b.keys) >= loaderMaxBatch {
+			go l.dispatch(b)
+		}
+	}
+	l.mu.Unlock()
+
+	select {
+	case <-b.done:
+		return b.values[key], b.err
+	case <-ctx.Done():
+		var zero V
+		return zero, ctx.Err()
+	}
+}
+
+// dispatch fetches the batch, it's a no-op if the batch was already sent
+func (l *dataLoader[K, V]) dispatch(b *loaderBatch[K, V]) {
+	l.mu.Lock()
+	if l.batch != b {
+		l.mu.Unlock()
+		return
+	}
+	l.batch = nil
+	l.mu.Unlock()
+
+	// the batch isn't bound to a single resolver, so it uses the request context
+	ctx, cancel := context.WithTimeout(l.ctx, 3*time.Second)
+	defer cancel()
+	b.values, b.err = l.fetch(ctx, b.keys)
+	close(b.done)
+}
+
+// loaders are created for every request, so cached results never leak between requests or callers
+type loaders struct {
+	// orders of an account, batched into GetOrdersForAccounts
+	ordersByAccount *dataLoader[string, []order.Order]
+	// products by ID, batched into the Ids path of GetProducts
+	productByID *dataLoader[string, *catalog.Product]
+}
+
+const loadersKey contextKey = "loaders"
+
+func newLoaders(ctx context.Context, s *Server) *loaders {
+	return &loaders{
+		ordersByAccount: newDataLoader(ctx, s.orderClient.GetOrdersForAccounts),
+		productByID: newDataLoader(ctx, func(ctx context.Context, ids []string) (map[string]*catalog.Product, error) {
+			products, err := s.catalogClient.GetProducts(ctx, 0, 0, ids, "")
+			if err != nil {
+				return nil, err
+			}
+			m := map[string]*catalog.Product{}
+			for i := range products {
+				m[products[i].ID] = &products[i]
+			}
+			return m, nil
+		}),
+	}
+}
+
+// loaderMiddleware puts new loaders into the context of every request.
+// It runs after authMiddleware, so the batched calls forward the token of the caller.
+func loaderMiddleware(s *Server, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := context.WithValue(r.Context(), loadersKey, newLoaders(r.Context(), s))
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+func loadersFromContext(ctx context.Context) *loaders {
+	return ctx.Value(loadersKey).(*loaders)
+}
@@ -1,11 +1,15 @@
 //go:generate sh -c "go get github.com/99designs/gqlgen@v0.17.56 && go run github.com/99designs/gqlgen generate"
-package main
+package graphql
 
 import (
+	"net/http"
+
 	"github.com/99designs/gqlgen/graphql"
+	"github.com/99designs/gqlgen/handler"
 	"github.com/haroonalbar/go-grpc-graphql-microservices/account"
 	"github.com/haroonalbar/go-grpc-graphql-microservices/catalog"
 	"github.com/haroonalbar/go-grpc-graphql-microservices/order"
+	"google.golang.org/grpc"
 )
 
 type Server struct {
@@ -14,21 +18,22 @@ type Server struct {
 	orderClient   *order.Client
 }
 
-func NewGraphQLServer(accountUrl, catalogUrl, orderUrl string) (*Server, error) {
-	accountClient, err := account.NewClient(accountUrl)
+// NewGraphQLServer connects to the services, opts are added to the dial options of every client
+func NewGraphQLServer(accountUrl, catalogUrl, orderUrl string, opts ...grpc.DialOption) (*Server, error) {
+	accountClient, err := account.NewClient(accountUrl, opts...)
 	if err != nil {
 		return nil, err
 	}
 
 	// catalogClient is dependant on accountClient
-	catalogClient, err := catalog.NewClient(catalogUrl)
+	catalogClient, err := catalog.NewClient(catalogUrl, opts...)
 	if err != nil {
 		accountClient.Close()
 		return nil, err
 	}
 
 	// orderClient is dependant on both clients above
-	orderClient, err := order.NewClient(orderUrl)
+	orderClient, err := order.NewClient(orderUrl, opts...)
 	if err != nil {
 		accountClient.Close()
 		catalogClient.Close()
@@ -63,10 +68,41 @@ func (s *Server) Account() AccountResolver {
 	}
 }
 
+func (s *Server) Subscription() SubscriptionResolver {
+	// subscription_resolver.go
+	return &subscriptionResolver{
+		server: s,
+	}
+}
+
 // // ToExecutableSchema is converting the Server instance into an executable GraphQL schema.
 func (s *Server) ToExecutableSchema() graphql.ExecutableSchema {
 	return NewExecutableSchema(Config{
 		// server matches ResolverRoot interface in graphql
 		Resolvers: s,
+		// auth.go
+		Directives: DirectiveRoot{
+			Auth:    authDirective,
+			HasRole: hasRoleDirective,
+		},
 	})
 }
+
+// Handler serves the graphql endpoint, the playground and the demo frontend in staticDir.
+// verifier checks the access tokens of the requests.
+func (s *Server) Handler(verifier *account.TokenVerifier, staticDir string) http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/", http.FileServer(http.Dir(staticDir)))
+
+	// auth.go
+	// Bearer tokens are checked before the request reaches the graphql handler
+	// dataloader.go
+	// Every request gets its own DataLoaders to batch calls to the services
+	mux.Handle("/graphql", authMiddleware(verifier, loaderMiddleware(s, handler.GraphQL(
+		s.ToExecutableSchema(),
+		// subscriptions authenticate with the connection_init payload
+		handler.WebsocketInitFunc(websocketInit(verifier)),
+	))))
+	mux.Handle("/playground", handler.Playground("play", "/graphql"))
+	return mux
+}
@@ -1,7 +1,164 @@
-package main
+package graphql
+
+import (
+	"strings"
+
+	"github.com/haroonalbar/go-grpc-graphql-microservices/account"
+	"github.com/haroonalbar/go-grpc-graphql-microservices/catalog"
+	"github.com/haroonalbar/go-grpc-graphql-microservices/order"
+)
 
 type Account struct {
 	ID     string  `json:"id"`
 	Name   string  `json:"name"`
 	Orders []Order `json:"orders"`
 }
+
+// toOrder converts an order from the order client to the graphql Order
+func toOrder(o *order.Order) *Order {
+	var products []*OrderedProduct
+	for _, op := range o.Products {
+		products = append(products, &OrderedProduct{
+			ID:          op.ID,
+			Name:        op.Name,
+			Description: op.Description,
+			Price:       op.Price,
+			Quantity:    int(op.Quantity),
+			Discount:    op.Discount,
+		})
+	}
+	discounts := []*Discount{}
+	for _, d := range o.Discounts {
+		discount := &Discount{
+			CouponCode:  d.CouponCode,
+			Description: d.Description,
+			Amount:      d.Amount,
+		}
+		if d.ProductID != "" {
+			discount.ProductID = &d.ProductID
+		}
+		discounts = append(discounts, discount)
+	}
+	var couponCode *string
+	if o.CouponCode != "" {
+		couponCode = &o.CouponCode
+	}
+	return &Order{
+		ID:         o.ID,
+		CreatedAt:  o.CreatedAt,
+		Subtotal:   o.Subtotal,
+		TotalPrice: o.TotalPrice,
+		// order service uses lower case statuses, graphql enums are upper case
+		Status:     OrderStatus(strings.ToUpper(string(o.Status))),
+		Products:   products,
+		CouponCode: couponCode,
+		Discounts:  discounts,
+	}
+}
+
+func toCoupon(c *order.Coupon) *Coupon {
+	coupon := &Coupon{
+		Code: c.Code,
+		// order service uses lower case coupon types, graphql enums are upper case
+		Type:              CouponType(strings.ToUpper(string(c.Type))),
+		Value:             c.Value,
+		BuyQuantity:       int(c.BuyQuantity),
+		GetQuantity:       int(c.GetQuantity),
+		MinSpend:          c.MinSpend,
+		MaxUsesPerAccount: int(c.MaxUsesPerAccount),
+		CreatedAt:         c.CreatedAt,
+	}
+	if c.ProductID != "" {
+		coupon.ProductID = &c.ProductID
+	}
+	if !c.ValidFrom.IsZero() {
+		coupon.ValidFrom = &c.ValidFrom
+	}
+	if !c.ValidUntil.IsZero() {
+		coupon.ValidUntil = &c.ValidUntil
+	}
+	return coupon
+}
+
+func toCart(c *order.Cart) *Cart {
+	items := []*CartItem{}
+	for _, p := range c.Products {
+		items = append(items, &CartItem{
+			ProductID:         p.ID,
+			Name:              p.Name,
+			Description:       p.Description,
+			Price:             p.Price,
+			Quantity:          int(p.Quantity),
+			Available:         p.Available,
+			AvailableQuantity: int(p.AvailableQuantity),
+		})
+	}
+	return &Cart{
+		Items:      items,
+		TotalPrice: c.TotalPrice,
+	}
+}
+
+func toTokens(t *account.Tokens) *Tokens {
+	return &Tokens{
+		AccessToken:          t.AccessToken,
+		RefreshToken:         t.RefreshToken,
+		AccessTokenExpiresAt: t.AccessTokenExpiresAt,
+	}
+}
+
+// toProduct converts a catalog product, search results also get their relevance score
+func toProduct(p *catalog.Product, searched bool) *Product {
+	product := &Product{
+		ID:          p.ID,
+		Name:        p.Name,
+		Description: p.Description,
+		Price:       p.Price,
+		// deleted products can't be ordered anymore
+		AvailableQuantity: int(p.Stock),
+		InStock:           p.Stock > 0 && !p.Deleted,
+	}
+	if p.Category != "" {
+		category := p.Category
+		product.Category = &category
+	}
+	if searched {
+		score := p.Score
+		product.Score = &score
+	}
+	return product
+}
+
+// toProductQuery converts the filter and sort arguments of the product queries.
+// RELEVANCE is the default sort of the catalog, the others are the lower case enum value.
+func toProductQuery(filter *ProductFilterInput, sort *ProductSort, priceInterval *float64) catalog.ProductQuery {
+	var q catalog.ProductQuery
+	if filter != nil {
+		q.Filter = catalog.ProductFilter{
+			MinPrice:   filter.MinPrice,
+			MaxPrice:   filter.MaxPrice,
+			Categories: filter.Categories,
+		}
+	}
+	if sort != nil && *sort != ProductSortRelevance {
+		q.Sort = catalog.ProductSort(strings.ToLower(string(*sort)))
+	}
+	if priceInterval != nil {
+		q.PriceInterval = *priceInterval
+	}
+	return q
+}
+
+func toProductFacets(f *catalog.Facets) *ProductFacets {
+	facets := &ProductFacets{Prices: []*PriceBucket{}, Categories: []*CategoryBucket{}}
+	if f == nil {
+		return facets
+	}
+	for _, b := range f.Prices {
+		facets.Prices = append(facets.Prices, &PriceBucket{From: b.From, To: b.To, Count: int(b.Count)})
+	}
+	for _, b := range f.Categories {
+		facets.Categories = append(facets.Categories, &CategoryBucket{Category: b.Category, Count: int(b.Count)})
+	}
+	return facets
+}
@@ -1,28 +1,133 @@
 // Code generated by github.com/99designs/gqlgen, DO NOT EDIT.
 
-package main
+package graphql
 
 import (
+	"fmt"
+	"io"
+	"strconv"
 	"time"
 )
 
+type AccountConnection struct {
+	Edges    []*AccountEdge `json:"edges"`
+	PageInfo *PageInfo      `json:"pageInfo"`
+}
+
+type AccountEdge struct {
+	Cursor string   `json:"cursor"`
+	Node   *Account `json:"node"`
+}
+
 type AccountInput struct {
 	Name string `json:"name"`
 }
 
+type AuthPayload struct {
+	Account *Account `json:"account"`
+	Tokens  *Tokens  `json:"tokens"`
+}
+
+type Cart struct {
+	Items      []*CartItem `json:"items"`
+	TotalPrice float64     `json:"totalPrice"`
+}
+
+type CartItem struct {
+	ProductID         string  `json:"productId"`
+	Name              string  `json:"name"`
+	Description       string  `json:"description"`
+	Price             float64 `json:"price"`
+	Quantity          int     `json:"quantity"`
+	Available         bool    `json:"available"`
+	AvailableQuantity int     `json:"availableQuantity"`
+}
+
+type CartItemInput struct {
+	AccountID *string `json:"accountId,omitempty"`
+	ProductID string  `json:"productId"`
+	Quantity  int     `json:"quantity"`
+}
+
+type CategoryBucket struct {
+	Category string `json:"category"`
+	Count    int    `json:"count"`
+}
+
+type CheckoutInput struct {
+	AccountID      *string `json:"accountId,omitempty"`
+	IdempotencyKey *string `json:"idempotencyKey,omitempty"`
+	CouponCode     *string `json:"couponCode,omitempty"`
+}
+
+type Coupon struct {
+	Code              string     `json:"code"`
+	Type              CouponType `json:"type"`
+	Value             float64    `json:"value"`
+	ProductID         *string    `json:"productId,omitempty"`
+	BuyQuantity       int        `json:"buyQuantity"`
+	GetQuantity       int        `json:"getQuantity"`
+	MinSpend          float64    `json:"minSpend"`
+	ValidFrom         *time.Time `json:"validFrom,omitempty"`
+	ValidUntil        *time.Time `json:"validUntil,omitempty"`
+	MaxUsesPerAccount int        `json:"maxUsesPerAccount"`
+	CreatedAt         time.Time  `json:"createdAt"`
+}
+
+type CouponInput struct {
+	Code              string     `json:"code"`
+	Type              CouponType `json:"type"`
+	Value             *float64   `json:"value,omitempty"`
+	ProductID         *string    `json:"productId,omitempty"`
+	BuyQuantity       *int       `json:"buyQuantity,omitempty"`
+	GetQuantity       *int       `json:"getQuantity,omitempty"`
+	MinSpend          *float64   `json:"minSpend,omitempty"`
+	ValidFrom         *time.Time `json:"validFrom,omitempty"`
+	ValidUntil        *time.Time `json:"validUntil,omitempty"`
+	MaxUsesPerAccount *int       `json:"maxUsesPerAccount,omitempty"`
+}
+
+type Discount struct {
+	CouponCode  string  `json:"couponCode"`
+	ProductID   *string `json:"productId,omitempty"`
+	Description string  `json:"description"`
+	Amount      float64 `json:"amount"`
+}
+
+type LoginInput struct {
+	Email    string `json:"email"`
+	Password string `json:"password"`
+}
+
 type Mutation struct {
 }
 
 type Order struct {
 	ID         string            `json:"id"`
 	CreatedAt  time.Time         `json:"createdAt"`
+	Subtotal   float64           `json:"subtotal"`
 	TotalPrice float64           `json:"totalPrice"`
+	Status     OrderStatus       `json:"status"`
 	Products   []*OrderedProduct `json:"products"`
+	CouponCode *string           `json:"couponCode,omitempty"`
+	Discounts  []*Discount       `json:"discounts"`
+}
+
+type OrderConnection struct {
+	Edges    []*OrderEdge `json:"edges"`
+	PageInfo *PageInfo    `json:"pageInfo"`
+}
+
+type OrderEdge struct {
+	Cursor string `json:"cursor"`
+	Node   *Order `json:"node"`
 }
 
 type OrderInput struct {
-	AccountID string               `json:"accountId"`
-	Products  []*OrderProductInput `json:"products"`
+	AccountID      *string              `json:"accountId,omitempty"`
+	Products       []*OrderProductInput `json:"products"`
+	IdempotencyKey *string              `json:"idempotencyKey,omitempty"`
+	CouponCode     *string              `json:"couponCode,omitempty"`
 }
 
 type OrderProductInput struct {
@@ -36,6 +141,12 @@ type OrderedProduct struct {
 	Description string  `json:"description"`
 	Price       float64 `json:"price"`
 	Quantity    int     `json:"quantity"`
+	Discount    float64 `json:"discount"`
+}
+
+type PageInfo struct {
+	HasNextPage bool    `json:"hasNextPage"`
+	EndCursor   *string `json:"endCursor,omitempty"`
 }
 
 type PaginationInput struct {
@@ -43,18 +154,255 @@ type PaginationInput struct {
 	Take *int `json:"take,omitempty"`
 }
 
+type PriceBucket struct {
+	From  float64 `json:"from"`
+	To    float64 `json:"to"`
+	Count int     `json:"count"`
+}
+
 type Product struct {
-	ID          string  `json:"id"`
-	Name        string  `json:"name"`
-	Description string  `json:"description"`
-	Price       float64 `json:"price"`
+	ID                string   `json:"id"`
+	Name              string   `json:"name"`
+	Description       string   `json:"description"`
+	Price             float64  `json:"price"`
+	Category          *string  `json:"category,omitempty"`
+	Score             *float64 `json:"score,omitempty"`
+	AvailableQuantity int      `json:"availableQuantity"`
+	InStock           bool     `json:"inStock"`
+}
+
+type ProductConnection struct {
+	Edges    []*ProductEdge `json:"edges"`
+	PageInfo *PageInfo      `json:"pageInfo"`
+	Facets   *ProductFacets `json:"facets"`
+}
+
+type ProductEdge struct {
+	Cursor string   `json:"cursor"`
+	Node   *Product `json:"node"`
+}
+
+type ProductFacets struct {
+	Prices     []*PriceBucket    `json:"prices"`
+	Categories []*CategoryBucket `json:"categories"`
+}
+
+type ProductFilterInput struct {
+	MinPrice   *float64 `json:"minPrice,omitempty"`
+	MaxPrice   *float64 `json:"maxPrice,omitempty"`
+	Categories []string `json:"categories,omitempty"`
 }
 
 type ProductInput struct {
 	Name        string  `json:"name"`
 	Description string  `json:"description"`
 	Price       float64 `json:"price"`
+	Category    *string `json:"category,omitempty"`
+	Stock       *int    `json:"stock,omitempty"`
+}
+
+type ProductPatchInput struct {
+	Name        *string  `json:"name,omitempty"`
+	Description *string  `json:"description,omitempty"`
+	Price       *float64 `json:"price,omitempty"`
+	Category    *string  `json:"category,omitempty"`
+	Stock       *int     `json:"stock,omitempty"`
 }
 
 type Query struct {
 }
+
+type RegisterInput struct {
+	Name     string `json:"name"`
+	Email    string `json:"email"`
+	Password string `json:"password"`
+}
+
+type Subscription struct {
+}
+
+type Tokens struct {
+	AccessToken          string    `json:"accessToken"`
+	RefreshToken         string    `json:"refreshToken"`
+	AccessTokenExpiresAt time.Time `json:"accessTokenExpiresAt"`
+}
+
+type CouponType string
+
+const (
+	CouponTypePercentage CouponType = "PERCENTAGE"
+	CouponTypeFixed      CouponType = "FIXED"
+	CouponTypeBuyXGetY   CouponType = "BUY_X_GET_Y"
+)
+
+var AllCouponType = []CouponType{
+	CouponTypePercentage,
+	CouponTypeFixed,
+	CouponTypeBuyXGetY,
+}
+
+func (e CouponType) IsValid() bool {
+	switch e {
+	case CouponTypePercentage, CouponTypeFixed, CouponTypeBuyXGetY:
+		return true
+	}
+	return false
+}
+
+func (e CouponType) String() string {
+	return string(e)
+}
+
+func (e *CouponType) UnmarshalGQL(v interface{}) error {
+	str, ok := v.(string)
+	if !ok {
+		return fmt.Errorf("enums must be strings")
+	}
+
+	*e = CouponType(str)
+	if !e.IsValid() {
+		return fmt.Errorf("%s is not a valid CouponType", str)
+	}
+	return nil
+}
+
+func (e CouponType) MarshalGQL(w io.Writer) {
+	fmt.Fprint(w, strconv.Quote(e.String()))
+}
+
+type OrderStatus string
+
+const (
+	OrderStatusPending   OrderStatus = "PENDING"
+	OrderStatusPaid      OrderStatus = "PAID"
+	OrderStatusFulfilled OrderStatus = "FULFILLED"
+	OrderStatusShipped   OrderStatus = "SHIPPED"
+	OrderStatusDelivered OrderStatus = "DELIVERED"
+	OrderStatusCancelled OrderStatus = "CANCELLED"
+)
+
+var AllOrderStatus = []OrderStatus{
+	OrderStatusPending,
+	OrderStatusPaid,
+	OrderStatusFulfilled,
+	OrderStatusShipped,
+	OrderStatusDelivered,
+	OrderStatusCancelled,
+}
+
+func (e OrderStatus) IsValid() bool {
+	switch e {
+	case OrderStatusPending, OrderStatusPaid, OrderStatusFulfilled, OrderStatusShipped, OrderStatusDelivered, OrderStatusCancelled:
+		return true
+	}
+	return false
+}
+
+func (e OrderStatus) String() string {
+	return string(e)
+}
+
+func (e *OrderStatus) UnmarshalGQL(v interface{}) error {
+	str, ok := v.(string)
+	if !ok {
+		return fmt.Errorf("enums must be strings")
+	}
+
+	*e = OrderStatus(str)
+	if !e.IsValid() {
+		return fmt.Errorf("%s is not a valid OrderStatus", str)
+	}
+	return nil
+}
+
+func (e OrderStatus) MarshalGQL(w io.Writer) {
+	fmt.Fprint(w, strconv.Quote(e.String()))
+}
+
+type ProductSort string
+
+const (
+	ProductSortRelevance ProductSort = "RELEVANCE"
+	ProductSortNewest    ProductSort = "NEWEST"
+	ProductSortPriceAsc  ProductSort = "PRICE_ASC"
+	ProductSortPriceDesc ProductSort = "PRICE_DESC"
+	ProductSortName      ProductSort = "NAME"
+)
+
+var AllProductSort = []ProductSort{
+	ProductSortRelevance,
+	ProductSortNewest,
+	ProductSortPriceAsc,
+	ProductSortPriceDesc,
+	ProductSortName,
+}
+
+func (e ProductSort) IsValid() bool {
+	switch e {
+	case ProductSortRelevance, ProductSortNewest, ProductSortPriceAsc, ProductSortPriceDesc, ProductSortName:
+		return true
+	}
+	return false
+}
+
+func (e ProductSort) String() string {
+	return string(e)
+}
+
+func (e *ProductSort) UnmarshalGQL(v interface{}) error {
+	str, ok := v.(string)
+	if !ok {
+		return fmt.Errorf("enums must be strings")
+	}
+
+	*e = ProductSort(str)
+	if !e.IsValid() {
+		return fmt.Errorf("%s is not a valid ProductSort", str)
+	}
+	return nil
+}
+
+func (e ProductSort) MarshalGQL(w io.Writer) {
+	fmt.Fprint(w, strconv.Quote(e.String()))
+}
+
+type Role string
+
+const (
+	RoleUser  Role = "USER"
+	RoleAdmin Role = "ADMIN"
+)
+
+var AllRole = []Role{
+	RoleUser,
+	RoleAdmin,
+}
+
+func (e Role) IsValid() bool {
+	switch e {
+	case RoleUser, RoleAdmin:
+		return true
+	}
+	return false
+}
+
+func (e Role) String() string {
+	return string(e)
+}
+
+func (e *Role) UnmarshalGQL(v interface{}) error {
+	str, ok := v.(string)
+	if !ok {
+		return fmt.Errorf("enums must be strings")
+	}
+
+	*e = Role(str)
+	if !e.IsValid() {
+		return fmt.Errorf("%s is not a valid Role", str)
+	}
+	return nil
+}
+
+func (e Role) MarshalGQL(w io.Writer) {
+	fmt.Fprint(w, strconv.Quote(e.String()))
+}
@@ -1,9 +1,10 @@
-package main
+package graphql
 
 import (
 	"context"
 	"errors"
 	"log"
+	"strings"
 	"time"
 
 	"github.com/haroonalbar/go-grpc-graphql-microservices/order"
@@ -16,6 +17,51 @@ type mutationResolver struct {
 	server *Server
 }
 
+func (r *mutationResolver) Register(ctx context.Context, in RegisterInput) (*AuthPayload, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	acc, tokens, err := r.server.accountClient.Register(ctx, in.Name, in.Email, in.Password)
+	if err != nil {
+		log.Println("Error registering account from account client: ", err)
+		return nil, err
+	}
+	return &AuthPayload{
+		Account: &Account{
+			ID:   acc.ID,
+			Name: acc.Name,
+		},
+		Tokens: toTokens(tokens),
+	}, nil
+}
+
+func (r *mutationResolver) Login(ctx context.Context, in LoginInput) (*AuthPayload, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	acc, tokens, err := r.server.accountClient.Login(ctx, in.Email, in.Password)
+	if err != nil {
+		log.Println("Error logging in from account client: ", err)
+		return nil, err
+	}
+	return &AuthPayload{
+		Account: &Account{
+			ID:   acc.ID,
+			Name: acc.Name,
+		},
+		Tokens: toTokens(tokens),
+	}, nil
+}
+
+func (r *mutationResolver) RefreshToken(ctx context.Context, refreshToken string) (*Tokens, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	tokens, err := r.server.accountClient.RefreshToken(ctx, refreshToken)
+	if err != nil {
+		log.Println("Error refreshing token from account client: ", err)
+		return nil, err
+	}
+	return toTokens(tokens), nil
+}
+
 func (r *mutationResolver) CreateAccount(ctx context.Context, in AccountInput) (*Account, error) {
 	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
@@ -30,28 +76,108 @@ func (r *mutationResolver) CreateAccount(ctx context.Context, in AccountInput) (
 	}, nil
 }
 
+func (r *mutationResolver) UpdateAccount(ctx context.Context, id string, in AccountInput) (*Account, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	if err := authorizeAccount(ctx, id); err != nil {
+		return nil, err
+	}
+	acc, err := r.server.accountClient.UpdateAccount(ctx, id, in.Name)
+	if err != nil {
+		log.Println("Error updating account from account client: ", err)
+		return nil, err
+	}
+	return &Account{
+		ID:   acc.ID,
+		Name: acc.Name,
+	}, nil
+}
+
+// DeleteAccount soft deletes the account, orders placed by it are kept
+func (r *mutationResolver) DeleteAccount(ctx context.Context, id string) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	if err := authorizeAccount(ctx, id); err != nil {
+		return false, err
+	}
+	if err := r.server.accountClient.DeleteAccount(ctx, id); err != nil {
+		log.Println("Error deleting account from account client: ", err)
+		return false, err
+	}
+	return true, nil
+}
+
 func (r *mutationResolver) CreateProduct(ctx context.Context, in ProductInput) (*Product, error) {
 	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
 
-	p, err := r.server.catalogClient.PostProduct(ctx, in.Name, in.Description, in.Price)
+	var category string
+	if in.Category != nil {
+		category = *in.Category
+	}
+	var stock uint64
+	if in.Stock != nil {
+		if *in.Stock < 0 {
+			return nil, ErrInvalidParameter
+		}
+		stock = uint64(*in.Stock)
+	}
+
+	p, err := r.server.catalogClient.PostProduct(ctx, in.Name, in.Description, in.Price, category, stock)
 	if err != nil {
 		log.Println("Error creating product on catalog client: ", err)
 		return nil, err
 	}
 
-	return &Product{
-		ID:          p.ID,
-		Name:        p.Name,
-		Description: p.Description,
-		Price:       p.Price,
-	}, nil
+	return toProduct(p, false), nil
+}
+
+func (r *mutationResolver) UpdateProduct(ctx context.Context, id string, in ProductPatchInput) (*Product, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	var stock *uint64
+	if in.Stock != nil {
+		if *in.Stock < 0 {
+			return nil, ErrInvalidParameter
+		}
+		s := uint64(*in.Stock)
+		stock = &s
+	}
+
+	p, err := r.server.catalogClient.UpdateProduct(ctx, id, in.Name, in.Description, in.Price, in.Category, stock)
+	if err != nil {
+		log.Println("Error updating product on catalog client: ", err)
+		return nil, err
+	}
+
+	return toProduct(p, false), nil
+}
+
+// DeleteProduct archives the product. It's removed from listings and search
+// but still resolves by id for existing orders.
+func (r *mutationResolver) DeleteProduct(ctx context.Context, id string) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	if err := r.server.catalogClient.DeleteProduct(ctx, id); err != nil {
+		log.Println("Error deleting product on catalog client: ", err)
+		return false, err
+	}
+	return true, nil
 }
 
+// CreateOrder places the order for the authenticated account.
+// Admins can place orders for other accounts by setting accountId.
 func (r *mutationResolver) CreateOrder(ctx context.Context, in OrderInput) (*Order, error) {
 	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
 
+	accountID, err := targetAccount(ctx, in.AccountID)
+	if err != nil {
+		return nil, err
+	}
+
 	products := []order.OrderedProduct{}
 	for _, p := range in.Products {
 		if p.Quantity <= 0 {
@@ -63,27 +189,187 @@ func (r *mutationResolver) CreateOrder(ctx context.Context, in OrderInput) (*Ord
 		})
 	}
 
-	o, err := r.server.orderClient.PostOrder(ctx, in.AccountID, products)
+	var idempotencyKey string
+	if in.IdempotencyKey != nil {
+		idempotencyKey = *in.IdempotencyKey
+	}
+
+	var couponCode string
+	if in.CouponCode != nil {
+		couponCode = *in.CouponCode
+	}
+
+	o, err := r.server.orderClient.PostOrder(ctx, accountID, idempotencyKey, couponCode, products)
 	if err != nil {
 		log.Println("Error creating order in order client: ", err)
 		return nil, err
 	}
 
-	var resProducts []*OrderedProduct
-	for _, op := range o.Products {
-		resProducts = append(resProducts, &OrderedProduct{
-			ID:          op.ID,
-			Name:        op.Name,
-			Description: op.Description,
-			Price:       op.Price,
-			Quantity:    int(op.Quantity),
-		})
+	return toOrder(o), nil
+}
+
+func (r *mutationResolver) UpdateOrderStatus(ctx context.Context, id string, status OrderStatus) (*Order, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	if !status.IsValid() {
+		return nil, ErrInvalidParameter
 	}
 
-	return &Order{
-		ID:         o.ID,
-		CreatedAt:  o.CreatedAt,
-		TotalPrice: o.TotalPrice,
-		Products:   resProducts,
-	}, nil
+	o, err := r.server.orderClient.UpdateOrderStatus(ctx, id, order.Status(strings.ToLower(string(status))))
+	if err != nil {
+		log.Println("Error updating order status in order client: ", err)
+		return nil, err
+	}
+
+	return toOrder(o), nil
+}
+
+func (r *mutationResolver) AddToCart(ctx context.Context, in CartItemInput) (*Cart, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	accountID, err := targetAccount(ctx, in.AccountID)
+	if err != nil {
+		return nil, err
+	}
+	if in.Quantity <= 0 {
+		return nil, ErrInvalidParameter
+	}
+
+	c, err := r.server.orderClient.AddToCart(ctx, accountID, in.ProductID, uint32(in.Quantity))
+	if err != nil {
+		log.Println("Error adding to cart in order client: ", err)
+		return nil, err
+	}
+
+	return toCart(c), nil
+}
+
+// UpdateCartItem replaces the quantity of a product in the cart, 0 removes it
+func (r *mutationResolver) UpdateCartItem(ctx context.Context, in CartItemInput) (*Cart, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	accountID, err := targetAccount(ctx, in.AccountID)
+	if err != nil {
+		return nil, err
+	}
+	if in.Quantity < 0 {
+		return nil, ErrInvalidParameter
+	}
+
+	c, err := r.server.orderClient.UpdateCartItem(ctx, accountID, in.ProductID, uint32(in.Quantity))
+	if err != nil {
+		log.Println("Error updating cart item in order client: ", err)
+		return nil, err
+	}
+
+	return toCart(c), nil
+}
+
+func (r *mutationResolver) RemoveFromCart(ctx context.Context, productID string, accountID *string) (*Cart, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	id, err := targetAccount(ctx, accountID)
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := r.server.orderClient.RemoveFromCart(ctx, id, productID)
+	if err != nil {
+		log.Println("Error removing from cart in order client: ", err)
+		return nil, err
+	}
+
+	return toCart(c), nil
+}
+
+// Checkout places an order for the cart, the order service prices it and reserves the stock like for createOrder
+func (r *mutationResolver) Checkout(ctx context.Context, in *CheckoutInput) (*Order, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	var accountID, idempotencyKey, couponCode *string
+	if in != nil {
+		accountID, idempotencyKey, couponCode = in.AccountID, in.IdempotencyKey, in.CouponCode
+	}
+	id, err := targetAccount(ctx, accountID)
+	if err != nil {
+		return nil, err
+	}
+	var key, code string
+	if idempotencyKey != nil {
+		key = *idempotencyKey
+	}
+	if couponCode != nil {
+		code = *couponCode
+	}
+
+	o, err := r.server.orderClient.Checkout(ctx, id, key, code)
+	if err != nil {
+		log.Println("Error checking out cart in order client: ", err)
+		return nil, err
+	}
+
+	return toOrder(o), nil
+}
+
+func (r *mutationResolver) CreateCoupon(ctx context.Context, in CouponInput) (*Coupon, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	if !in.Type.IsValid() {
+		return nil, ErrInvalidParameter
+	}
+	c := order.Coupon{
+		Code: in.Code,
+		// order service uses lower case coupon types, graphql enums are upper case
+		Type: order.CouponType(strings.ToLower(string(in.Type))),
+	}
+	if in.Value != nil {
+		c.Value = *in.Value
+	}
+	if in.ProductID != nil {
+		c.ProductID = *in.ProductID
+	}
+	if in.MinSpend != nil {
+		c.MinSpend = *in.MinSpend
+	}
+	if in.ValidFrom != nil {
+		c.ValidFrom = *in.ValidFrom
+	}
+	if in.ValidUntil != nil {
+		c.ValidUntil = *in.ValidUntil
+	}
+	var err error
+	if c.BuyQuantity, err = optionalCount(in.BuyQuantity); err != nil {
+		return nil, err
+	}
+	if c.GetQuantity, err = optionalCount(in.GetQuantity); err != nil {
+		return nil, err
+	}
+	if c.MaxUsesPerAccount, err = optionalCount(in.MaxUsesPerAccount); err != nil {
+		return nil, err
+	}
+
+	created, err := r.server.orderClient.CreateCoupon(ctx, c)
+	if err != nil {
+		log.Println("Error creating coupon in order client: ", err)
+		return nil, err
+	}
+
+	return toCoupon(created), nil
+}
+
+// optionalCount returns 0 for an unset count and ErrInvalidParameter for a negative one
+func optionalCount(n *int) (uint32, error) {
+	if n == nil {
+		return 0, nil
+	}
+	if *n < 0 {
+		return 0, ErrInvalidParameter
+	}
+	return uint32(*n), nil
 }
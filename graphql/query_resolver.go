@@ -1,9 +1,14 @@
-package main
+package graphql
 
 import (
 	"context"
 	"log"
 	"time"
+
+	"github.com/haroonalbar/go-grpc-graphql-microservices/catalog"
+	"github.com/haroonalbar/go-grpc-graphql-microservices/order"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 type queryResolver struct {
@@ -38,7 +43,7 @@ func (r *queryResolver) Accounts(ctx context.Context, pagination *PaginationInpu
 	if pagination != nil {
 		skip, take = pagination.bounds()
 	}
-	accountList, err := r.server.accountClient.GetAccounts(ctx, skip, take)
+	accountList, err := r.server.accountClient.GetAccounts(ctx, skip, take, "")
 	if err != nil {
 		log.Println("Error getting accounts from account client: ", err)
 		return nil, err
@@ -55,22 +60,22 @@ func (r *queryResolver) Accounts(ctx context.Context, pagination *PaginationInpu
 }
 
 // Products samething as Accounts with extra parameter query that's also defined in schema
-func (r *queryResolver) Products(ctx context.Context, pagination *PaginationInput, query *string, id *string) ([]*Product, error) {
+// filter and sort only apply to listings and searches, not to a single product
+func (r *queryResolver) Products(ctx context.Context, pagination *PaginationInput, query *string, id *string, filter *ProductFilterInput, sort *ProductSort) ([]*Product, error) {
 	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
 	// single
 	if id != nil && *id != "" {
-		p, err := r.server.catalogClient.GetProduct(ctx, *id)
+		// batched with the other product lookups of the request, see dataloader.go
+		p, err := loadersFromContext(ctx).productByID.Load(ctx, *id)
 		if err != nil {
 			log.Println("Error getting product from catalog client : ", err)
 			return nil, err
 		}
-		return []*Product{{
-			ID:          p.ID,
-			Name:        p.Name,
-			Description: p.Description,
-			Price:       p.Price,
-		}}, nil
+		if p == nil {
+			return nil, catalog.ErrNotFound
+		}
+		return []*Product{toProduct(p, false)}, nil
 	}
 
 	// multiple
@@ -88,22 +93,181 @@ func (r *queryResolver) Products(ctx context.Context, pagination *PaginationInpu
 	// 	ids = nil
 	// }
 
-	productList, err := r.server.catalogClient.GetProducts(ctx, skip, take, nil, q)
+	page, err := r.server.catalogClient.GetProductsPage(ctx, skip, take, q, "", toProductQuery(filter, sort, nil))
 	if err != nil {
 		log.Println("Error getting products from catalog client: ", err)
 		return nil, err
 	}
-	log.Printf("Number of products returned: %d", len(productList))
+	log.Printf("Number of products returned: %d", len(page.Products))
 
 	var products []*Product
-	for _, p := range productList {
-		products = append(products, &Product{
-			ID:          p.ID,
-			Name:        p.Name,
-			Description: p.Description,
-			Price:       p.Price,
+	for _, p := range page.Products {
+		products = append(products, toProduct(&p, q != ""))
+	}
+	return products, nil
+}
+
+// Order gets a single order by it's id
+func (r *queryResolver) Order(ctx context.Context, id string) (*Order, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	o, err := r.server.orderClient.GetOrder(ctx, id)
+	if err != nil {
+		log.Println("Error getting order from order client: ", err)
+		return nil, err
+	}
+	// only the account that placed the order and admins can see it, others get the same
+	// NotFound the order service returns for missing orders
+	if err := authorizeAccount(ctx, o.AccountID); err != nil {
+		return nil, status.Error(codes.NotFound, order.ErrNotFound.Error())
+	}
+
+	return toOrder(o), nil
+}
+
+// Cart returns the cart of the caller, or of accountID for admins
+func (r *queryResolver) Cart(ctx context.Context, accountID *string) (*Cart, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	id, err := targetAccount(ctx, accountID)
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := r.server.orderClient.GetCart(ctx, id)
+	if err != nil {
+		log.Println("Error getting cart from order client: ", err)
+		return nil, err
+	}
+
+	return toCart(c), nil
+}
+
+func (r *queryResolver) Coupon(ctx context.Context, code string) (*Coupon, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	c, err := r.server.orderClient.GetCoupon(ctx, code)
+	if err != nil {
+		log.Println("Error getting coupon from order client: ", err)
+		return nil, err
+	}
+
+	return toCoupon(c), nil
+}
+
+// AccountsConnection pages through the accounts newest first with account IDs as cursors
+func (r *queryResolver) AccountsConnection(ctx context.Context, first *int, after *string) (*AccountConnection, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	size, err := pageSize(first)
+	if err != nil {
+		return nil, err
+	}
+	afterID, err := decodeCursor(after)
+	if err != nil {
+		return nil, err
+	}
+
+	conn := &AccountConnection{Edges: []*AccountEdge{}, PageInfo: &PageInfo{}}
+	if size == 0 {
+		return conn, nil
+	}
+
+	// one more than requested to know if there is a next page
+	accountList, err := r.server.accountClient.GetAccounts(ctx, 0, uint64(size+1), afterID)
+	if err != nil {
+		log.Println("Error getting accounts from account client: ", err)
+		return nil, err
+	}
+
+	var n int
+	conn.PageInfo, n = newPageInfo(len(accountList), size, func(i int) string { return encodeCursor(accountList[i].ID) })
+	for _, a := range accountList[:n] {
+		conn.Edges = append(conn.Edges, &AccountEdge{
+			Cursor: encodeCursor(a.ID),
+			Node: &Account{
+				ID:   a.ID,
+				Name: a.Name,
+			},
+		})
+	}
+	return conn, nil
+}
+
+// ProductsConnection pages through the products newest first, or through the results of query.
+// The cursors are the page tokens of the catalog service, which pages with search_after.
+// The facets are computed by the catalog along with the page.
+func (r *queryResolver) ProductsConnection(ctx context.Context, first *int, after *string, query *string, filter *ProductFilterInput, sort *ProductSort, priceInterval *float64) (*ProductConnection, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	size, err := pageSize(first)
+	if err != nil {
+		return nil, err
+	}
+	var pageToken, q string
+	if after != nil {
+		pageToken = *after
+	}
+	if query != nil {
+		q = *query
+	}
+
+	// with first 0 the page is still fetched for the facets
+	// one more than requested to know if there is a next page
+	page, err := r.server.catalogClient.GetProductsPage(ctx, 0, uint64(size+1), q, pageToken, toProductQuery(filter, sort, priceInterval))
+	if err != nil {
+		log.Println("Error getting products from catalog client: ", err)
+		return nil, err
+	}
+	productList := page.Products
+
+	conn := &ProductConnection{Edges: []*ProductEdge{}, PageInfo: &PageInfo{}, Facets: toProductFacets(page.Facets)}
+	if size == 0 {
+		return conn, nil
+	}
+
+	var n int
+	conn.PageInfo, n = newPageInfo(len(productList), size, func(i int) string { return productList[i].Cursor })
+	for _, p := range productList[:n] {
+		conn.Edges = append(conn.Edges, &ProductEdge{
+			Cursor: p.Cursor,
+			Node:   toProduct(&p, q != ""),
 		})
 	}
+	return conn, nil
+}
+
+// suggestTimeout is much shorter than the other queries, suggestions are fetched on every keystroke
+// and a late suggestion is useless
+const suggestTimeout = 500 * time.Millisecond
+
+// ProductSuggestions autocompletes the prefix typed into a search box
+func (r *queryResolver) ProductSuggestions(ctx context.Context, prefix string, size *int) ([]*Product, error) {
+	ctx, cancel := context.WithTimeout(ctx, suggestTimeout)
+	defer cancel()
+
+	var n uint64
+	if size != nil {
+		if *size < 0 {
+			return nil, ErrInvalidParameter
+		}
+		n = uint64(*size)
+	}
+	productList, err := r.server.catalogClient.SuggestProducts(ctx, prefix, n)
+	if err != nil {
+		log.Println("Error getting product suggestions from catalog client: ", err)
+		return nil, err
+	}
+
+	products := []*Product{}
+	for _, p := range productList {
+		products = append(products, toProduct(&p, false))
+	}
 	return products, nil
 }
 
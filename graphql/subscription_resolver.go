@@ -0,0 +1,60 @@
+package graphql
+
+import (
+	"context"
+	"log"
+
+	"github.com/haroonalbar/go-grpc-graphql-microservices/account"
+	"github.com/haroonalbar/go-grpc-graphql-microservices/order"
+)
+
+type subscriptionResolver struct {
+	server *Server
+}
+
+// Subscriptions live as long as the WebSocket subscription, so there is no timeout on ctx.
+// Each one opens a WatchOrders stream with the token of the subscriber,
+// the order service only sends the events the subscriber is allowed to see.
+
+// OrderCreated streams the new orders of the caller, admins get the new orders of every account
+func (r *subscriptionResolver) OrderCreated(ctx context.Context) (<-chan *Order, error) {
+	caller := callerFromContext(ctx)
+	accountID := caller.AccountID()
+	if hasRole(caller, account.RoleAdmin) {
+		accountID = ""
+	}
+	return r.watch(ctx, accountID, order.EventOrderCreated)
+}
+
+// OrderUpdated streams the status changes of the orders of an account
+func (r *subscriptionResolver) OrderUpdated(ctx context.Context, accountID string) (<-chan *Order, error) {
+	if err := authorizeAccount(ctx, accountID); err != nil {
+		return nil, err
+	}
+	return r.watch(ctx, accountID, order.EventOrderUpdated)
+}
+
+// watch forwards the orders of events of the given type until the subscription ends
+func (r *subscriptionResolver) watch(ctx context.Context, accountID string, eventType order.EventType) (<-chan *Order, error) {
+	events, err := r.server.orderClient.WatchOrders(ctx, accountID)
+	if err != nil {
+		log.Println("Error watching orders from order client: ", err)
+		return nil, err
+	}
+
+	orders := make(chan *Order)
+	go func() {
+		defer close(orders)
+		for e := range events {
+			if e.Type != eventType {
+				continue
+			}
+			select {
+			case orders <- toOrder(&e.Order):
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return orders, nil
+}
@@ -0,0 +1,157 @@
+package order
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+)
+
+var (
+	ErrInvalidQuantity = errors.New("invalid quantity")
+	ErrCartFull        = errors.New("cart is full")
+	ErrEmptyCart       = errors.New("cart is empty")
+	// ErrNotInCart is returned when updating a product the cart doesn't have
+	ErrNotInCart = errors.New("product not in cart")
+)
+
+const (
+	// maxCartItems is how many different products a cart can hold
+	maxCartItems = 50
+	// maxCartItemQuantity is how many of one product a cart can hold
+	maxCartItemQuantity = 1000
+)
+
+// CartItem is a product in the cart of an account.
+// Only the product ID and quantity are stored, the cart is priced with the catalog whenever it's read,
+// so it always shows the current prices.
+type CartItem struct {
+	ProductID string    `json:"product_id"`
+	Quantity  uint32    `json:"quantity"`
+	AddedAt   time.Time `json:"added_at"`
+}
+
+// Cart is the cart of an account priced with the catalog
+type Cart struct {
+	AccountID string        `json:"account_id"`
+	Products  []CartProduct `json:"products"`
+	// TotalPrice only counts the available products, the ones checkout would order
+	TotalPrice float64 `json:"total_price"`
+}
+
+// CartProduct is a cart item with the current details of its product
+type CartProduct struct {
+	ID          string  `json:"product_id"`
+	Name        string  `json:"name"`
+	Description string  `json:"description"`
+	Price       float64 `json:"price"`
+	Quantity    uint32  `json:"quantity"`
+	// Available is false if the product was deleted from the catalog, it can't be ordered anymore
+	Available bool `json:"available"`
+	// AvailableQuantity is the stock of the product, checkout fails if it's less than Quantity
+	AvailableQuantity uint64 `json:"available_quantity"`
+}
+
+// CartRepository stores the carts, one per account
+type CartRepository interface {
+	// GetCartItems returns the items of the account's cart in the order they were added
+	GetCartItems(ctx context.Context, accountID string) ([]CartItem, error)
+	// AddCartItem adds item.Quantity to the quantity of the product in the cart,
+	// or adds the item if the cart doesn't have the product yet
+	AddCartItem(ctx context.Context, accountID string, item CartItem) error
+	// SetCartItemQuantity replaces the quantity of the product, ErrNotInCart is returned if the cart doesn't have it
+	SetCartItemQuantity(ctx context.Context, accountID, productID string, quantity uint32) error
+	// RemoveCartItems removes the products from the cart, products that aren't in it are ignored
+	RemoveCartItems(ctx context.Context, accountID string, productIDs []string) error
+}
+
+// AddToCart adds quantity of the product to the cart of the account.
+// The total quantity of a product is limited to maxCartItemQuantity and a cart holds
+// at most maxCartItems products. The caller checks that the product exists.
+func (s orderService) AddToCart(ctx context.Context, accountID, productID string, quantity uint32) ([]CartItem, error) {
+	if quantity == 0 || quantity > maxCartItemQuantity {
+		return nil, ErrInvalidQuantity
+	}
+	items, err := s.repository.GetCartItems(ctx, accountID)
+	if err != nil {
+		return nil, err
+	}
+	if item := findCartItem(items, productID); item != nil {
+		if item.Quantity+quantity > maxCartItemQuantity {
+			return nil, ErrInvalidQuantity
+		}
+	} else if len(items) >= maxCartItems {
+		return nil, ErrCartFull
+	}
+
+	err = s.repository.AddCartItem(ctx, accountID, CartItem{
+		ProductID: productID,
+		Quantity:  quantity,
+		AddedAt:   time.Now().UTC(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s.repository.GetCartItems(ctx, accountID)
+}
+
+// UpdateCartItem replaces the quantity of a product in the cart, 0 removes it.
+// ErrNotInCart is returned if the product isn't in the cart.
+func (s orderService) UpdateCartItem(ctx context.Context, accountID, productID string, quantity uint32) ([]CartItem, error) {
+	if quantity > maxCartItemQuantity {
+		return nil, ErrInvalidQuantity
+	}
+	if quantity == 0 {
+		return s.RemoveFromCart(ctx, accountID, productID)
+	}
+	if err := s.repository.SetCartItemQuantity(ctx, accountID, productID, quantity); err != nil {
+		return nil, err
+	}
+	return s.repository.GetCartItems(ctx, accountID)
+}
+
+// RemoveFromCart removes the product from the cart, it's not an error if the cart doesn't have it
+func (s orderService) RemoveFromCart(ctx context.Context, accountID, productID string) ([]CartItem, error) {
+	if err := s.repository.RemoveCartItems(ctx, accountID, []string{productID}); err != nil {
+		return nil, err
+	}
+	return s.repository.GetCartItems(ctx, accountID)
+}
+
+func (s orderService) GetCart(ctx context.Context, accountID string) ([]CartItem, error) {
+	return s.repository.GetCartItems(ctx, accountID)
+}
+
+// Checkout places an order for the products of the cart through PostOrder and removes them from the cart.
+// products are the cart items priced by the caller, products that can't be ordered anymore stay in the cart.
+// The cart is only changed if the order was placed, so a failed checkout can be retried.
+// A retry with the idempotency key of a placed order returns it and leaves the cart alone,
+// what's in the cart now was added after that checkout.
+func (s orderService) Checkout(ctx context.Context, accountID, idempotencyKey, couponCode string, products []OrderedProduct) (*Order, error) {
+	if len(products) == 0 {
+		return nil, ErrEmptyCart
+	}
+	o, created, err := s.placeOrder(ctx, accountID, idempotencyKey, couponCode, products)
+	if err != nil || !created {
+		return o, err
+	}
+
+	var ids []string
+	for _, p := range o.Products {
+		ids = append(ids, p.ID)
+	}
+	// the order is placed even if the request was cancelled now, so the cart should reflect it
+	if err := s.repository.RemoveCartItems(context.WithoutCancel(ctx), accountID, ids); err != nil {
+		log.Println("Error emptying cart after checkout: ", err)
+	}
+	return o, nil
+}
+
+func findCartItem(items []CartItem, productID string) *CartItem {
+	for i := range items {
+		if items[i].ProductID == productID {
+			return &items[i]
+		}
+	}
+	return nil
+}
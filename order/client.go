@@ -2,8 +2,10 @@ package order
 
 import (
 	"context"
+	"io"
 	"log"
 
+	"github.com/haroonalbar/go-grpc-graphql-microservices/auth"
 	"github.com/haroonalbar/go-grpc-graphql-microservices/order/pb"
 	"google.golang.org/grpc"
 )
@@ -13,9 +15,19 @@ type Client struct {
 	service pb.OrderServiceClient
 }
 
-func NewClient(url string) (*Client, error) {
+// NewClient connects to the service at url.
+// opts are added to the dial options, like a dialer for in-process connections.
+func NewClient(url string, opts ...grpc.DialOption) (*Client, error) {
 	// NOTE: used NewClient instead of depricated Dial
-	conn, err := grpc.Dial(url, grpc.WithInsecure())
+	conn, err := grpc.Dial(
+		url,
+		append([]grpc.DialOption{
+			grpc.WithInsecure(),
+			// forwards the caller identity from the context, see auth.WithToken and auth.ServiceCredentials
+			grpc.WithUnaryInterceptor(auth.UnaryClientInterceptor),
+			grpc.WithStreamInterceptor(auth.StreamClientInterceptor),
+		}, opts...)...,
+	)
 	// conn, err := grpc.NewClient(url, grpc.WithTransportCredentials(insecure.NewCredentials()))
 	if err != nil {
 		return nil, err
@@ -31,7 +43,10 @@ func (c *Client) Close() {
 	}
 }
 
-func (c *Client) PostOrder(ctx context.Context, accountID string, products []OrderedProduct) (*Order, error) {
+// PostOrder places an order for the account.
+// idempotencyKey is optional, retrying with the same key returns the order created the first time.
+// couponCode is optional too, the coupon is applied to the price of the order.
+func (c *Client) PostOrder(ctx context.Context, accountID, idempotencyKey, couponCode string, products []OrderedProduct) (*Order, error) {
 	protoProducts := []*pb.PostOrderRequest_OrderProduct{}
 	for _, p := range products {
 		protoProducts = append(protoProducts, &pb.PostOrderRequest_OrderProduct{
@@ -41,31 +56,37 @@ func (c *Client) PostOrder(ctx context.Context, accountID string, products []Ord
 	}
 
 	res, err := c.service.PostOrder(ctx, &pb.PostOrderRequest{
-		AccountId: accountID,
-		Products:  protoProducts,
+		AccountId:      accountID,
+		Products:       protoProducts,
+		IdempotencyKey: idempotencyKey,
+		CouponCode:     couponCode,
 	})
 	if err != nil {
 		return nil, err
 	}
 
-	newOrder := &Order{
-		ID:         res.Order.Id,
-		AccountID:  res.Order.AccountId,
-		TotalPrice: res.Order.TotalPrice,
-		Products:   products,
-	}
+	return orderFromProto(res.Order)
+}
 
-	err = newOrder.CreatedAt.UnmarshalBinary(res.Order.CreatedAt)
+func (c *Client) GetOrder(ctx context.Context, id string) (*Order, error) {
+	res, err := c.service.GetOrder(ctx, &pb.GetOrderRequest{
+		Id: id,
+	})
 	if err != nil {
+		log.Println(err)
 		return nil, err
 	}
 
-	return newOrder, nil
+	return orderFromProto(res.Order)
 }
 
-func (c *Client) GetOrderForAccount(ctx context.Context, accountID string) ([]Order, error) {
+// GetOrderForAccount lists the orders of the account newest first.
+// after is the ID of the last order of the previous page, take 0 returns all orders.
+func (c *Client) GetOrderForAccount(ctx context.Context, accountID string, after string, take uint64) ([]Order, error) {
 	res, err := c.service.GetOrdersForAccount(ctx, &pb.GetOrdersForAccountRequest{
 		AccountId: accountID,
+		After:     after,
+		Take:      take,
 	})
 	if err != nil {
 		log.Println(err)
@@ -75,32 +96,240 @@ func (c *Client) GetOrderForAccount(ctx context.Context, accountID string) ([]Or
 	orders := []Order{}
 
 	for _, orderProto := range res.Orders {
-		newOrder := Order{
-			ID:         orderProto.Id,
-			AccountID:  orderProto.AccountId,
-			TotalPrice: orderProto.TotalPrice,
+		newOrder, err := orderFromProto(orderProto)
+		if err != nil {
+			return nil, err
 		}
+		orders = append(orders, *newOrder)
+	}
 
-		err := newOrder.CreatedAt.UnmarshalBinary(orderProto.CreatedAt)
+	return orders, nil
+}
+
+// GetOrdersForAccounts returns the orders of every account in accountIDs, keyed by account ID.
+// Accounts without orders are missing from the map.
+func (c *Client) GetOrdersForAccounts(ctx context.Context, accountIDs []string) (map[string][]Order, error) {
+	res, err := c.service.GetOrdersForAccounts(ctx, &pb.GetOrdersForAccountsRequest{
+		AccountIds: accountIDs,
+	})
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+
+	orders := map[string][]Order{}
+	for _, orderProto := range res.Orders {
+		newOrder, err := orderFromProto(orderProto)
 		if err != nil {
-			log.Println("Error while converting bytes to time : ", err)
 			return nil, err
 		}
+		orders[newOrder.AccountID] = append(orders[newOrder.AccountID], *newOrder)
+	}
 
-		products := []OrderedProduct{}
-		for _, p := range orderProto.Products {
-			products = append(products, OrderedProduct{
-				ID:          p.Id,
-				Name:        p.Name,
-				Description: p.Description,
-				Price:       p.Price,
-				Quantity:    p.Quantity,
-			})
-		}
-		newOrder.Products = products
+	return orders, nil
+}
 
-		orders = append(orders, newOrder)
+func (c *Client) UpdateOrderStatus(ctx context.Context, id string, status Status) (*Order, error) {
+	res, err := c.service.UpdateOrderStatus(ctx, &pb.UpdateOrderStatusRequest{
+		Id:     id,
+		Status: string(status),
+	})
+	if err != nil {
+		log.Println(err)
+		return nil, err
 	}
 
-	return orders, nil
+	return orderFromProto(res.Order)
+}
+
+// AddToCart adds quantity of the product to the cart of the account and returns the cart
+func (c *Client) AddToCart(ctx context.Context, accountID, productID string, quantity uint32) (*Cart, error) {
+	res, err := c.service.AddToCart(ctx, &pb.AddToCartRequest{
+		AccountId: accountID,
+		ProductId: productID,
+		Quantity:  quantity,
+	})
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+	return cartFromProto(res.Cart), nil
+}
+
+// UpdateCartItem replaces the quantity of the product in the cart, 0 removes it
+func (c *Client) UpdateCartItem(ctx context.Context, accountID, productID string, quantity uint32) (*Cart, error) {
+	res, err := c.service.UpdateCartItem(ctx, &pb.UpdateCartItemRequest{
+		AccountId: accountID,
+		ProductId: productID,
+		Quantity:  quantity,
+	})
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+	return cartFromProto(res.Cart), nil
+}
+
+func (c *Client) RemoveFromCart(ctx context.Context, accountID, productID string) (*Cart, error) {
+	res, err := c.service.RemoveFromCart(ctx, &pb.RemoveFromCartRequest{
+		AccountId: accountID,
+		ProductId: productID,
+	})
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+	return cartFromProto(res.Cart), nil
+}
+
+func (c *Client) GetCart(ctx context.Context, accountID string) (*Cart, error) {
+	res, err := c.service.GetCart(ctx, &pb.GetCartRequest{
+		AccountId: accountID,
+	})
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+	return cartFromProto(res.Cart), nil
+}
+
+// Checkout places an order for the cart of the account.
+// idempotencyKey and couponCode are optional and work like the ones of PostOrder.
+func (c *Client) Checkout(ctx context.Context, accountID, idempotencyKey, couponCode string) (*Order, error) {
+	res, err := c.service.Checkout(ctx, &pb.CheckoutRequest{
+		AccountId:      accountID,
+		IdempotencyKey: idempotencyKey,
+		CouponCode:     couponCode,
+	})
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+	return orderFromProto(res.Order)
+}
+
+// CreateCoupon adds a coupon and returns it as stored, with the code upper case
+func (c *Client) CreateCoupon(ctx context.Context, coupon Coupon) (*Coupon, error) {
+	cp, err := couponToProto(&coupon)
+	if err != nil {
+		return nil, err
+	}
+	res, err := c.service.CreateCoupon(ctx, &pb.CreateCouponRequest{
+		Coupon: cp,
+	})
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+	return couponFromProto(res.Coupon)
+}
+
+func (c *Client) GetCoupon(ctx context.Context, code string) (*Coupon, error) {
+	res, err := c.service.GetCoupon(ctx, &pb.GetCouponRequest{
+		Code: code,
+	})
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+	return couponFromProto(res.Coupon)
+}
+
+func cartFromProto(cartProto *pb.Cart) *Cart {
+	cart := &Cart{
+		AccountID:  cartProto.AccountId,
+		Products:   []CartProduct{},
+		TotalPrice: cartProto.TotalPrice,
+	}
+	for _, item := range cartProto.Items {
+		cart.Products = append(cart.Products, CartProduct{
+			ID:                item.ProductId,
+			Name:              item.Name,
+			Description:       item.Description,
+			Price:             item.Price,
+			Quantity:          item.Quantity,
+			Available:         item.Available,
+			AvailableQuantity: item.AvailableQuantity,
+		})
+	}
+	return cart
+}
+
+// orderFromProto converts a protobuf order back to the domain order
+func orderFromProto(orderProto *pb.Order) (*Order, error) {
+	newOrder := &Order{
+		ID:         orderProto.Id,
+		AccountID:  orderProto.AccountId,
+		Subtotal:   orderProto.Subtotal,
+		TotalPrice: orderProto.TotalPrice,
+		Status:     Status(orderProto.Status),
+		CouponCode: orderProto.CouponCode,
+	}
+
+	err := newOrder.CreatedAt.UnmarshalBinary(orderProto.CreatedAt)
+	if err != nil {
+		log.Println("Error while converting bytes to time : ", err)
+		return nil, err
+	}
+
+	products := []OrderedProduct{}
+	for _, p := range orderProto.Products {
+		products = append(products, OrderedProduct{
+			ID:          p.Id,
+			Name:        p.Name,
+			Description: p.Description,
+			Price:       p.Price,
+			Quantity:    p.Quantity,
+			Discount:    p.Discount,
+		})
+	}
+	newOrder.Products = products
+
+	for _, d := range orderProto.Discounts {
+		newOrder.Discounts = append(newOrder.Discounts, Discount{
+			CouponCode:  d.CouponCode,
+			ProductID:   d.ProductId,
+			Description: d.Description,
+			Amount:      d.Amount,
+		})
+	}
+
+	return newOrder, nil
+}
+
+// WatchOrders streams the events of the account's orders, or of all orders if accountID is empty.
+// The channel is closed when ctx is done or the stream breaks.
+func (c *Client) WatchOrders(ctx context.Context, accountID string) (<-chan OrderEvent, error) {
+	stream, err := c.service.WatchOrders(ctx, &pb.WatchOrdersRequest{
+		AccountId: accountID,
+	})
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+
+	events := make(chan OrderEvent)
+	go func() {
+		defer close(events)
+		for {
+			res, err := stream.Recv()
+			if err != nil {
+				if err != io.EOF && ctx.Err() == nil {
+					log.Println("Error receiving order event: ", err)
+				}
+				return
+			}
+			o, err := orderFromProto(res.Order)
+			if err != nil {
+				log.Println("Error converting order event: ", err)
+				return
+			}
+			select {
+			case events <- OrderEvent{Type: EventType(res.Type), Order: *o}:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return events, nil
 }
@@ -1,9 +1,12 @@
 package main
 
 import (
+	"context"
 	"log"
 	"time"
 
+	"github.com/haroonalbar/go-grpc-graphql-microservices/account"
+	"github.com/haroonalbar/go-grpc-graphql-microservices/catalog"
 	"github.com/haroonalbar/go-grpc-graphql-microservices/order"
 	"github.com/kelseyhightower/envconfig"
 	"github.com/tinrab/retry"
@@ -13,6 +16,13 @@ type Config struct {
 	DatabaseURL string `envconfig:"DATABASE_URL"`
 	AccountURL  string `envconfig:"ACCOUNT_SERVICE_URL"`
 	CatalogURL  string `envconfig:"CATALOG_SERVICE_URL"`
+	// PEM encoded RSA public key of the account service, used to verify access tokens
+	JWTPublicKeyFile string `envconfig:"JWT_PUBLIC_KEY_FILE" default:"/keys/jwt.pub.pem"`
+	// Secret shared by the services to call each other
+	ServiceToken string `envconfig:"SERVICE_TOKEN"`
+	// NATS server with JetStream the order events are published to.
+	// If it's empty they go to an in-process bus, which nothing outside the service can consume.
+	NATSURL string `envconfig:"NATS_URL"`
 }
 
 func main() {
@@ -22,6 +32,11 @@ func main() {
 		log.Fatal(err)
 	}
 
+	verifier, err := account.NewTokenVerifier(cfg.JWTPublicKeyFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	var r order.Repository
 	retry.ForeverSleep(2*time.Second, func(_ int) (err error) {
 		r, err = order.NewPostgresRepository(cfg.DatabaseURL)
@@ -33,7 +48,43 @@ func main() {
 	})
 	defer r.Close()
 
+	accountClient, err := account.NewClient(cfg.AccountURL)
+	if err != nil {
+		log.Fatalf("failed to connect to account service: %v", err)
+	}
+	defer accountClient.Close()
+	// the catalog service looks up the ordered products and keeps their stock
+	catalogClient, err := catalog.NewClient(cfg.CatalogURL)
+	if err != nil {
+		log.Fatalf("failed to connect to catalog service: %v", err)
+	}
+	defer catalogClient.Close()
+
+	// the relay publishes the events the repository writes to its outbox
+	var bus order.EventBus
+	if cfg.NATSURL != "" {
+		retry.ForeverSleep(2*time.Second, func(_ int) (err error) {
+			bus, err = order.NewNATSEventBus(cfg.NATSURL)
+			if err != nil {
+				log.Println(err)
+			}
+			return
+		})
+	} else {
+		bus = order.NewMemoryEventBus()
+	}
+	defer bus.Close()
+	go order.NewRelay(r, bus, time.Second).Run(context.Background())
+
+	// the reconciler gives back the stock of orders that were reserved but never saved,
+	// placing an order takes far less than 10 minutes, older reservations are left over
+	inventory := order.NewCatalogInventory(catalogClient, cfg.ServiceToken)
+	go order.NewReconciler(r, inventory, 10*time.Minute, time.Minute).Run(context.Background())
+
+	s, err := order.NewService(r, inventory, bus)
+	if err != nil {
+		log.Fatalf("failed to set up order service: %v", err)
+	}
 	log.Println("Listening on port 8080...")
-	s := order.NewService(r)
-	log.Fatal(order.ListenGRPC(s, cfg.AccountURL, cfg.CatalogURL, 8080))
+	log.Fatal(order.ListenGRPC(s, accountClient, catalogClient, verifier, cfg.ServiceToken, 8080))
 }
@@ -0,0 +1,225 @@
+package order
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+var (
+	ErrCouponNotFound = errors.New("coupon not found")
+	ErrCouponExists   = errors.New("coupon already exists")
+	// ErrInvalidCoupon is returned when creating a coupon that can't be applied, like 150% off
+	ErrInvalidCoupon       = errors.New("invalid coupon")
+	ErrCouponNotValidNow   = errors.New("coupon is not valid at this time")
+	ErrCouponMinSpend      = errors.New("order does not reach the minimum spend of the coupon")
+	ErrCouponNotApplicable = errors.New("coupon does not apply to the order")
+	// ErrCouponUsageLimit is returned by PutOrder when the account used the coupon as often as it may
+	ErrCouponUsageLimit = errors.New("coupon usage limit reached")
+)
+
+// CouponType is how a coupon takes money off an order
+type CouponType string
+
+const (
+	// CouponPercentage takes Value percent off every product
+	CouponPercentage CouponType = "percentage"
+	// CouponFixed takes Value off the whole order, at most its subtotal
+	CouponFixed CouponType = "fixed"
+	// CouponBuyXGetY makes GetQuantity of every BuyQuantity + GetQuantity units of ProductID free
+	CouponBuyXGetY CouponType = "buy_x_get_y"
+)
+
+// ParseCouponType returns ErrInvalidCoupon for unknown types
+func ParseCouponType(s string) (CouponType, error) {
+	switch t := CouponType(s); t {
+	case CouponPercentage, CouponFixed, CouponBuyXGetY:
+		return t, nil
+	}
+	return "", ErrInvalidCoupon
+}
+
+// Coupon is a promotion customers apply to an order with its code.
+// An order can use one coupon, the conditions are checked when the order is placed.
+type Coupon struct {
+	// Code is what customers enter, codes are stored upper case and matched ignoring case
+	Code string     `json:"code"`
+	Type CouponType `json:"type"`
+	// Value is the percentage off for CouponPercentage and the amount off for CouponFixed
+	Value float64 `json:"value,omitempty"`
+	// ProductID, BuyQuantity and GetQuantity are the offer of a CouponBuyXGetY
+	ProductID   string `json:"product_id,omitempty"`
+	BuyQuantity uint32 `json:"buy_quantity,omitempty"`
+	GetQuantity uint32 `json:"get_quantity,omitempty"`
+	// MinSpend is the subtotal an order needs before the coupon applies, 0 for any order
+	MinSpend float64 `json:"min_spend,omitempty"`
+	// ValidFrom and ValidUntil bound when the coupon can be used, the zero time leaves that side open.
+	// ValidUntil is exclusive.
+	ValidFrom  time.Time `json:"valid_from"`
+	ValidUntil time.Time `json:"valid_until"`
+	// MaxUsesPerAccount is how many orders of an account can use the coupon, 0 for no limit.
+	// Cancelled orders don't count.
+	MaxUsesPerAccount uint32    `json:"max_uses_per_account,omitempty"`
+	CreatedAt         time.Time `json:"created_at"`
+}
+
+// Discount is one deduction from the price of an order, the breakdown of an order lists all of them
+type Discount struct {
+	CouponCode string `json:"coupon_code"`
+	// ProductID is set for discounts of one order line, it's empty for discounts of the whole order
+	ProductID   string  `json:"product_id,omitempty"`
+	Description string  `json:"description"`
+	Amount      float64 `json:"amount"`
+}
+
+// CouponRepository stores the coupons.
+// The usage limit of a coupon is enforced by PutOrder, in the transaction that saves the order.
+type CouponRepository interface {
+	// PutCoupon returns ErrCouponExists if there is a coupon with the code
+	PutCoupon(ctx context.Context, c Coupon) error
+	// GetCoupon returns ErrCouponNotFound if there is no coupon with the code
+	GetCoupon(ctx context.Context, code string) (*Coupon, error)
+}
+
+// normalizeCouponCode makes codes case insensitive
+func normalizeCouponCode(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+// validate checks that the coupon can discount an order
+func (c Coupon) validate() error {
+	if c.Code == "" || len(c.Code) > 64 || c.MinSpend < 0 {
+		return ErrInvalidCoupon
+	}
+	if !c.ValidFrom.IsZero() && !c.ValidUntil.IsZero() && !c.ValidUntil.After(c.ValidFrom) {
+		return ErrInvalidCoupon
+	}
+	switch c.Type {
+	case CouponPercentage:
+		if c.Value <= 0 || c.Value > 100 {
+			return ErrInvalidCoupon
+		}
+	case CouponFixed:
+		if c.Value <= 0 {
+			return ErrInvalidCoupon
+		}
+	case CouponBuyXGetY:
+		if c.ProductID == "" || c.BuyQuantity == 0 || c.GetQuantity == 0 {
+			return ErrInvalidCoupon
+		}
+	default:
+		return ErrInvalidCoupon
+	}
+	return nil
+}
+
+// validAt reports if t is inside the validity window
+func (c Coupon) validAt(t time.Time) bool {
+	return (c.ValidFrom.IsZero() || !t.Before(c.ValidFrom)) && (c.ValidUntil.IsZero() || t.Before(c.ValidUntil))
+}
+
+// discounts returns what the coupon takes off the products, placed at the given time.
+// The per-account usage limit isn't checked here, it needs the orders of the account.
+func (c Coupon) discounts(products []OrderedProduct, subtotal float64, at time.Time) ([]Discount, error) {
+	if !c.validAt(at) {
+		return nil, ErrCouponNotValidNow
+	}
+	if subtotal < c.MinSpend {
+		return nil, ErrCouponMinSpend
+	}
+
+	discounts := []Discount{}
+	switch c.Type {
+	case CouponPercentage:
+		for _, p := range products {
+			amount := roundCents(p.Price * float64(p.Quantity) * c.Value / 100)
+			if amount > 0 {
+				discounts = append(discounts, Discount{
+					CouponCode:  c.Code,
+					ProductID:   p.ID,
+					Description: fmt.Sprintf("%g%% off", c.Value),
+					Amount:      amount,
+				})
+			}
+		}
+	case CouponFixed:
+		discounts = append(discounts, Discount{
+			CouponCode:  c.Code,
+			Description: fmt.Sprintf("%.2f off", c.Value),
+			Amount:      math.Min(c.Value, subtotal),
+		})
+	case CouponBuyXGetY:
+		for _, p := range products {
+			if p.ID != c.ProductID {
+				continue
+			}
+			free := p.Quantity / (c.BuyQuantity + c.GetQuantity) * c.GetQuantity
+			if free > 0 {
+				discounts = append(discounts, Discount{
+					CouponCode:  c.Code,
+					ProductID:   p.ID,
+					Description: fmt.Sprintf("buy %d get %d free", c.BuyQuantity, c.GetQuantity),
+					Amount:      roundCents(p.Price * float64(free)),
+				})
+			}
+		}
+	}
+	if len(discounts) == 0 {
+		return nil, ErrCouponNotApplicable
+	}
+	return discounts, nil
+}
+
+// applyCoupon prices the order with the coupon. It sets the discount of every line,
+// the breakdown of the order and the total price.
+func (o *Order) applyCoupon(c Coupon) error {
+	discounts, err := c.discounts(o.Products, o.Subtotal, o.CreatedAt)
+	if err != nil {
+		return err
+	}
+
+	// copy the products, they belong to the caller
+	o.Products = append([]OrderedProduct{}, o.Products...)
+	var total float64
+	for _, d := range discounts {
+		total += d.Amount
+		for i := range o.Products {
+			if d.ProductID != "" && o.Products[i].ID == d.ProductID {
+				o.Products[i].Discount = roundCents(o.Products[i].Discount + d.Amount)
+			}
+		}
+	}
+	o.CouponCode = c.Code
+	o.Discounts = discounts
+	o.TotalPrice = roundCents(o.Subtotal - total)
+	return nil
+}
+
+func roundCents(amount float64) float64 {
+	return math.Round(amount*100) / 100
+}
+
+// CreateCoupon validates and saves a new coupon, its code is upper cased
+func (s orderService) CreateCoupon(ctx context.Context, c Coupon) (*Coupon, error) {
+	c.Code = normalizeCouponCode(c.Code)
+	if c.Type != CouponBuyXGetY {
+		c.ProductID, c.BuyQuantity, c.GetQuantity = "", 0, 0
+	} else {
+		c.Value = 0
+	}
+	if err := c.validate(); err != nil {
+		return nil, err
+	}
+	c.CreatedAt = time.Now().UTC()
+	if err := s.repository.PutCoupon(ctx, c); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+func (s orderService) GetCoupon(ctx context.Context, code string) (*Coupon, error) {
+	return s.repository.GetCoupon(ctx, normalizeCouponCode(code))
+}
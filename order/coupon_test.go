@@ -0,0 +1,159 @@
+package order
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCouponDiscounts(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	mug := OrderedProduct{ID: "mug", Name: "Mug", Price: 4, Quantity: 1}
+	pen := OrderedProduct{ID: "pen", Name: "Pen", Price: 0.35, Quantity: 1}
+	withQuantity := func(p OrderedProduct, quantity uint32) OrderedProduct {
+		p.Quantity = quantity
+		return p
+	}
+
+	tests := []struct {
+		name     string
+		coupon   Coupon
+		products []OrderedProduct
+		at       time.Time
+		wantErr  error
+		// wantLines is the discount of every product, products that aren't listed have none
+		wantLines map[string]float64
+		wantTotal float64
+	}{
+		{
+			name:      "percentage is rounded per line",
+			coupon:    Coupon{Type: CouponPercentage, Value: 10},
+			products:  []OrderedProduct{pen, {ID: "pencil", Price: 0.35, Quantity: 1}},
+			at:        now,
+			wantLines: map[string]float64{"pen": 0.04, "pencil": 0.04},
+			// 10% of the 0.70 subtotal would be 0.07
+			wantTotal: 0.62,
+		},
+		{
+			name:      "percentage of the whole line",
+			coupon:    Coupon{Type: CouponPercentage, Value: 25},
+			products:  []OrderedProduct{withQuantity(mug, 3), pen},
+			at:        now,
+			wantLines: map[string]float64{"mug": 3, "pen": 0.09},
+			wantTotal: 9.26,
+		},
+		{
+			name:      "fixed",
+			coupon:    Coupon{Type: CouponFixed, Value: 3},
+			products:  []OrderedProduct{withQuantity(mug, 2)},
+			at:        now,
+			wantTotal: 5,
+		},
+		{
+			name:      "fixed is capped at the subtotal",
+			coupon:    Coupon{Type: CouponFixed, Value: 10},
+			products:  []OrderedProduct{withQuantity(mug, 2)},
+			at:        now,
+			wantTotal: 0,
+		},
+		{
+			name:      "buy 2 get 1 of every full group",
+			coupon:    Coupon{Type: CouponBuyXGetY, ProductID: "mug", BuyQuantity: 2, GetQuantity: 1},
+			products:  []OrderedProduct{withQuantity(mug, 7), pen},
+			at:        now,
+			wantLines: map[string]float64{"mug": 8},
+			wantTotal: 20.35,
+		},
+		{
+			name:     "buy 2 get 1 without a full group",
+			coupon:   Coupon{Type: CouponBuyXGetY, ProductID: "mug", BuyQuantity: 2, GetQuantity: 1},
+			products: []OrderedProduct{withQuantity(mug, 2)},
+			at:       now,
+			wantErr:  ErrCouponNotApplicable,
+		},
+		{
+			name:     "buy x get y of a product not ordered",
+			coupon:   Coupon{Type: CouponBuyXGetY, ProductID: "cup", BuyQuantity: 1, GetQuantity: 1},
+			products: []OrderedProduct{withQuantity(mug, 4)},
+			at:       now,
+			wantErr:  ErrCouponNotApplicable,
+		},
+		{
+			name:      "min spend reached",
+			coupon:    Coupon{Type: CouponFixed, Value: 1, MinSpend: 8},
+			products:  []OrderedProduct{withQuantity(mug, 2)},
+			at:        now,
+			wantTotal: 7,
+		},
+		{
+			name:     "min spend missed",
+			coupon:   Coupon{Type: CouponFixed, Value: 1, MinSpend: 8.01},
+			products: []OrderedProduct{withQuantity(mug, 2)},
+			at:       now,
+			wantErr:  ErrCouponMinSpend,
+		},
+		{
+			name:      "valid from is inclusive",
+			coupon:    Coupon{Type: CouponFixed, Value: 1, ValidFrom: now, ValidUntil: now.Add(time.Hour)},
+			products:  []OrderedProduct{mug},
+			at:        now,
+			wantTotal: 3,
+		},
+		{
+			name:     "before valid from",
+			coupon:   Coupon{Type: CouponFixed, Value: 1, ValidFrom: now},
+			products: []OrderedProduct{mug},
+			at:       now.Add(-time.Nanosecond),
+			wantErr:  ErrCouponNotValidNow,
+		},
+		{
+			name:      "just before valid until",
+			coupon:    Coupon{Type: CouponFixed, Value: 1, ValidUntil: now},
+			products:  []OrderedProduct{mug},
+			at:        now.Add(-time.Nanosecond),
+			wantTotal: 3,
+		},
+		{
+			name:     "valid until is exclusive",
+			coupon:   Coupon{Type: CouponFixed, Value: 1, ValidFrom: now.Add(-time.Hour), ValidUntil: now},
+			products: []OrderedProduct{mug},
+			at:       now,
+			wantErr:  ErrCouponNotValidNow,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.coupon.Code = "TEST"
+			o := Order{CreatedAt: tt.at, Products: tt.products}
+			for _, p := range tt.products {
+				o.Subtotal += p.Price * float64(p.Quantity)
+			}
+			o.Subtotal = roundCents(o.Subtotal)
+			o.TotalPrice = o.Subtotal
+
+			err := o.applyCoupon(tt.coupon)
+			if err != tt.wantErr {
+				t.Fatalf("applyCoupon error = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if o.TotalPrice != tt.wantTotal {
+				t.Errorf("total = %v, want %v", o.TotalPrice, tt.wantTotal)
+			}
+			for _, p := range o.Products {
+				if p.Discount != tt.wantLines[p.ID] {
+					t.Errorf("discount of %s = %v, want %v", p.ID, p.Discount, tt.wantLines[p.ID])
+				}
+			}
+			for _, p := range tt.products {
+				if p.Discount != 0 {
+					t.Errorf("the caller's %s got a discount, applyCoupon has to copy the products", p.ID)
+				}
+			}
+			if o.CouponCode != "TEST" || len(o.Discounts) == 0 {
+				t.Errorf("order coupon = %q with %d discounts, want TEST with its breakdown", o.CouponCode, len(o.Discounts))
+			}
+		})
+	}
+}
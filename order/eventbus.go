@@ -0,0 +1,74 @@
+package order
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// EventHandler processes an event. An error asks for the event again later,
+// so handlers have to cope with events they already processed.
+type EventHandler func(ctx context.Context, e Event) error
+
+// EventBus delivers the domain events of the order service to consumers like inventory,
+// notifications or analytics. It's in-process for a single binary, or a message broker.
+type EventBus interface {
+	// Publish returns once the bus has taken over the event
+	Publish(ctx context.Context, e Event) error
+	// Subscribe hands every event published from now on to handle, until ctx is done.
+	// name identifies the consumer, a broker remembers what it processed across restarts,
+	// and instances of a service subscribing with the same name share the events between them.
+	// An empty name subscribes only this process, it gets every event while it's subscribed.
+	Subscribe(ctx context.Context, name string, handle EventHandler) error
+	Close()
+}
+
+// memoryEventBus calls the handlers in the publishing goroutine, a handler error fails the
+// publish so the relay retries the event. The other handlers see it again in that case.
+type memoryEventBus struct {
+	mu       sync.RWMutex
+	handlers map[*memoryHandler]struct{}
+}
+
+type memoryHandler struct {
+	name   string
+	handle EventHandler
+}
+
+// NewMemoryEventBus returns an event bus for consumers in the same process
+func NewMemoryEventBus() EventBus {
+	return &memoryEventBus{handlers: map[*memoryHandler]struct{}{}}
+}
+
+func (b *memoryEventBus) Publish(ctx context.Context, e Event) error {
+	b.mu.RLock()
+	handlers := make([]*memoryHandler, 0, len(b.handlers))
+	for h := range b.handlers {
+		handlers = append(handlers, h)
+	}
+	b.mu.RUnlock()
+
+	for _, h := range handlers {
+		if err := h.handle(ctx, e); err != nil {
+			return fmt.Errorf("%s consumer: %w", h.name, err)
+		}
+	}
+	return nil
+}
+
+func (b *memoryEventBus) Subscribe(ctx context.Context, name string, handle EventHandler) error {
+	h := &memoryHandler{name: name, handle: handle}
+	b.mu.Lock()
+	b.handlers[h] = struct{}{}
+	b.mu.Unlock()
+
+	go func() {
+		<-ctx.Done()
+		b.mu.Lock()
+		delete(b.handlers, h)
+		b.mu.Unlock()
+	}()
+	return nil
+}
+
+func (b *memoryEventBus) Close() {}
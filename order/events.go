@@ -0,0 +1,130 @@
+package order
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"sync"
+)
+
+// ErrWatcherTooSlow ends a watch whose watcher didn't keep up with the events, it has to watch again
+var ErrWatcherTooSlow = errors.New("order watcher fell behind")
+
+// EventType is what happened to the order of an OrderEvent
+type EventType string
+
+const (
+	EventOrderCreated EventType = "created"
+	EventOrderUpdated EventType = "updated"
+)
+
+// OrderEvent is sent to the watchers of an order whenever it's created or changes status
+type OrderEvent struct {
+	Type  EventType `json:"type"`
+	Order Order     `json:"order"`
+}
+
+// watcherBuffer is how many events a watcher can fall behind before it's disconnected
+const watcherBuffer = 16
+
+type watcher struct {
+	// empty watches the orders of all accounts
+	accountID string
+	events    chan OrderEvent
+}
+
+// broadcaster fans out order events to the watchers in this process.
+// Publish never blocks, a watcher that doesn't keep up is disconnected instead of stalling
+// the other watchers, its channel is closed so it knows it missed events.
+type broadcaster struct {
+	mu       sync.Mutex
+	watchers map[*watcher]struct{}
+}
+
+func newBroadcaster() *broadcaster {
+	return &broadcaster{watchers: map[*watcher]struct{}{}}
+}
+
+// subscribe returns the events of the account, or of all accounts if accountID is empty.
+// cancel has to be called to stop watching, it closes the channel unless the watcher was disconnected already.
+func (b *broadcaster) subscribe(accountID string) (events <-chan OrderEvent, cancel func()) {
+	w := &watcher{accountID: accountID, events: make(chan OrderEvent, watcherBuffer)}
+
+	b.mu.Lock()
+	b.watchers[w] = struct{}{}
+	b.mu.Unlock()
+
+	return w.events, func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		b.remove(w)
+	}
+}
+
+// remove closes the channel of the watcher once, b.mu has to be held
+func (b *broadcaster) remove(w *watcher) {
+	if _, ok := b.watchers[w]; ok {
+		delete(b.watchers, w)
+		close(w.events)
+	}
+}
+
+func (b *broadcaster) publish(e OrderEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for w := range b.watchers {
+		if w.accountID != "" && w.accountID != e.Order.AccountID {
+			continue
+		}
+		select {
+		case w.events <- e:
+		default:
+			log.Println("Disconnecting slow order watcher of account: ", w.accountID)
+			b.remove(w)
+		}
+	}
+}
+
+// handleEvent turns the domain events of the event bus into the events of the watchers.
+// Every instance of the service subscribes on its own, so watchers see the orders of all of them,
+// and only committed changes are published, the outbox has them once the transaction went through.
+func (s orderService) handleEvent(ctx context.Context, e Event) error {
+	switch e.Type {
+	case OrderCreatedEvent:
+		var created OrderCreated
+		if err := json.Unmarshal(e.Payload, &created); err != nil {
+			log.Println("Error decoding order event: ", err)
+			return nil
+		}
+		s.events.publish(OrderEvent{Type: EventOrderCreated, Order: Order{
+			ID:         created.OrderID,
+			CreatedAt:  created.CreatedAt,
+			AccountID:  created.AccountID,
+			Subtotal:   created.Subtotal,
+			TotalPrice: created.TotalPrice,
+			Status:     created.Status,
+			Products:   created.Products,
+			CouponCode: created.CouponCode,
+			Discounts:  created.Discounts,
+		}})
+	case OrderStatusChangedEvent:
+		var changed OrderStatusChanged
+		if err := json.Unmarshal(e.Payload, &changed); err != nil {
+			log.Println("Error decoding order event: ", err)
+			return nil
+		}
+		// the event only has the status, the rest of the order is read from the repository.
+		// The order may have moved on already, the watchers get the status of this change.
+		o, err := s.repository.GetOrder(ctx, changed.OrderID)
+		if err != nil {
+			// watchers aren't worth stalling the other consumers for, they miss this one
+			log.Println("Error getting order of status change: ", err)
+			return nil
+		}
+		o.Status = changed.To
+		s.events.publish(OrderEvent{Type: EventOrderUpdated, Order: *o})
+	}
+	return nil
+}
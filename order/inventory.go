@@ -0,0 +1,92 @@
+package order
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/haroonalbar/go-grpc-graphql-microservices/auth"
+	"github.com/haroonalbar/go-grpc-graphql-microservices/catalog"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var (
+	ErrInsufficientStock = errors.New("insufficient stock")
+	// ErrReservationUnknown is returned by ReserveStock if it can't tell whether the stock was reserved,
+	// e.g. because the call timed out. It's wrapped around the error of the call.
+	ErrReservationUnknown = errors.New("stock reservation outcome unknown")
+)
+
+// Inventory holds the stock of the products. Placing an order reserves the ordered quantities,
+// so products can't be oversold, and cancelling it releases them again.
+// Reservations have an ID, the ID of the order, and the inventory records what each of them took
+// until the order is saved, so retrying or cancelling one never takes or gives back stock twice.
+type Inventory interface {
+	// ReserveStock reserves the quantities of all products or none of them, reserving again with
+	// the same ID takes nothing. It returns ErrInsufficientStock if a product doesn't have enough stock.
+	// After any error but ErrReservationUnknown nothing was reserved.
+	ReserveStock(ctx context.Context, reservationID string, products []OrderedProduct) error
+	// CancelReservation gives back what the reservation took, which is nothing if it failed
+	CancelReservation(ctx context.Context, reservationID string, products []OrderedProduct) error
+	// ConfirmReservation hands the stock of the reservation to its saved order, the inventory forgets it
+	ConfirmReservation(ctx context.Context, reservationID string, products []OrderedProduct) error
+	// ReleaseStock gives back the quantities of a saved order, when it's cancelled
+	ReleaseStock(ctx context.Context, products []OrderedProduct) error
+}
+
+// catalogInventory keeps the stock in the catalog service
+type catalogInventory struct {
+	client *catalog.Client
+	// Identity used when calling the catalog service, only services may change the stock
+	credentials auth.ServiceCredentials
+}
+
+// NewCatalogInventory reserves stock with the catalog service, calling it as "order" with the service token
+func NewCatalogInventory(client *catalog.Client, serviceToken string) Inventory {
+	return &catalogInventory{
+		client:      client,
+		credentials: auth.ServiceCredentials{Name: "order", Token: serviceToken},
+	}
+}
+
+// reserveTimeout bounds a reservation in the catalog, it has to be well below the maxAge of the Reconciler,
+// which mustn't cancel a reservation the catalog is still working on
+const reserveTimeout = 30 * time.Second
+
+// ReserveStock trusts an error the catalog answered with, the catalog undoes a failed reservation before
+// it answers. Without an answer the reservation may still go through, or have gone through already.
+func (i *catalogInventory) ReserveStock(ctx context.Context, reservationID string, products []OrderedProduct) error {
+	ctx, cancel := context.WithTimeout(ctx, reserveTimeout)
+	defer cancel()
+	err := i.client.ReserveStock(i.credentials.Context(ctx), reservationID, stockItems(products))
+	if err == catalog.ErrInsufficientStock {
+		return ErrInsufficientStock
+	}
+	switch status.Code(err) {
+	case codes.DeadlineExceeded, codes.Canceled, codes.Unavailable:
+		return fmt.Errorf("%w: %v", ErrReservationUnknown, err)
+	}
+	return err
+}
+
+func (i *catalogInventory) CancelReservation(ctx context.Context, reservationID string, products []OrderedProduct) error {
+	return i.client.ReleaseStock(i.credentials.Context(ctx), reservationID, stockItems(products))
+}
+
+func (i *catalogInventory) ConfirmReservation(ctx context.Context, reservationID string, products []OrderedProduct) error {
+	return i.client.ConfirmStock(i.credentials.Context(ctx), reservationID, stockItems(products))
+}
+
+func (i *catalogInventory) ReleaseStock(ctx context.Context, products []OrderedProduct) error {
+	return i.client.ReleaseStock(i.credentials.Context(ctx), "", stockItems(products))
+}
+
+func stockItems(products []OrderedProduct) []catalog.StockItem {
+	items := make([]catalog.StockItem, 0, len(products))
+	for _, p := range products {
+		items = append(items, catalog.StockItem{ProductID: p.ID, Quantity: uint64(p.Quantity)})
+	}
+	return items
+}
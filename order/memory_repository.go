@@ -0,0 +1,370 @@
+package order
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// memoryRepository keeps orders in memory, for tests and local demos.
+// It behaves like postgresRepository: orders are listed by ID descending, idempotency keys
+// are unique per account and status updates fail with ErrStatusConflict if the order moved on.
+// Everything is lost when the process exits.
+type memoryRepository struct {
+	mu     sync.RWMutex
+	orders map[string]Order
+	// history is the status history of every order, like the order_status_transitions table
+	history map[string][]StatusTransition
+	// outbox holds the events in the order they were written, like the order_outbox table
+	outbox []outboxEntry
+	// carts holds the cart items of every account in the order they were added
+	carts map[string][]CartItem
+	// coupons by code
+	coupons map[string]Coupon
+	// reservations by order ID
+	reservations map[string]Reservation
+}
+
+type outboxEntry struct {
+	event     Event
+	published bool
+}
+
+// NewMemoryRepository returns an empty in-memory repository, it's safe for concurrent use
+func NewMemoryRepository() Repository {
+	return &memoryRepository{
+		orders:       map[string]Order{},
+		history:      map[string][]StatusTransition{},
+		carts:        map[string][]CartItem{},
+		coupons:      map[string]Coupon{},
+		reservations: map[string]Reservation{},
+	}
+}
+
+func (r *memoryRepository) Close() {}
+
+// PutOrder saves the order and its initial status and deletes its stock reservation.
+// ErrDuplicateIdempotencyKey is returned if the account already has an order with the key,
+// ErrCouponUsageLimit if the account can't use the coupon of the order anymore
+// and ErrReservationExpired if the order has no reservation.
+func (r *memoryRepository) PutOrder(ctx context.Context, o Order) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.orders[o.ID]; ok {
+		// postgres fails on the primary key too
+		return fmt.Errorf("order %s already exists", o.ID)
+	}
+	if o.IdempotencyKey != "" {
+		for _, existing := range r.orders {
+			if existing.AccountID == o.AccountID && existing.IdempotencyKey == o.IdempotencyKey {
+				return ErrDuplicateIdempotencyKey
+			}
+		}
+	}
+	if o.CouponCode != "" {
+		coupon, ok := r.coupons[o.CouponCode]
+		if !ok {
+			return ErrCouponNotFound
+		}
+		if coupon.MaxUsesPerAccount > 0 && r.couponUses(o.AccountID, o.CouponCode) >= coupon.MaxUsesPerAccount {
+			return ErrCouponUsageLimit
+		}
+	}
+	if _, ok := r.reservations[o.ID]; !ok {
+		return ErrReservationExpired
+	}
+
+	event, err := newOrderCreatedEvent(o)
+	if err != nil {
+		return err
+	}
+
+	delete(r.reservations, o.ID)
+	r.orders[o.ID] = copyOrder(o)
+	r.outbox = append(r.outbox, outboxEntry{event: event})
+	r.history[o.ID] = []StatusTransition{{
+		OrderID:   o.ID,
+		To:        o.Status,
+		CreatedAt: o.CreatedAt,
+	}}
+	return nil
+}
+
+// GetOrder returns ErrNotFound if no order exists with the given ID
+func (r *memoryRepository) GetOrder(ctx context.Context, id string) (*Order, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	o, ok := r.orders[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	o = copyOrder(o)
+	return &o, nil
+}
+
+// GetOrderByIdempotencyKey returns ErrNotFound if the account has no order with the key
+func (r *memoryRepository) GetOrderByIdempotencyKey(ctx context.Context, accountID, key string) (*Order, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if key == "" {
+		return nil, ErrNotFound
+	}
+	for _, o := range r.orders {
+		if o.AccountID == accountID && o.IdempotencyKey == key {
+			o = copyOrder(o)
+			return &o, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+// GetOrderForAccount returns the orders of the account newest first, after is the ID
+// of the last order of the previous page and take 0 returns all of them
+func (r *memoryRepository) GetOrderForAccount(ctx context.Context, accountID string, after string, take uint64) ([]Order, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	orders := r.filter(func(o Order) bool {
+		return o.AccountID == accountID && (after == "" || o.ID < after)
+	})
+	if take > 0 && uint64(len(orders)) > take {
+		orders = orders[:take]
+	}
+	return orders, nil
+}
+
+// GetOrdersForAccounts returns the orders of all the accounts, newest first
+func (r *memoryRepository) GetOrdersForAccounts(ctx context.Context, accountIDs []string) ([]Order, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	accounts := map[string]bool{}
+	for _, id := range accountIDs {
+		accounts[id] = true
+	}
+	return r.filter(func(o Order) bool {
+		return accounts[o.AccountID]
+	}), nil
+}
+
+// UpdateOrderStatus moves an order from t.From to t.To and records the transition.
+// ErrStatusConflict is returned if the order isn't in t.From anymore, or doesn't exist,
+// the same as the conditional update of postgresRepository.
+func (r *memoryRepository) UpdateOrderStatus(ctx context.Context, t StatusTransition) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	o, ok := r.orders[t.OrderID]
+	if !ok || o.Status != t.From {
+		return ErrStatusConflict
+	}
+	event, err := newOrderStatusChangedEvent(t, o.AccountID)
+	if err != nil {
+		return err
+	}
+	o.Status = t.To
+	r.orders[t.OrderID] = o
+	r.history[t.OrderID] = append(r.history[t.OrderID], t)
+	r.outbox = append(r.outbox, outboxEntry{event: event})
+	return nil
+}
+
+// UnpublishedEvents returns the oldest unpublished events
+func (r *memoryRepository) UnpublishedEvents(ctx context.Context, limit int) ([]Event, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	events := []Event{}
+	for _, entry := range r.outbox {
+		if len(events) == limit {
+			break
+		}
+		if !entry.published {
+			events = append(events, entry.event)
+		}
+	}
+	return events, nil
+}
+
+// MarkPublished marks the events, they are kept like in the order_outbox table
+func (r *memoryRepository) MarkPublished(ctx context.Context, ids []string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	published := map[string]bool{}
+	for _, id := range ids {
+		published[id] = true
+	}
+	for i := range r.outbox {
+		if published[r.outbox[i].event.ID] {
+			r.outbox[i].published = true
+		}
+	}
+	return nil
+}
+
+// GetCartItems returns a copy of the account's cart
+func (r *memoryRepository) GetCartItems(ctx context.Context, accountID string) ([]CartItem, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return append([]CartItem{}, r.carts[accountID]...), nil
+}
+
+func (r *memoryRepository) AddCartItem(ctx context.Context, accountID string, item CartItem) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if existing := findCartItem(r.carts[accountID], item.ProductID); existing != nil {
+		// the item keeps its place in the cart, like the row in postgres
+		existing.Quantity += item.Quantity
+		return nil
+	}
+	r.carts[accountID] = append(r.carts[accountID], item)
+	return nil
+}
+
+func (r *memoryRepository) SetCartItemQuantity(ctx context.Context, accountID, productID string, quantity uint32) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	item := findCartItem(r.carts[accountID], productID)
+	if item == nil {
+		return ErrNotInCart
+	}
+	item.Quantity = quantity
+	return nil
+}
+
+func (r *memoryRepository) RemoveCartItems(ctx context.Context, accountID string, productIDs []string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	remove := map[string]bool{}
+	for _, id := range productIDs {
+		remove[id] = true
+	}
+	items := []CartItem{}
+	for _, item := range r.carts[accountID] {
+		if !remove[item.ProductID] {
+			items = append(items, item)
+		}
+	}
+	if len(items) == 0 {
+		delete(r.carts, accountID)
+		return nil
+	}
+	r.carts[accountID] = items
+	return nil
+}
+
+// couponUses counts the orders of the account that used the coupon and weren't cancelled.
+// The caller must hold the lock.
+func (r *memoryRepository) couponUses(accountID, code string) uint32 {
+	var uses uint32
+	for _, o := range r.orders {
+		if o.AccountID == accountID && o.CouponCode == code && o.Status != StatusCancelled {
+			uses++
+		}
+	}
+	return uses
+}
+
+// PutCoupon returns ErrCouponExists if the code is taken
+func (r *memoryRepository) PutCoupon(ctx context.Context, c Coupon) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.coupons[c.Code]; ok {
+		return ErrCouponExists
+	}
+	r.coupons[c.Code] = c
+	return nil
+}
+
+// GetCoupon returns ErrCouponNotFound if there is no coupon with the code
+func (r *memoryRepository) GetCoupon(ctx context.Context, code string) (*Coupon, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	c, ok := r.coupons[code]
+	if !ok {
+		return nil, ErrCouponNotFound
+	}
+	return &c, nil
+}
+
+// filter returns copies of the orders matching keep, sorted by ID descending.
+// The caller must hold the lock.
+func (r *memoryRepository) filter(keep func(o Order) bool) []Order {
+	orders := []Order{}
+	for _, o := range r.orders {
+		if keep(o) {
+			orders = append(orders, copyOrder(o))
+		}
+	}
+	sort.Slice(orders, func(i, j int) bool { return orders[i].ID > orders[j].ID })
+	return orders
+}
+
+func (r *memoryRepository) PutReservation(ctx context.Context, res Reservation) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.reservations[res.OrderID]; ok {
+		// postgres fails on the primary key too
+		return fmt.Errorf("reservation of order %s already exists", res.OrderID)
+	}
+	res.Products = append([]OrderedProduct{}, res.Products...)
+	r.reservations[res.OrderID] = res
+	return nil
+}
+
+func (r *memoryRepository) DeleteReservation(ctx context.Context, orderID string) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.reservations[orderID]; !ok {
+		return false, nil
+	}
+	delete(r.reservations, orderID)
+	return true, nil
+}
+
+// StaleReservations sorts by creation time and order ID like postgres
+func (r *memoryRepository) StaleReservations(ctx context.Context, before time.Time, limit int) ([]Reservation, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	reservations := []Reservation{}
+	for _, res := range r.reservations {
+		if res.CreatedAt.Before(before) {
+			reservations = append(reservations, res)
+		}
+	}
+	sort.Slice(reservations, func(i, j int) bool {
+		a, b := reservations[i], reservations[j]
+		if !a.CreatedAt.Equal(b.CreatedAt) {
+			return a.CreatedAt.Before(b.CreatedAt)
+		}
+		return a.OrderID < b.OrderID
+	})
+	if len(reservations) > limit {
+		reservations = reservations[:limit]
+	}
+	return reservations, nil
+}
+
+// copyOrder copies the products and discounts, so callers can't change a stored order through the slices
+func copyOrder(o Order) Order {
+	o.Products = append([]OrderedProduct{}, o.Products...)
+	if o.Discounts != nil {
+		o.Discounts = append([]Discount{}, o.Discounts...)
+	}
+	return o
+}
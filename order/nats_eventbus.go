@@ -0,0 +1,114 @@
+package order
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// natsStream is the JetStream stream holding the order events, their subject is the event type
+const (
+	natsStream        = "ORDERS"
+	natsStreamSubject = "order.>"
+	// natsDuplicateWindow is how long the stream remembers event IDs. The relay publishes
+	// an event again if it crashed before marking it, the stream drops the copy.
+	natsDuplicateWindow = 10 * time.Minute
+	// natsEphemeralTimeout is how long an ephemeral consumer outlives the process that stopped consuming it
+	natsEphemeralTimeout = time.Minute
+)
+
+// natsEventBus publishes events to NATS JetStream, which stores them until every consumer acknowledged them
+type natsEventBus struct {
+	conn *nats.Conn
+	js   jetstream.JetStream
+}
+
+// NewNATSEventBus connects to a NATS server with JetStream enabled, like `nats -js` in a container,
+// and creates the stream of the order events if it doesn't exist
+func NewNATSEventBus(url string) (EventBus, error) {
+	conn, err := nats.Connect(url)
+	if err != nil {
+		return nil, err
+	}
+	js, err := jetstream.New(conn)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	_, err = js.CreateOrUpdateStream(ctx, jetstream.StreamConfig{
+		Name:       natsStream,
+		Subjects:   []string{natsStreamSubject},
+		Storage:    jetstream.FileStorage,
+		Duplicates: natsDuplicateWindow,
+	})
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &natsEventBus{conn: conn, js: js}, nil
+}
+
+// Publish waits for the stream to store the event, the event ID deduplicates it
+func (b *natsEventBus) Publish(ctx context.Context, e Event) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	_, err = b.js.Publish(ctx, e.Type, data, jetstream.WithMsgID(e.ID))
+	return err
+}
+
+// Subscribe creates a durable consumer with the name, it continues after the last acknowledged
+// event when the consumer subscribes again. Events handle fails on are redelivered.
+// Without a name the consumer is ephemeral, the server deletes it once this process is gone.
+func (b *natsEventBus) Subscribe(ctx context.Context, name string, handle EventHandler) error {
+	config := jetstream.ConsumerConfig{
+		Durable:       name,
+		FilterSubject: natsStreamSubject,
+		AckPolicy:     jetstream.AckExplicitPolicy,
+		DeliverPolicy: jetstream.DeliverNewPolicy,
+	}
+	if name == "" {
+		config.InactiveThreshold = natsEphemeralTimeout
+	}
+	consumer, err := b.js.CreateOrUpdateConsumer(ctx, natsStream, config)
+	if err != nil {
+		return err
+	}
+
+	consumeCtx, err := consumer.Consume(func(msg jetstream.Msg) {
+		var e Event
+		if err := json.Unmarshal(msg.Data(), &e); err != nil {
+			// it will never decode, don't redeliver it
+			log.Println("Error decoding order event: ", err)
+			msg.Term()
+			return
+		}
+		if err := handle(ctx, e); err != nil {
+			log.Println("Error handling order event: ", err)
+			msg.Nak()
+			return
+		}
+		msg.Ack()
+	})
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		<-ctx.Done()
+		consumeCtx.Stop()
+	}()
+	return nil
+}
+
+func (b *natsEventBus) Close() {
+	b.conn.Close()
+}
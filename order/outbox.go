@@ -0,0 +1,164 @@
+package order
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/segmentio/ksuid"
+)
+
+// Types of the domain events the order service publishes
+const (
+	OrderCreatedEvent       = "order.created"
+	OrderStatusChangedEvent = "order.status_changed"
+)
+
+// Event is a domain event of the order service.
+// The repositories write it to the outbox in the same transaction as the change it describes,
+// so an event exists if and only if the change was committed. The Relay publishes it afterwards.
+// Delivery is at least once, consumers should skip events whose ID they have seen.
+type Event struct {
+	ID        string    `json:"id"`
+	Type      string    `json:"type"`
+	OrderID   string    `json:"order_id"`
+	CreatedAt time.Time `json:"created_at"`
+	// Payload is an OrderCreated or OrderStatusChanged, depending on Type
+	Payload json.RawMessage `json:"payload"`
+}
+
+// OrderCreated is the payload of an OrderCreatedEvent
+type OrderCreated struct {
+	OrderID    string           `json:"order_id"`
+	AccountID  string           `json:"account_id"`
+	CreatedAt  time.Time        `json:"created_at"`
+	Subtotal   float64          `json:"subtotal"`
+	TotalPrice float64          `json:"total_price"`
+	Status     Status           `json:"status"`
+	Products   []OrderedProduct `json:"products"`
+	CouponCode string           `json:"coupon_code,omitempty"`
+	Discounts  []Discount       `json:"discounts,omitempty"`
+}
+
+// OrderStatusChanged is the payload of an OrderStatusChangedEvent
+type OrderStatusChanged struct {
+	OrderID   string    `json:"order_id"`
+	AccountID string    `json:"account_id"`
+	From      Status    `json:"from"`
+	To        Status    `json:"to"`
+	ChangedAt time.Time `json:"changed_at"`
+}
+
+func newEvent(eventType, orderID string, createdAt time.Time, payload interface{}) (Event, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return Event{}, err
+	}
+	return Event{
+		ID:        ksuid.New().String(),
+		Type:      eventType,
+		OrderID:   orderID,
+		CreatedAt: createdAt,
+		Payload:   data,
+	}, nil
+}
+
+func newOrderCreatedEvent(o Order) (Event, error) {
+	return newEvent(OrderCreatedEvent, o.ID, o.CreatedAt, OrderCreated{
+		OrderID:    o.ID,
+		AccountID:  o.AccountID,
+		CreatedAt:  o.CreatedAt,
+		Subtotal:   o.Subtotal,
+		TotalPrice: o.TotalPrice,
+		Status:     o.Status,
+		Products:   o.Products,
+		CouponCode: o.CouponCode,
+		Discounts:  o.Discounts,
+	})
+}
+
+func newOrderStatusChangedEvent(t StatusTransition, accountID string) (Event, error) {
+	return newEvent(OrderStatusChangedEvent, t.OrderID, t.CreatedAt, OrderStatusChanged{
+		OrderID:   t.OrderID,
+		AccountID: accountID,
+		From:      t.From,
+		To:        t.To,
+		ChangedAt: t.CreatedAt,
+	})
+}
+
+// Outbox holds the events written by PutOrder and UpdateOrderStatus until they are published
+type Outbox interface {
+	// UnpublishedEvents returns up to limit events that weren't published yet, in the order they were written
+	UnpublishedEvents(ctx context.Context, limit int) ([]Event, error)
+	// MarkPublished removes the events from the unpublished ones
+	MarkPublished(ctx context.Context, ids []string) error
+}
+
+// relayBatchSize is how many events the relay reads from the outbox at once
+const relayBatchSize = 100
+
+// Relay publishes the events of the outbox to the event bus.
+// An event is only marked as published after the bus accepted it, if the process dies in between
+// it's published again. Run one relay per outbox, two would publish the same events twice.
+type Relay struct {
+	outbox   Outbox
+	bus      EventBus
+	interval time.Duration
+}
+
+// NewRelay returns a relay that checks the outbox for new events every interval
+func NewRelay(outbox Outbox, bus EventBus, interval time.Duration) *Relay {
+	return &Relay{outbox: outbox, bus: bus, interval: interval}
+}
+
+// Run publishes events until ctx is done
+func (r *Relay) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+	for {
+		if err := r.publishPending(ctx); err != nil && ctx.Err() == nil {
+			log.Println("Error relaying order events: ", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// publishPending publishes batches until the outbox is empty.
+// It stops at the first event the bus rejects, so events of an order stay in order.
+func (r *Relay) publishPending(ctx context.Context) error {
+	for {
+		events, err := r.outbox.UnpublishedEvents(ctx, relayBatchSize)
+		if err != nil {
+			return err
+		}
+		if len(events) == 0 {
+			return nil
+		}
+
+		var published []string
+		var publishErr error
+		for _, e := range events {
+			if publishErr = r.bus.Publish(ctx, e); publishErr != nil {
+				break
+			}
+			published = append(published, e.ID)
+		}
+		if len(published) > 0 {
+			if err := r.outbox.MarkPublished(ctx, published); err != nil {
+				return err
+			}
+		}
+		if publishErr != nil {
+			return publishErr
+		}
+		if len(events) < relayBatchSize {
+			return nil
+		}
+	}
+}
@@ -3,14 +3,57 @@ package order
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
 
 	"github.com/lib/pq"
 )
 
+var (
+	ErrNotFound = errors.New("order not found")
+	// ErrDuplicateIdempotencyKey is returned by PutOrder when the account already
+	// has an order with the same idempotency key.
+	ErrDuplicateIdempotencyKey = errors.New("duplicate idempotency key")
+)
+
+// selectOrders selects orders joined with their products, in the column order expected by scanOrders.
+// Callers append the WHERE clause and must order by o.id.
+const selectOrders = `SELECT
+		o.id,
+		o.created_at,
+		o.account_id,
+		COALESCE(o.subtotal, o.total_price)::money::numeric::float8,
+		o.total_price::money::numeric::float8,
+		o.status,
+		COALESCE(o.idempotency_key, ''),
+		COALESCE(o.coupon_code, ''),
+		o.discounts,
+		op.product_id,
+		op.name,
+		op.description,
+		op.price::money::numeric::float8,
+		op.quantity,
+		op.discount::money::numeric::float8
+		FROM orders o JOIN order_products op ON(o.id = op.order_id)
+		`
+
+// Repository stores orders and the carts they are checked out from.
+// PutOrder and UpdateOrderStatus also write their event to the Outbox, in the same transaction, see Event.
 type Repository interface {
+	Outbox
+	CartRepository
+	CouponRepository
+	ReservationRepository
 	Close()
+	// PutOrder returns ErrCouponUsageLimit if the account used the coupon of the order as often as it may.
+	// It deletes the stock reservation of the order, ErrReservationExpired is returned if there is none.
 	PutOrder(ctx context.Context, o Order) error
-	GetOrderForAccount(ctx context.Context, accountID string) ([]Order, error)
+	GetOrder(ctx context.Context, id string) (*Order, error)
+	GetOrderByIdempotencyKey(ctx context.Context, accountID, key string) (*Order, error)
+	GetOrderForAccount(ctx context.Context, accountID string, after string, take uint64) ([]Order, error)
+	GetOrdersForAccounts(ctx context.Context, accountIDs []string) ([]Order, error)
+	UpdateOrderStatus(ctx context.Context, t StatusTransition) error
 }
 
 type postgresRepository struct {
@@ -39,7 +82,11 @@ func (r *postgresRepository) Close() {
 //
 // It does this by using a single database transaction to:
 // 1. Insert the main order record into the orders table.
-// 2. Bulk insert all the products associated with the order, using the PostgreSQL COPY command.
+// 2. Record the initial status of the order in the order_status_transitions table.
+// 3. Bulk insert all the products associated with the order, using the PostgreSQL COPY command.
+// 4. Check the usage limit of the coupon, if the order has one.
+// 5. Delete the stock reservation of the order, the order owns the stock from then on.
+// 6. Write the OrderCreated event to the outbox.
 //
 // The function returns an error if any part of this process fails.
 // The key benefits of this implementation are:
@@ -70,27 +117,59 @@ func (r *postgresRepository) PutOrder(ctx context.Context, o Order) (err error)
 	}()
 	// Insert Order
 	// Inserts the main order record into the orders table.
+	// An empty idempotency key is stored as NULL, so it doesn't collide with the unique constraint.
+	// The discount breakdown is stored as JSON, NULL if the order has none.
+	var discounts []byte
+	if len(o.Discounts) > 0 {
+		discounts, err = json.Marshal(o.Discounts)
+		if err != nil {
+			return
+		}
+	}
 	_, err = tx.ExecContext(ctx,
-		"INSERT INTO orders(id, created_at, account_id, total_price) VALUES ($1, $2, $3, $4)",
+		`INSERT INTO orders(id, created_at, account_id, subtotal, total_price, status, idempotency_key, coupon_code, discounts)
+		VALUES ($1, $2, $3, $4, $5, $6, NULLIF($7, ''), NULLIF($8, ''), $9)`,
 		o.ID,
 		o.CreatedAt,
 		o.AccountID,
+		o.Subtotal,
 		o.TotalPrice,
+		o.Status,
+		o.IdempotencyKey,
+		o.CouponCode,
+		discounts,
+	)
+	if err != nil {
+		// unique_violation on (account_id, idempotency_key) means this order was already placed
+		if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" && pqErr.Constraint == "orders_account_id_idempotency_key_key" {
+			err = ErrDuplicateIdempotencyKey
+		}
+		return
+	}
+	// Record Initial Status
+	// The first transition has no from_status as the order did not exist before.
+	_, err = tx.ExecContext(ctx,
+		"INSERT INTO order_status_transitions(order_id, from_status, to_status, created_at) VALUES ($1, NULL, $2, $3)",
+		o.ID,
+		o.Status,
+		o.CreatedAt,
 	)
 	if err != nil {
 		return
 	}
 	// Bulk Insert Products
 	// Uses PostgreSQL's COPY command (through pq.CopyIn) for efficient bulk insertion of order products.
+	// Name, description and price are stored along with the product ID, so the order
+	// keeps the product details it was placed with, even if the product changes later.
 	stmt, err := tx.PrepareContext(ctx,
-		pq.CopyIn("order_products", "order_id", "product_id", "quantity"))
+		pq.CopyIn("order_products", "order_id", "product_id", "name", "description", "price", "quantity", "discount"))
 	if err != nil {
 		return
 	}
 	// Insert Each Product
 	// Loops through each product and adds it to the bulk insert operation.
 	for _, p := range o.Products {
-		_, err = stmt.ExecContext(ctx, o.ID, p.ID, p.Quantity)
+		_, err = stmt.ExecContext(ctx, o.ID, p.ID, p.Name, p.Description, p.Price, p.Quantity, p.Discount)
 		if err != nil {
 			return
 		}
@@ -107,48 +186,461 @@ func (r *postgresRepository) PutOrder(ctx context.Context, o Order) (err error)
 		return
 	}
 
+	// Check Coupon Usage
+	// The coupon row is locked until the transaction ends, so concurrent orders of the account
+	// with the same coupon are counted one after the other. The count includes this order.
+	if o.CouponCode != "" {
+		var maxUses uint32
+		err = tx.QueryRowContext(ctx,
+			"SELECT max_uses_per_account FROM coupons WHERE code = $1 FOR UPDATE",
+			o.CouponCode,
+		).Scan(&maxUses)
+		if err == sql.ErrNoRows {
+			err = ErrCouponNotFound
+			return
+		}
+		if err != nil {
+			return
+		}
+		if maxUses > 0 {
+			var uses uint32
+			err = tx.QueryRowContext(ctx,
+				"SELECT count(*) FROM orders WHERE coupon_code = $1 AND account_id = $2 AND status <> $3",
+				o.CouponCode,
+				o.AccountID,
+				StatusCancelled,
+			).Scan(&uses)
+			if err != nil {
+				return
+			}
+			if uses > maxUses {
+				err = ErrCouponUsageLimit
+				return
+			}
+		}
+	}
+
+	// Take Over the Stock Reservation
+	// The row lock makes a Reconciler deleting the same reservation wait for this transaction.
+	// If the reservation is gone, its stock was given back and the order can't be saved.
+	var res sql.Result
+	res, err = tx.ExecContext(ctx, "DELETE FROM stock_reservations WHERE order_id = $1", o.ID)
+	if err != nil {
+		return
+	}
+	var deleted int64
+	deleted, err = res.RowsAffected()
+	if err != nil {
+		return
+	}
+	if deleted == 0 {
+		err = ErrReservationExpired
+		return
+	}
+
+	// Write the event
+	// It's only published if the transaction commits.
+	event, err := newOrderCreatedEvent(o)
+	if err != nil {
+		return
+	}
+	err = insertEvent(ctx, tx, event)
 	return
 }
 
-// This function is using a row-by-row processing approach to group products
-// with their respective orders, taking advantage of the ORDER BY o.id to ensure
-// all products for the same order are processed together.
+// GetOrder retrieves a single order and its products by order ID.
+//
+// It uses the same JOIN as GetOrderForAccount, filtered on the order ID instead
+// of the account ID. ErrNotFound is returned if no order exists with the given ID.
+func (r *postgresRepository) GetOrder(ctx context.Context, id string) (*Order, error) {
+	rows, err := r.db.QueryContext(
+		ctx,
+		selectOrders+"WHERE o.id = $1 ORDER BY o.id",
+		id,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	orders, err := scanOrders(rows)
+	if err != nil {
+		return nil, err
+	}
+	if len(orders) == 0 {
+		return nil, ErrNotFound
+	}
+	return &orders[0], nil
+}
+
+// GetOrderByIdempotencyKey retrieves the order the account placed with the given idempotency key.
+// ErrNotFound is returned if there is no such order.
+func (r *postgresRepository) GetOrderByIdempotencyKey(ctx context.Context, accountID, key string) (*Order, error) {
+	rows, err := r.db.QueryContext(
+		ctx,
+		selectOrders+"WHERE o.account_id = $1 AND o.idempotency_key = $2 ORDER BY o.id",
+		accountID,
+		key,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	orders, err := scanOrders(rows)
+	if err != nil {
+		return nil, err
+	}
+	if len(orders) == 0 {
+		return nil, ErrNotFound
+	}
+	return &orders[0], nil
+}
 
-// GetOrderForAccount retrieves all orders for the given account ID.
+// GetOrderForAccount retrieves the orders of the given account ID, newest first.
 //
-// It executes a single SQL query to retrieve all orders and their products
+// It executes a single SQL query to retrieve the orders and their products
 // in a single pass. This query uses a JOIN to combine the orders and order_products
-// tables. The result is then processed in a single pass, using a row-by-row
-// approach to group products with their respective orders. The result is a slice
-// of Order objects, each containing a slice of OrderedProduct objects.
-func (r *postgresRepository) GetOrderForAccount(ctx context.Context, accountID string) ([]Order, error) {
+// tables. The result is then processed by scanOrders, which groups products with
+// their respective orders. The result is a slice of Order objects, each containing
+// a slice of OrderedProduct objects.
+//
+// Paging is keyset based: after is the ID of the last order of the previous page and
+// take limits the number of orders, 0 returns all of them. The limit is applied to the
+// orders in a subquery, since the joined rows have one row per product.
+func (r *postgresRepository) GetOrderForAccount(ctx context.Context, accountID string, after string, take uint64) ([]Order, error) {
 	// Execute SQL query to get orders and their products
+	// LIMIT NULL means no limit
 	rows, err := r.db.QueryContext(
 		ctx,
-		`SELECT
-		o.id,
-		o.created_at,
-		o.account_id,
-		o.total_price::money::numeric::float8,
-		op.product_id,
-		op.quantity
-		FROM orders o JOIN order_products op ON(o.id = op.order_id)
-		WHERE o.account_id = $1
-		ORDER BY o.id
-		`,
+		selectOrders+`WHERE o.id IN (
+			SELECT id FROM orders WHERE account_id = $1 AND ($2 = '' OR id < $2::CHAR(27)) ORDER BY id DESC LIMIT NULLIF($3, 0)
+		) ORDER BY o.id DESC`,
 		accountID,
+		after,
+		take,
 	)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
+	return scanOrders(rows)
+}
+
+// GetOrdersForAccounts retrieves the orders of several accounts with one query.
+// It's the batched version of GetOrderForAccount used by the graphql DataLoaders.
+func (r *postgresRepository) GetOrdersForAccounts(ctx context.Context, accountIDs []string) ([]Order, error) {
+	rows, err := r.db.QueryContext(
+		ctx,
+		selectOrders+"WHERE o.account_id = ANY($1) ORDER BY o.id DESC",
+		pq.Array(accountIDs),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanOrders(rows)
+}
+
+// UpdateOrderStatus moves an order from t.From to t.To and records the transition.
+//
+// The update only matches the order while it is still in t.From, so a concurrent
+// transition can't be overwritten. In that case ErrStatusConflict is returned.
+// Both writes happen in one transaction, so the history always matches the order.
+func (r *postgresRepository) UpdateOrderStatus(ctx context.Context, t StatusTransition) (err error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+			return
+		}
+		err = tx.Commit()
+	}()
+
+	// the account ID goes into the event
+	var accountID string
+	err = tx.QueryRowContext(ctx,
+		"UPDATE orders SET status = $1 WHERE id = $2 AND status = $3 RETURNING account_id",
+		t.To,
+		t.OrderID,
+		t.From,
+	).Scan(&accountID)
+	if err == sql.ErrNoRows {
+		err = ErrStatusConflict
+		return
+	}
+	if err != nil {
+		return
+	}
+
+	_, err = tx.ExecContext(ctx,
+		"INSERT INTO order_status_transitions(order_id, from_status, to_status, created_at) VALUES ($1, $2, $3, $4)",
+		t.OrderID,
+		t.From,
+		t.To,
+		t.CreatedAt,
+	)
+	if err != nil {
+		return
+	}
+
+	event, err := newOrderStatusChangedEvent(t, accountID)
+	if err != nil {
+		return
+	}
+	err = insertEvent(ctx, tx, event)
+	return
+}
+
+func insertEvent(ctx context.Context, tx *sql.Tx, e Event) error {
+	_, err := tx.ExecContext(ctx,
+		"INSERT INTO order_outbox(id, type, order_id, payload, created_at) VALUES ($1, $2, $3, $4, $5)",
+		e.ID,
+		e.Type,
+		e.OrderID,
+		[]byte(e.Payload),
+		e.CreatedAt,
+	)
+	return err
+}
+
+// UnpublishedEvents returns the oldest events without published_at
+func (r *postgresRepository) UnpublishedEvents(ctx context.Context, limit int) ([]Event, error) {
+	rows, err := r.db.QueryContext(ctx,
+		"SELECT id, type, order_id, payload, created_at FROM order_outbox WHERE published_at IS NULL ORDER BY seq LIMIT $1",
+		limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := []Event{}
+	for rows.Next() {
+		var e Event
+		var payload []byte
+		if err := rows.Scan(&e.ID, &e.Type, &e.OrderID, &payload, &e.CreatedAt); err != nil {
+			return nil, err
+		}
+		e.Payload = payload
+		events = append(events, e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+// MarkPublished sets published_at, published events are kept as a log of what happened
+func (r *postgresRepository) MarkPublished(ctx context.Context, ids []string) error {
+	_, err := r.db.ExecContext(ctx,
+		"UPDATE order_outbox SET published_at = now() WHERE id = ANY($1)",
+		pq.Array(ids),
+	)
+	return err
+}
+
+// GetCartItems returns the cart of the account, oldest item first
+func (r *postgresRepository) GetCartItems(ctx context.Context, accountID string) ([]CartItem, error) {
+	rows, err := r.db.QueryContext(ctx,
+		"SELECT product_id, quantity, added_at FROM cart_items WHERE account_id = $1 ORDER BY added_at, product_id",
+		accountID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	items := []CartItem{}
+	for rows.Next() {
+		var item CartItem
+		if err := rows.Scan(&item.ProductID, &item.Quantity, &item.AddedAt); err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+// AddCartItem inserts the item, or adds to its quantity if the cart has the product.
+// It's one statement, so concurrent adds of the same product don't lose quantities.
+func (r *postgresRepository) AddCartItem(ctx context.Context, accountID string, item CartItem) error {
+	_, err := r.db.ExecContext(ctx,
+		`INSERT INTO cart_items(account_id, product_id, quantity, added_at) VALUES ($1, $2, $3, $4)
+		ON CONFLICT (account_id, product_id) DO UPDATE SET quantity = cart_items.quantity + EXCLUDED.quantity`,
+		accountID,
+		item.ProductID,
+		item.Quantity,
+		item.AddedAt,
+	)
+	return err
+}
+
+// SetCartItemQuantity returns ErrNotInCart if no row was updated
+func (r *postgresRepository) SetCartItemQuantity(ctx context.Context, accountID, productID string, quantity uint32) error {
+	res, err := r.db.ExecContext(ctx,
+		"UPDATE cart_items SET quantity = $3 WHERE account_id = $1 AND product_id = $2",
+		accountID,
+		productID,
+		quantity,
+	)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNotInCart
+	}
+	return nil
+}
+
+func (r *postgresRepository) RemoveCartItems(ctx context.Context, accountID string, productIDs []string) error {
+	_, err := r.db.ExecContext(ctx,
+		"DELETE FROM cart_items WHERE account_id = $1 AND product_id = ANY($2)",
+		accountID,
+		pq.Array(productIDs),
+	)
+	return err
+}
+
+// PutCoupon inserts the coupon, ErrCouponExists is returned if the code is taken
+func (r *postgresRepository) PutCoupon(ctx context.Context, c Coupon) error {
+	_, err := r.db.ExecContext(ctx,
+		`INSERT INTO coupons(code, type, value, product_id, buy_quantity, get_quantity, min_spend, valid_from, valid_until, max_uses_per_account, created_at)
+		VALUES ($1, $2, $3, NULLIF($4, ''), $5, $6, $7, $8, $9, $10, $11)`,
+		c.Code,
+		c.Type,
+		c.Value,
+		c.ProductID,
+		c.BuyQuantity,
+		c.GetQuantity,
+		c.MinSpend,
+		nullTime(c.ValidFrom),
+		nullTime(c.ValidUntil),
+		c.MaxUsesPerAccount,
+		c.CreatedAt,
+	)
+	if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
+		return ErrCouponExists
+	}
+	return err
+}
+
+// GetCoupon returns ErrCouponNotFound if there is no coupon with the code
+func (r *postgresRepository) GetCoupon(ctx context.Context, code string) (*Coupon, error) {
+	var c Coupon
+	var validFrom, validUntil sql.NullTime
+	err := r.db.QueryRowContext(ctx,
+		`SELECT code, type, value, COALESCE(product_id, ''), buy_quantity, get_quantity, min_spend::numeric::float8,
+		valid_from, valid_until, max_uses_per_account, created_at FROM coupons WHERE code = $1`,
+		code,
+	).Scan(
+		&c.Code,
+		&c.Type,
+		&c.Value,
+		&c.ProductID,
+		&c.BuyQuantity,
+		&c.GetQuantity,
+		&c.MinSpend,
+		&validFrom,
+		&validUntil,
+		&c.MaxUsesPerAccount,
+		&c.CreatedAt,
+	)
+	if err == sql.ErrNoRows {
+		return nil, ErrCouponNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	c.ValidFrom, c.ValidUntil = validFrom.Time, validUntil.Time
+	return &c, nil
+}
+
+// PutReservation inserts the reservation, the products are stored as JSON
+func (r *postgresRepository) PutReservation(ctx context.Context, res Reservation) error {
+	products, err := json.Marshal(res.Products)
+	if err != nil {
+		return err
+	}
+	_, err = r.db.ExecContext(ctx,
+		"INSERT INTO stock_reservations(order_id, products, created_at) VALUES ($1, $2, $3)",
+		res.OrderID,
+		products,
+		res.CreatedAt,
+	)
+	return err
+}
+
+// DeleteReservation deletes the row, concurrent deletes of the same reservation see it deleted once
+func (r *postgresRepository) DeleteReservation(ctx context.Context, orderID string) (bool, error) {
+	res, err := r.db.ExecContext(ctx, "DELETE FROM stock_reservations WHERE order_id = $1", orderID)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
+
+func (r *postgresRepository) StaleReservations(ctx context.Context, before time.Time, limit int) ([]Reservation, error) {
+	rows, err := r.db.QueryContext(ctx,
+		"SELECT order_id, products, created_at FROM stock_reservations WHERE created_at < $1 ORDER BY created_at, order_id LIMIT $2",
+		before,
+		limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	reservations := []Reservation{}
+	for rows.Next() {
+		var res Reservation
+		var products []byte
+		if err := rows.Scan(&res.OrderID, &products, &res.CreatedAt); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(products, &res.Products); err != nil {
+			return nil, err
+		}
+		reservations = append(reservations, res)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return reservations, nil
+}
+
+// nullTime stores the zero time as NULL, it leaves the validity window of a coupon open
+func nullTime(t time.Time) sql.NullTime {
+	return sql.NullTime{Time: t, Valid: !t.IsZero()}
+}
+
+// scanOrders is using a row-by-row processing approach to group products
+// with their respective orders. The query must be ordered by o.id to ensure
+// all products for the same order are processed together.
+func scanOrders(rows *sql.Rows) ([]Order, error) {
 	// Initialize tracking variables
 	currentOrder := &Order{}            // Holds the order currently being processed
 	lastOrder := &Order{}               // Holds the previous order for comparison
 	orders := []Order{}                 // Final slice of all orders
 	orderedProduct := &OrderedProduct{} // Temporary holder for product data
 	products := []OrderedProduct{}      // Collects products for current order
+	var discounts []byte                // Discount breakdown of the current order as JSON
 
 	// Iterate through result rows
 	for rows.Next() {
@@ -157,22 +649,46 @@ func (r *postgresRepository) GetOrderForAccount(ctx context.Context, accountID s
 			&currentOrder.ID,
 			&currentOrder.CreatedAt,
 			&currentOrder.AccountID,
+			&currentOrder.Subtotal,
 			&currentOrder.TotalPrice,
+			&currentOrder.Status,
+			&currentOrder.IdempotencyKey,
+			&currentOrder.CouponCode,
+			&discounts,
 			&orderedProduct.ID,
+			&orderedProduct.Name,
+			&orderedProduct.Description,
+			&orderedProduct.Price,
 			&orderedProduct.Quantity,
+			&orderedProduct.Discount,
 		); err != nil {
 			return nil, err
 		}
 
+		// The breakdown is the same on every row of an order, decode it on the first one
+		if currentOrder.ID != lastOrder.ID {
+			currentOrder.Discounts = nil
+			if discounts != nil {
+				if err := json.Unmarshal(discounts, &currentOrder.Discounts); err != nil {
+					return nil, err
+				}
+			}
+		}
+
 		// If we've moved to a new order (ID changed)
 		if lastOrder.ID != "" && lastOrder.ID != currentOrder.ID {
 			// Create and append the completed order
 			newOrder := Order{
-				ID:         lastOrder.ID,
-				AccountID:  lastOrder.AccountID,
-				CreatedAt:  lastOrder.CreatedAt,
-				TotalPrice: lastOrder.TotalPrice,
-				Products:   products, // Assign collected products
+				ID:             lastOrder.ID,
+				AccountID:      lastOrder.AccountID,
+				CreatedAt:      lastOrder.CreatedAt,
+				Subtotal:       lastOrder.Subtotal,
+				TotalPrice:     lastOrder.TotalPrice,
+				Status:         lastOrder.Status,
+				IdempotencyKey: lastOrder.IdempotencyKey,
+				CouponCode:     lastOrder.CouponCode,
+				Discounts:      lastOrder.Discounts,
+				Products:       products, // Assign collected products
 			}
 			orders = append(orders, newOrder)
 
@@ -181,10 +697,7 @@ func (r *postgresRepository) GetOrderForAccount(ctx context.Context, accountID s
 		}
 
 		// Add current product to products slice
-		products = append(products, OrderedProduct{
-			ID:       orderedProduct.ID,
-			Quantity: orderedProduct.Quantity,
-		})
+		products = append(products, *orderedProduct)
 
 		// Update lastOrder for next iteration
 		*lastOrder = *currentOrder
@@ -193,17 +706,22 @@ func (r *postgresRepository) GetOrderForAccount(ctx context.Context, accountID s
 	// Handle the last order after loop ends
 	if lastOrder.ID != "" {
 		newOrder := Order{
-			ID:         lastOrder.ID,
-			AccountID:  lastOrder.AccountID,
-			CreatedAt:  lastOrder.CreatedAt,
-			TotalPrice: lastOrder.TotalPrice,
-			Products:   products, // Assign collected products
+			ID:             lastOrder.ID,
+			AccountID:      lastOrder.AccountID,
+			CreatedAt:      lastOrder.CreatedAt,
+			Subtotal:       lastOrder.Subtotal,
+			TotalPrice:     lastOrder.TotalPrice,
+			Status:         lastOrder.Status,
+			IdempotencyKey: lastOrder.IdempotencyKey,
+			CouponCode:     lastOrder.CouponCode,
+			Discounts:      lastOrder.Discounts,
+			Products:       products, // Assign collected products
 		}
 		orders = append(orders, newOrder)
 	}
 
 	// Check for any errors that occurred during iteration
-	if err = rows.Err(); err != nil {
+	if err := rows.Err(); err != nil {
 		return nil, err
 	}
 
@@ -0,0 +1,617 @@
+package order
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/segmentio/ksuid"
+)
+
+// The conformance suite runs against every Repository implementation.
+// PostgreSQL is only tested if TEST_DATABASE_URL is set, its order tables are emptied by every test.
+func TestRepositories(t *testing.T) {
+	implementations := map[string]func(t *testing.T) Repository{
+		"memory": func(t *testing.T) Repository {
+			return NewMemoryRepository()
+		},
+		"postgres": newTestPostgresRepository,
+	}
+	for name, newRepository := range implementations {
+		t.Run(name, func(t *testing.T) {
+			testRepository(t, newRepository)
+		})
+	}
+}
+
+func newTestPostgresRepository(t *testing.T) Repository {
+	url := os.Getenv("TEST_DATABASE_URL")
+	if url == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+	r, err := NewPostgresRepository(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	schema, err := os.ReadFile("up.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := r.(*postgresRepository).db
+	if _, err := db.Exec(string(schema)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec("TRUNCATE orders, order_products, order_status_transitions, order_outbox, cart_items, coupons, stock_reservations"); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(r.Close)
+	return r
+}
+
+// newTestOrder returns a pending order with one product.
+// The time is truncated to microseconds, which is what postgres stores.
+func newTestOrder(accountID, idempotencyKey string) Order {
+	return Order{
+		ID:         ksuid.New().String(),
+		CreatedAt:  time.Now().UTC().Truncate(time.Microsecond),
+		AccountID:  accountID,
+		Subtotal:   21.5,
+		TotalPrice: 21.5,
+		Status:     StatusPending,
+		Products: []OrderedProduct{
+			{ID: ksuid.New().String(), Name: "Mug", Description: "A mug", Price: 10.75, Quantity: 2},
+		},
+		IdempotencyKey: idempotencyKey,
+	}
+}
+
+// newReservation returns the stock reservation PostOrder records for the order
+func newReservation(o Order) Reservation {
+	return Reservation{OrderID: o.ID, Products: o.Products, CreatedAt: o.CreatedAt}
+}
+
+// placeOrder saves the order like PostOrder does, after recording its stock reservation
+func placeOrder(ctx context.Context, r Repository, o Order) error {
+	if err := r.PutReservation(ctx, newReservation(o)); err != nil {
+		return err
+	}
+	return r.PutOrder(ctx, o)
+}
+
+func testRepository(t *testing.T, newRepository func(t *testing.T) Repository) {
+	ctx := context.Background()
+
+	t.Run("get", func(t *testing.T) {
+		r := newRepository(t)
+		o := newTestOrder(ksuid.New().String(), "")
+		o.Products = append(o.Products, OrderedProduct{ID: ksuid.New().String(), Name: "Tea", Description: "Green tea", Price: 4.2, Quantity: 1})
+		if err := placeOrder(ctx, r, o); err != nil {
+			t.Fatal(err)
+		}
+
+		got, err := r.GetOrder(ctx, o.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertOrders(t, []Order{*got}, []Order{o})
+
+		if _, err := r.GetOrder(ctx, ksuid.New().String()); err != ErrNotFound {
+			t.Errorf("GetOrder of unknown order: %v, want ErrNotFound", err)
+		}
+	})
+
+	t.Run("idempotency key", func(t *testing.T) {
+		r := newRepository(t)
+		accountID := ksuid.New().String()
+		o := newTestOrder(accountID, "key")
+		if err := placeOrder(ctx, r, o); err != nil {
+			t.Fatal(err)
+		}
+
+		got, err := r.GetOrderByIdempotencyKey(ctx, accountID, "key")
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertOrders(t, []Order{*got}, []Order{o})
+
+		if err := placeOrder(ctx, r, newTestOrder(accountID, "key")); err != ErrDuplicateIdempotencyKey {
+			t.Errorf("PutOrder with a used key: %v, want ErrDuplicateIdempotencyKey", err)
+		}
+		// keys are unique per account, and orders without one don't collide
+		for _, o := range []Order{newTestOrder(ksuid.New().String(), "key"), newTestOrder(accountID, ""), newTestOrder(accountID, "")} {
+			if err := placeOrder(ctx, r, o); err != nil {
+				t.Error(err)
+			}
+		}
+
+		if _, err := r.GetOrderByIdempotencyKey(ctx, accountID, "other"); err != ErrNotFound {
+			t.Errorf("GetOrderByIdempotencyKey of unknown key: %v, want ErrNotFound", err)
+		}
+	})
+
+	t.Run("list", func(t *testing.T) {
+		r := newRepository(t)
+		alice, bob := ksuid.New().String(), ksuid.New().String()
+		var aliceOrders, bobOrders []Order
+		for i := 0; i < 4; i++ {
+			for _, account := range []string{alice, bob} {
+				o := newTestOrder(account, "")
+				if err := placeOrder(ctx, r, o); err != nil {
+					t.Fatal(err)
+				}
+				if account == alice {
+					aliceOrders = append(aliceOrders, o)
+				} else {
+					bobOrders = append(bobOrders, o)
+				}
+			}
+		}
+		// newest first
+		newestFirst := func(orders []Order) {
+			sort.Slice(orders, func(i, j int) bool { return orders[i].ID > orders[j].ID })
+		}
+		newestFirst(aliceOrders)
+		newestFirst(bobOrders)
+
+		for _, tc := range []struct {
+			after string
+			take  uint64
+			want  []Order
+		}{
+			{"", 0, aliceOrders},
+			{"", 2, aliceOrders[:2]},
+			{aliceOrders[1].ID, 0, aliceOrders[2:]},
+			{aliceOrders[1].ID, 1, aliceOrders[2:3]},
+			{aliceOrders[3].ID, 0, []Order{}},
+		} {
+			got, err := r.GetOrderForAccount(ctx, alice, tc.after, tc.take)
+			if err != nil {
+				t.Fatal(err)
+			}
+			assertOrders(t, got, tc.want)
+		}
+
+		got, err := r.GetOrdersForAccounts(ctx, []string{alice, bob})
+		if err != nil {
+			t.Fatal(err)
+		}
+		all := append(append([]Order{}, aliceOrders...), bobOrders...)
+		newestFirst(all)
+		assertOrders(t, got, all)
+	})
+
+	t.Run("update status", func(t *testing.T) {
+		r := newRepository(t)
+		o := newTestOrder(ksuid.New().String(), "")
+		if err := placeOrder(ctx, r, o); err != nil {
+			t.Fatal(err)
+		}
+
+		paid := StatusTransition{OrderID: o.ID, From: StatusPending, To: StatusPaid, CreatedAt: time.Now().UTC()}
+		if err := r.UpdateOrderStatus(ctx, paid); err != nil {
+			t.Fatal(err)
+		}
+		got, err := r.GetOrder(ctx, o.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Status != StatusPaid {
+			t.Errorf("status = %s, want %s", got.Status, StatusPaid)
+		}
+
+		// the order isn't pending anymore
+		cancelled := StatusTransition{OrderID: o.ID, From: StatusPending, To: StatusCancelled, CreatedAt: time.Now().UTC()}
+		if err := r.UpdateOrderStatus(ctx, cancelled); err != ErrStatusConflict {
+			t.Errorf("UpdateOrderStatus from a stale status: %v, want ErrStatusConflict", err)
+		}
+		cancelled.OrderID = ksuid.New().String()
+		if err := r.UpdateOrderStatus(ctx, cancelled); err != ErrStatusConflict {
+			t.Errorf("UpdateOrderStatus of unknown order: %v, want ErrStatusConflict", err)
+		}
+	})
+
+	t.Run("outbox", func(t *testing.T) {
+		r := newRepository(t)
+		accountID := ksuid.New().String()
+		o := newTestOrder(accountID, "key")
+		if err := placeOrder(ctx, r, o); err != nil {
+			t.Fatal(err)
+		}
+		// failed writes don't leave events behind
+		if err := placeOrder(ctx, r, newTestOrder(accountID, "key")); err != ErrDuplicateIdempotencyKey {
+			t.Fatalf("PutOrder with a used key: %v, want ErrDuplicateIdempotencyKey", err)
+		}
+		paid := StatusTransition{OrderID: o.ID, From: StatusPending, To: StatusPaid, CreatedAt: time.Now().UTC().Truncate(time.Microsecond)}
+		if err := r.UpdateOrderStatus(ctx, paid); err != nil {
+			t.Fatal(err)
+		}
+		if err := r.UpdateOrderStatus(ctx, paid); err != ErrStatusConflict {
+			t.Fatalf("UpdateOrderStatus from a stale status: %v, want ErrStatusConflict", err)
+		}
+
+		events, err := r.UnpublishedEvents(ctx, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(events) != 2 {
+			t.Fatalf("%d unpublished events, want 2", len(events))
+		}
+		created, changed := events[0], events[1]
+		if created.Type != OrderCreatedEvent || created.OrderID != o.ID {
+			t.Errorf("first event = %s of %s, want %s of %s", created.Type, created.OrderID, OrderCreatedEvent, o.ID)
+		}
+		var createdPayload OrderCreated
+		if err := json.Unmarshal(created.Payload, &createdPayload); err != nil {
+			t.Fatal(err)
+		}
+		if createdPayload.AccountID != accountID || createdPayload.TotalPrice != o.TotalPrice || !reflect.DeepEqual(createdPayload.Products, o.Products) {
+			t.Errorf("OrderCreated = %+v, want the order %+v", createdPayload, o)
+		}
+		if changed.Type != OrderStatusChangedEvent || changed.OrderID != o.ID {
+			t.Errorf("second event = %s of %s, want %s of %s", changed.Type, changed.OrderID, OrderStatusChangedEvent, o.ID)
+		}
+		var changedPayload OrderStatusChanged
+		if err := json.Unmarshal(changed.Payload, &changedPayload); err != nil {
+			t.Fatal(err)
+		}
+		want := OrderStatusChanged{OrderID: o.ID, AccountID: accountID, From: StatusPending, To: StatusPaid, ChangedAt: paid.CreatedAt}
+		changedPayload.ChangedAt = changedPayload.ChangedAt.UTC()
+		if changedPayload != want {
+			t.Errorf("OrderStatusChanged = %+v, want %+v", changedPayload, want)
+		}
+
+		if events, err := r.UnpublishedEvents(ctx, 1); err != nil || len(events) != 1 || events[0].ID != created.ID {
+			t.Errorf("UnpublishedEvents with limit 1 = %+v, %v, want the first event", events, err)
+		}
+		if err := r.MarkPublished(ctx, []string{created.ID}); err != nil {
+			t.Fatal(err)
+		}
+		events, err = r.UnpublishedEvents(ctx, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(events) != 1 || events[0].ID != changed.ID {
+			t.Errorf("UnpublishedEvents after MarkPublished = %+v, want the second event", events)
+		}
+	})
+
+	t.Run("cart", func(t *testing.T) {
+		r := newRepository(t)
+		accountID, otherAccountID := ksuid.New().String(), ksuid.New().String()
+		mug, tea, lamp := ksuid.New().String(), ksuid.New().String(), ksuid.New().String()
+		added := time.Now().UTC().Truncate(time.Microsecond)
+
+		if items, err := r.GetCartItems(ctx, accountID); err != nil || len(items) != 0 {
+			t.Fatalf("GetCartItems of a new account = %+v, %v, want an empty cart", items, err)
+		}
+		for i, item := range []CartItem{
+			{ProductID: mug, Quantity: 2, AddedAt: added},
+			{ProductID: tea, Quantity: 1, AddedAt: added.Add(time.Second)},
+			// adding a product again adds to its quantity, it keeps its place
+			{ProductID: mug, Quantity: 3, AddedAt: added.Add(2 * time.Second)},
+		} {
+			if err := r.AddCartItem(ctx, accountID, item); err != nil {
+				t.Fatalf("AddCartItem %d: %v", i, err)
+			}
+		}
+		if err := r.AddCartItem(ctx, otherAccountID, CartItem{ProductID: mug, Quantity: 1, AddedAt: added}); err != nil {
+			t.Fatal(err)
+		}
+		assertCart(t, r, accountID, []CartItem{
+			{ProductID: mug, Quantity: 5, AddedAt: added},
+			{ProductID: tea, Quantity: 1, AddedAt: added.Add(time.Second)},
+		})
+
+		if err := r.SetCartItemQuantity(ctx, accountID, tea, 4); err != nil {
+			t.Fatal(err)
+		}
+		if err := r.SetCartItemQuantity(ctx, accountID, lamp, 1); err != ErrNotInCart {
+			t.Errorf("SetCartItemQuantity of a product not in the cart: %v, want ErrNotInCart", err)
+		}
+		assertCart(t, r, accountID, []CartItem{
+			{ProductID: mug, Quantity: 5, AddedAt: added},
+			{ProductID: tea, Quantity: 4, AddedAt: added.Add(time.Second)},
+		})
+
+		if err := r.RemoveCartItems(ctx, accountID, []string{mug, lamp}); err != nil {
+			t.Fatal(err)
+		}
+		assertCart(t, r, accountID, []CartItem{
+			{ProductID: tea, Quantity: 4, AddedAt: added.Add(time.Second)},
+		})
+		// carts are per account
+		assertCart(t, r, otherAccountID, []CartItem{
+			{ProductID: mug, Quantity: 1, AddedAt: added},
+		})
+	})
+
+	t.Run("concurrent cart adds", func(t *testing.T) {
+		r := newRepository(t)
+		accountID, productID := ksuid.New().String(), ksuid.New().String()
+
+		// no add may be lost, even when they all insert the item at once
+		var wg sync.WaitGroup
+		for i := 0; i < 20; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if err := r.AddCartItem(ctx, accountID, CartItem{ProductID: productID, Quantity: 1, AddedAt: time.Now().UTC()}); err != nil {
+					t.Error(err)
+				}
+			}()
+		}
+		wg.Wait()
+
+		items, err := r.GetCartItems(ctx, accountID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(items) != 1 || items[0].Quantity != 20 {
+			t.Errorf("cart = %+v, want 20 of the product", items)
+		}
+	})
+
+	t.Run("coupons", func(t *testing.T) {
+		r := newRepository(t)
+		now := time.Now().UTC().Truncate(time.Microsecond)
+		c := Coupon{
+			Code:              "MUGS3FOR2",
+			Type:              CouponBuyXGetY,
+			ProductID:         ksuid.New().String(),
+			BuyQuantity:       2,
+			GetQuantity:       1,
+			MinSpend:          10.5,
+			ValidFrom:         now.Add(-time.Hour),
+			ValidUntil:        now.Add(time.Hour),
+			MaxUsesPerAccount: 3,
+			CreatedAt:         now,
+		}
+		if _, err := r.GetCoupon(ctx, c.Code); err != ErrCouponNotFound {
+			t.Errorf("GetCoupon of unknown code: %v, want ErrCouponNotFound", err)
+		}
+		if err := r.PutCoupon(ctx, c); err != nil {
+			t.Fatal(err)
+		}
+		if err := r.PutCoupon(ctx, c); err != ErrCouponExists {
+			t.Errorf("PutCoupon with a used code: %v, want ErrCouponExists", err)
+		}
+		// open validity windows are stored as zero times
+		open := Coupon{Code: "TENOFF", Type: CouponPercentage, Value: 10, CreatedAt: now}
+		if err := r.PutCoupon(ctx, open); err != nil {
+			t.Fatal(err)
+		}
+		for _, want := range []Coupon{c, open} {
+			got, err := r.GetCoupon(ctx, want.Code)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got.ValidFrom, got.ValidUntil, got.CreatedAt = utc(got.ValidFrom), utc(got.ValidUntil), got.CreatedAt.UTC()
+			if *got != want {
+				t.Errorf("GetCoupon = %+v, want %+v", *got, want)
+			}
+		}
+
+		// the discount breakdown is stored with the order
+		o := newTestOrder(ksuid.New().String(), "")
+		o.Products = append(o.Products, OrderedProduct{ID: c.ProductID, Name: "Tea", Description: "Green tea", Price: 4, Quantity: 3, Discount: 4})
+		o.Subtotal = 33.5
+		o.TotalPrice = 29.5
+		o.CouponCode = c.Code
+		o.Discounts = []Discount{{CouponCode: c.Code, ProductID: c.ProductID, Description: "buy 2 get 1 free", Amount: 4}}
+		if err := placeOrder(ctx, r, o); err != nil {
+			t.Fatal(err)
+		}
+		got, err := r.GetOrder(ctx, o.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertOrders(t, []Order{*got}, []Order{o})
+
+		unknown := newTestOrder(ksuid.New().String(), "")
+		unknown.CouponCode = "UNKNOWN"
+		if err := placeOrder(ctx, r, unknown); err != ErrCouponNotFound {
+			t.Errorf("PutOrder with an unknown coupon: %v, want ErrCouponNotFound", err)
+		}
+	})
+
+	t.Run("coupon usage limit", func(t *testing.T) {
+		r := newRepository(t)
+		c := Coupon{Code: "TWICE", Type: CouponFixed, Value: 5, MaxUsesPerAccount: 2, CreatedAt: time.Now().UTC()}
+		if err := r.PutCoupon(ctx, c); err != nil {
+			t.Fatal(err)
+		}
+		accountID := ksuid.New().String()
+		orderWithCoupon := func(accountID string) Order {
+			o := newTestOrder(accountID, "")
+			o.CouponCode = c.Code
+			return o
+		}
+
+		first := orderWithCoupon(accountID)
+		for _, o := range []Order{first, orderWithCoupon(accountID)} {
+			if err := placeOrder(ctx, r, o); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := placeOrder(ctx, r, orderWithCoupon(accountID)); err != ErrCouponUsageLimit {
+			t.Errorf("PutOrder over the limit: %v, want ErrCouponUsageLimit", err)
+		}
+		// the limit is per account
+		if err := placeOrder(ctx, r, orderWithCoupon(ksuid.New().String())); err != nil {
+			t.Errorf("PutOrder of another account: %v", err)
+		}
+		// cancelled orders give the use back
+		cancelled := StatusTransition{OrderID: first.ID, From: StatusPending, To: StatusCancelled, CreatedAt: time.Now().UTC()}
+		if err := r.UpdateOrderStatus(ctx, cancelled); err != nil {
+			t.Fatal(err)
+		}
+		if err := placeOrder(ctx, r, orderWithCoupon(accountID)); err != nil {
+			t.Errorf("PutOrder after cancelling an order: %v", err)
+		}
+	})
+
+	t.Run("concurrent coupon use", func(t *testing.T) {
+		r := newRepository(t)
+		c := Coupon{Code: "ONCE", Type: CouponPercentage, Value: 10, MaxUsesPerAccount: 1, CreatedAt: time.Now().UTC()}
+		if err := r.PutCoupon(ctx, c); err != nil {
+			t.Fatal(err)
+		}
+
+		// only one of the orders can use the coupon
+		accountID := ksuid.New().String()
+		var wg sync.WaitGroup
+		errs := make(chan error, 10)
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				o := newTestOrder(accountID, "")
+				o.CouponCode = c.Code
+				errs <- placeOrder(ctx, r, o)
+			}()
+		}
+		wg.Wait()
+		close(errs)
+
+		succeeded := 0
+		for err := range errs {
+			switch err {
+			case nil:
+				succeeded++
+			case ErrCouponUsageLimit:
+			default:
+				t.Error(err)
+			}
+		}
+		if succeeded != 1 {
+			t.Errorf("%d orders used the coupon, want 1", succeeded)
+		}
+	})
+
+	t.Run("concurrent status updates", func(t *testing.T) {
+		r := newRepository(t)
+		o := newTestOrder(ksuid.New().String(), "")
+		if err := placeOrder(ctx, r, o); err != nil {
+			t.Fatal(err)
+		}
+
+		// only one of the transitions out of pending can win
+		var wg sync.WaitGroup
+		errs := make(chan error, 10)
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				errs <- r.UpdateOrderStatus(ctx, StatusTransition{OrderID: o.ID, From: StatusPending, To: StatusPaid, CreatedAt: time.Now().UTC()})
+			}()
+		}
+		wg.Wait()
+		close(errs)
+
+		succeeded := 0
+		for err := range errs {
+			switch err {
+			case nil:
+				succeeded++
+			case ErrStatusConflict:
+			default:
+				t.Error(err)
+			}
+		}
+		if succeeded != 1 {
+			t.Errorf("%d transitions succeeded, want 1", succeeded)
+		}
+	})
+
+	t.Run("reservations", func(t *testing.T) {
+		r := newRepository(t)
+		accountID := ksuid.New().String()
+		older, newer := newTestOrder(accountID, ""), newTestOrder(accountID, "")
+		older.CreatedAt = older.CreatedAt.Add(-time.Hour)
+		for _, o := range []Order{newer, older} {
+			if err := r.PutReservation(ctx, newReservation(o)); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		stale, err := r.StaleReservations(ctx, time.Now().Add(-time.Minute), 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(stale) != 1 || stale[0].OrderID != older.ID || !reflect.DeepEqual(stale[0].Products, older.Products) {
+			t.Errorf("StaleReservations = %+v, want the reservation of %s", stale, older.ID)
+		}
+		if all, err := r.StaleReservations(ctx, time.Now().Add(time.Minute), 10); err != nil || len(all) != 2 || all[0].OrderID != older.ID {
+			t.Errorf("StaleReservations of all = %+v, %v, want both, oldest first", all, err)
+		}
+
+		// saving the order takes over its reservation
+		if err := r.PutOrder(ctx, newer); err != nil {
+			t.Fatal(err)
+		}
+		if deleted, err := r.DeleteReservation(ctx, newer.ID); err != nil || deleted {
+			t.Errorf("DeleteReservation of a saved order = %v, %v, want false", deleted, err)
+		}
+
+		// an order whose reservation was released can't be saved
+		if deleted, err := r.DeleteReservation(ctx, older.ID); err != nil || !deleted {
+			t.Errorf("DeleteReservation = %v, %v, want true", deleted, err)
+		}
+		if err := r.PutOrder(ctx, older); err != ErrReservationExpired {
+			t.Errorf("PutOrder without reservation: %v, want ErrReservationExpired", err)
+		}
+		if _, err := r.GetOrder(ctx, older.ID); err != ErrNotFound {
+			t.Errorf("GetOrder of order without reservation: %v, want ErrNotFound", err)
+		}
+		if all, err := r.StaleReservations(ctx, time.Now().Add(time.Minute), 10); err != nil || len(all) != 0 {
+			t.Errorf("StaleReservations after deleting all = %+v, %v, want none", all, err)
+		}
+	})
+}
+
+// utc converts t to UTC, keeping the zero time
+func utc(t time.Time) time.Time {
+	if t.IsZero() {
+		return t
+	}
+	return t.UTC()
+}
+
+// assertCart compares the cart of the account, the times are compared in UTC like in assertOrders
+func assertCart(t *testing.T, r Repository, accountID string, want []CartItem) {
+	t.Helper()
+	got, err := r.GetCartItems(context.Background(), accountID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range got {
+		got[i].AddedAt = got[i].AddedAt.UTC()
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("cart = %+v, want %+v", got, want)
+	}
+}
+
+// assertOrders compares orders, the times are compared in UTC since postgres returns them in its time zone.
+// The products are compared by ID, their order within an order isn't defined.
+func assertOrders(t *testing.T, got, want []Order) {
+	t.Helper()
+	normalize := func(orders []Order) []Order {
+		normalized := []Order{}
+		for _, o := range orders {
+			o.CreatedAt = o.CreatedAt.UTC()
+			o.Products = append([]OrderedProduct{}, o.Products...)
+			sort.Slice(o.Products, func(i, j int) bool { return o.Products[i].ID < o.Products[j].ID })
+			normalized = append(normalized, o)
+		}
+		return normalized
+	}
+	if g, w := normalize(got), normalize(want); !reflect.DeepEqual(g, w) {
+		t.Errorf("orders = %+v, want %+v", g, w)
+	}
+}
@@ -0,0 +1,115 @@
+package order
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+)
+
+// ErrReservationExpired is returned by PutOrder if the order has no stock reservation anymore.
+// The Reconciler cancelled it because saving the order took too long, the order can't be placed with it.
+var ErrReservationExpired = errors.New("stock reservation expired")
+
+// Reservation is the stock PostOrder takes from the inventory for an order that isn't saved yet,
+// its ID in the inventory is the order ID. It's written before the stock is reserved and PutOrder deletes it
+// in the transaction that saves the order, from then on the order owns the stock. A reservation that stays
+// behind belongs to an order that was never saved, because the process died, the inventory didn't answer
+// or the repository failed, and the Reconciler cancels it. The inventory gives back what the reservation
+// took, nothing if the stock was never reserved.
+type Reservation struct {
+	OrderID   string           `json:"order_id"`
+	Products  []OrderedProduct `json:"products"`
+	CreatedAt time.Time        `json:"created_at"`
+}
+
+// ReservationRepository stores the reservations of the orders being placed
+type ReservationRepository interface {
+	PutReservation(ctx context.Context, r Reservation) error
+	// DeleteReservation removes the reservation of the order and reports if there was one.
+	// Only the caller that deleted it cancels it, so the stock is given back once.
+	DeleteReservation(ctx context.Context, orderID string) (bool, error)
+	// StaleReservations returns up to limit reservations created before the given time, oldest first
+	StaleReservations(ctx context.Context, before time.Time, limit int) ([]Reservation, error)
+}
+
+// cancelReservation gives back the stock of an order that won't be saved.
+// It also runs if the request was cancelled, failures are only logged, the Reconciler retries them.
+func (s orderService) cancelReservation(ctx context.Context, r Reservation) {
+	if err := releaseReservation(context.WithoutCancel(ctx), s.repository, s.inventory, r); err != nil {
+		log.Println("Error cancelling stock reservation: ", err)
+	}
+}
+
+// releaseReservation deletes the reservation and cancels it in the inventory. Once it's deleted PutOrder
+// can't save the order anymore. If the inventory fails the reservation is put back, so the Reconciler
+// tries again, cancelling it twice is fine since the inventory only gives back what it took once.
+func releaseReservation(ctx context.Context, reservations ReservationRepository, inventory Inventory, r Reservation) error {
+	deleted, err := reservations.DeleteReservation(ctx, r.OrderID)
+	if err != nil || !deleted {
+		// if it's gone, the order was saved or another caller cancelled the reservation
+		return err
+	}
+	if err := inventory.CancelReservation(ctx, r.OrderID, r.Products); err != nil {
+		if putErr := reservations.PutReservation(context.WithoutCancel(ctx), r); putErr != nil {
+			log.Println("Error restoring stock reservation: ", putErr)
+		}
+		return err
+	}
+	return nil
+}
+
+// reconcileBatchSize is how many stale reservations the Reconciler cancels at once
+const reconcileBatchSize = 100
+
+// Reconciler cancels the reservations whose order was never saved.
+// A reservation is stale once it's older than maxAge, which has to be longer than placing an order takes,
+// including a call to the inventory that doesn't answer. A slower PostOrder fails with ErrReservationExpired.
+// Reconcilers of several processes can share a repository, a reservation is deleted before it's cancelled,
+// so only one of them cancels it.
+type Reconciler struct {
+	reservations ReservationRepository
+	inventory    Inventory
+	maxAge       time.Duration
+	interval     time.Duration
+}
+
+// NewReconciler returns a reconciler that looks for reservations older than maxAge every interval
+func NewReconciler(reservations ReservationRepository, inventory Inventory, maxAge, interval time.Duration) *Reconciler {
+	return &Reconciler{reservations: reservations, inventory: inventory, maxAge: maxAge, interval: interval}
+}
+
+// Run cancels stale reservations until ctx is done
+func (r *Reconciler) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+	for {
+		if err := r.releaseStale(ctx); err != nil && ctx.Err() == nil {
+			log.Println("Error cancelling stale stock reservations: ", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// releaseStale cancels batches until no reservation is older than maxAge
+func (r *Reconciler) releaseStale(ctx context.Context) error {
+	for {
+		stale, err := r.reservations.StaleReservations(ctx, time.Now().Add(-r.maxAge), reconcileBatchSize)
+		if err != nil {
+			return err
+		}
+		for _, res := range stale {
+			if err := releaseReservation(ctx, r.reservations, r.inventory, res); err != nil {
+				return err
+			}
+			log.Printf("Cancelled the stock reservation of order %s, it was never saved", res.OrderID)
+		}
+		if len(stale) < reconcileBatchSize {
+			return nil
+		}
+	}
+}
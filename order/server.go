@@ -6,12 +6,16 @@ import (
 	"fmt"
 	"log"
 	"net"
+	"time"
 
 	"github.com/haroonalbar/go-grpc-graphql-microservices/account"
+	"github.com/haroonalbar/go-grpc-graphql-microservices/auth"
 	"github.com/haroonalbar/go-grpc-graphql-microservices/catalog"
 	"github.com/haroonalbar/go-grpc-graphql-microservices/order/pb"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/status"
 )
 
 type grpcServer struct {
@@ -19,78 +23,189 @@ type grpcServer struct {
 	service       Service
 	accountClient *account.Client // Dependency: Account microservice client
 	catalogClient *catalog.Client // Dependency: Catalog microservice client
+	// Identity used when calling the account and catalog services
+	credentials auth.ServiceCredentials
 }
 
-// ListenGRPC starts the gRPC server, establishing connections to Account and Catalog services.
-// It also registers the OrderService server and handles graceful cleanup of resources.
-func ListenGRPC(s Service, accountURL, catalogURL string, port int) error {
-	// Attempt to connect to the Account service
-	accountClient, err := account.NewClient(accountURL)
-	if err != nil {
-		return fmt.Errorf("failed to connect to account service: %w", err)
-	}
-	defer accountClient.Close() // Ensures cleanup if initialization fails
+// policy is what callers need for every method.
+// Ownership of orders is checked in the handlers with auth.AuthorizeAccount.
+var policy = auth.Policy{
+	pb.OrderService_PostOrder_FullMethodName:            auth.Authenticated,
+	pb.OrderService_GetOrder_FullMethodName:             auth.Authenticated,
+	pb.OrderService_GetOrdersForAccount_FullMethodName:  auth.Authenticated,
+	pb.OrderService_GetOrdersForAccounts_FullMethodName: auth.Authenticated,
+	pb.OrderService_UpdateOrderStatus_FullMethodName:    auth.RequireRole(auth.RoleAdmin),
+	pb.OrderService_AddToCart_FullMethodName:            auth.Authenticated,
+	pb.OrderService_UpdateCartItem_FullMethodName:       auth.Authenticated,
+	pb.OrderService_RemoveFromCart_FullMethodName:       auth.Authenticated,
+	pb.OrderService_GetCart_FullMethodName:              auth.Authenticated,
+	pb.OrderService_Checkout_FullMethodName:             auth.Authenticated,
+	pb.OrderService_CreateCoupon_FullMethodName:         auth.RequireRole(auth.RoleAdmin),
+	pb.OrderService_GetCoupon_FullMethodName:            auth.RequireRole(auth.RoleAdmin),
+}
 
-	// Attempt to connect to the Catalog service
-	catalogClient, err := catalog.NewClient(catalogURL)
-	if err != nil {
-		return fmt.Errorf("failed to connect to catalog service: %w", err)
+// placeOrderErrors are the errors of placing an order the caller can fix, like ordering less
+// or using another coupon, with their codes. They are returned as they are, other errors are hidden.
+var placeOrderErrors = map[error]codes.Code{
+	ErrInsufficientStock: codes.FailedPrecondition,
+	ErrEmptyCart:         codes.InvalidArgument,
+	ErrEmptyOrder:        codes.InvalidArgument,
+	ErrCouponNotFound:    codes.NotFound,
+	// the coupon exists but doesn't apply to this order
+	ErrCouponNotValidNow:   codes.FailedPrecondition,
+	ErrCouponMinSpend:      codes.FailedPrecondition,
+	ErrCouponNotApplicable: codes.FailedPrecondition,
+	ErrCouponUsageLimit:    codes.FailedPrecondition,
+}
+
+// placeOrderStatus returns the status of an error of PostOrder or Checkout,
+// nil for errors the caller can't fix, which are logged and hidden.
+// If the catalog didn't answer the stock may be taken for a while, trying again later can still work.
+func placeOrderStatus(err error) *status.Status {
+	if code, ok := placeOrderErrors[err]; ok {
+		return status.New(code, err.Error())
+	}
+	if errors.Is(err, ErrReservationUnknown) {
+		return status.New(codes.Unavailable, "could not reserve the stock, try again later")
 	}
-	defer catalogClient.Close() // Ensures cleanup if initialization fails
+	return nil
+}
 
+// ListenGRPC starts the gRPC server, it uses the Account and Catalog clients to look up accounts and products.
+// The catalog client is shared with the Inventory of the service, so it's connected by the caller.
+// a checks the access tokens forwarded by the graphql gateway. serviceToken is shared by the services,
+// it lets them call this one and this one call the account and catalog services as "order".
+func ListenGRPC(s Service, accountClient *account.Client, catalogClient *catalog.Client, a auth.Authenticator, serviceToken string, port int) error {
 	// Start listening on the specified TCP port
 	lis, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
 	if err != nil {
 		return fmt.Errorf("failed to start listener on port %d: %w", port, err)
 	}
 
-	// Create a new gRPC server
-	serv := grpc.NewServer()
+	serv := NewGRPCServer(s, accountClient, catalogClient, a, serviceToken)
+
+	// Defer server closure for graceful shutdown in production scenarios
+	defer func() {
+		serv.GracefulStop()
+	}()
+
+	// Start serving requests
+	return serv.Serve(lis)
+}
+
+// NewGRPCServer returns the gRPC server of the order service without listening, so it can serve
+// any listener, like an in-memory one. The clients are used to look up accounts and products.
+func NewGRPCServer(s Service, accountClient *account.Client, catalogClient *catalog.Client, a auth.Authenticator, serviceToken string) *grpc.Server {
+	// Create a new gRPC server, every request goes through the auth interceptor before it reaches a handler
+	serv := grpc.NewServer(
+		grpc.UnaryInterceptor(auth.UnaryServerInterceptor(a, serviceToken, policy)),
+		grpc.StreamInterceptor(auth.StreamServerInterceptor(a, serviceToken, policy)),
+	)
 
 	// Register OrderService with gRPC server
 	pb.RegisterOrderServiceServer(serv, &grpcServer{
 		service:       s,
 		accountClient: accountClient,
 		catalogClient: catalogClient,
+		credentials:   auth.ServiceCredentials{Name: "order", Token: serviceToken},
 	})
 
 	// Register reflection service for debugging (consider restricting this in production)
 	reflection.Register(serv)
-
-	// Defer server closure for graceful shutdown in production scenarios
-	defer func() {
-		serv.GracefulStop()
-	}()
-
-	// Start serving requests
-	return serv.Serve(lis)
+	return serv
 }
 
 // PostOrder processes a new order request, validating the account and products before creation
 func (s *grpcServer) PostOrder(ctx context.Context, r *pb.PostOrderRequest) (*pb.PostOrderResponse, error) {
+	// Users can only place orders for themselves
+	if err := auth.AuthorizeAccount(ctx, r.AccountId); err != nil {
+		return nil, err
+	}
+
+	// Merge the lines of the same product, so the order has one line per product
+	items := []CartItem{}
+	for _, p := range r.Products {
+		if p.Quantity == 0 {
+			return nil, status.Errorf(codes.InvalidArgument, "%v of product %s", ErrInvalidQuantity, p.ProductId)
+		}
+		if item := findCartItem(items, p.ProductId); item != nil {
+			if item.Quantity+p.Quantity < item.Quantity {
+				return nil, status.Errorf(codes.InvalidArgument, "%v of product %s", ErrInvalidQuantity, p.ProductId)
+			}
+			item.Quantity += p.Quantity
+			continue
+		}
+		items = append(items, CartItem{ProductID: p.ProductId, Quantity: p.Quantity})
+	}
+	if len(items) == 0 {
+		return nil, status.Error(codes.InvalidArgument, ErrEmptyOrder.Error())
+	}
+
+	orderedProducts, err := s.orderedProducts(ctx, r.AccountId, items)
+	if err != nil {
+		return nil, err
+	}
+	// Every requested product has to be orderable, the caller asked for all of them
+	for _, item := range items {
+		if !hasOrderedProduct(orderedProducts, item.ProductID) {
+			return nil, status.Errorf(codes.InvalidArgument, "product %s doesn't exist or was deleted", item.ProductID)
+		}
+	}
+
+	// Create the order in the order service
+	order, err := s.service.PostOrder(ctx, r.AccountId, r.IdempotencyKey, r.CouponCode, orderedProducts)
+	if st := placeOrderStatus(err); st != nil {
+		return nil, st.Err()
+	}
+	if err != nil {
+		log.Println("Error posting order: ", err)
+		return nil, errors.New("could not post order")
+	}
+
+	// Convert domain order to protobuf format
+	orderProto, err := orderToProto(order)
+	if err != nil {
+		log.Println("Error conveting CreatedAt time to byte: ", err)
+		return nil, errors.New("couldn't convert time to byte CreateAt")
+	}
+
+	// Return the created order
+	return &pb.PostOrderResponse{Order: orderProto}, nil
+}
+
+// orderedProducts verifies that the account exists and prices the items with the catalog service.
+// It's shared by PostOrder and Checkout, products that are unknown, deleted or not ordered are left out.
+// PostOrder rejects the order in that case, Checkout leaves them in the cart.
+func (s *grpcServer) orderedProducts(ctx context.Context, accountID string, items []CartItem) ([]OrderedProduct, error) {
 	// Verify account exists by calling account service
-	_, err := s.accountClient.GetAccount(ctx, r.AccountId)
+	_, err := s.accountClient.GetAccount(s.credentials.Context(ctx), accountID)
 	if err != nil {
 		log.Println("Error getting account:", err)
 		return nil, errors.New("account not found")
 	}
 
-	// Extract product IDs from the request for catalog lookup
+	// Extract product IDs from the items for catalog lookup
 	var ids []string
-	for _, p := range r.Products {
-		ids = append(ids, p.ProductId)
+	for _, item := range items {
+		ids = append(ids, item.ProductID)
 	}
 
 	// Fetch full product details from catalog service
-	products, err := s.catalogClient.GetProducts(ctx, 0, 0, ids, "")
+	products, err := s.catalogClient.GetProducts(s.credentials.Context(ctx), 0, 0, ids, "")
 	if err != nil {
 		log.Println("Error getting products:", err)
 		return nil, errors.New("products not found")
 	}
 
 	// Prepare ordered products by combining catalog details with requested quantities
+	// These details are stored with the order, so later reads don't need the catalog service
 	var orderedProducts []OrderedProduct
 	for _, p := range products {
+		// Deleted products can no longer be ordered
+		if p.Deleted {
+			continue
+		}
+
 		// Initialize product with catalog details
 		product := OrderedProduct{
 			ID:          p.ID,
@@ -100,12 +215,9 @@ func (s *grpcServer) PostOrder(ctx context.Context, r *pb.PostOrderRequest) (*pb
 			Quantity:    0,
 		}
 
-		// Find matching product from request to get quantity
-		for _, pro := range r.Products {
-			if pro.ProductId == p.ID {
-				product.Quantity = pro.Quantity
-				break
-			}
+		// Find matching item to get quantity
+		if item := findCartItem(items, p.ID); item != nil {
+			product.Quantity = item.Quantity
 		}
 
 		// Only include products that were actually ordered (quantity > 0)
@@ -113,116 +225,475 @@ func (s *grpcServer) PostOrder(ctx context.Context, r *pb.PostOrderRequest) (*pb
 			orderedProducts = append(orderedProducts, product)
 		}
 	}
+	return orderedProducts, nil
+}
 
-	// Create the order in the order service
-	order, err := s.service.PostOrder(ctx, r.AccountId, orderedProducts)
-	if err != nil {
-		log.Println("Error posting order: ", err)
-		return nil, errors.New("could not post order")
+func hasOrderedProduct(products []OrderedProduct, id string) bool {
+	for _, p := range products {
+		if p.ID == id {
+			return true
+		}
 	}
+	return false
+}
 
-	// Convert domain order to protobuf format
-	orderProto := &pb.Order{
-		Id:         order.ID,
-		AccountId:  order.AccountID,
-		TotalPrice: order.TotalPrice,
-	}
+// updateStatusErrors are the codes of the errors of UpdateOrderStatus the caller can act on
+var updateStatusErrors = map[error]codes.Code{
+	ErrNotFound:          codes.NotFound,
+	ErrInvalidTransition: codes.FailedPrecondition,
+	// the order moved on while it was updated, reading it again shows the status it's in now
+	ErrStatusConflict: codes.Aborted,
+}
+
+// errOrderNotFound is returned by GetOrder both for missing orders and for orders of other accounts,
+// so callers can't find out which order IDs exist
+var errOrderNotFound = status.Error(codes.NotFound, ErrNotFound.Error())
 
-	// Convert timestamp to binary format for protobuf
-	orderProto.CreatedAt, err = order.CreatedAt.MarshalBinary()
+// GetOrder retrieves a single order by ID.
+// The ordered products are served as they were stored when the order was placed.
+func (s *grpcServer) GetOrder(ctx context.Context, r *pb.GetOrderRequest) (*pb.GetOrderResponse, error) {
+	o, err := s.service.GetOrder(ctx, r.Id)
+	if err == ErrNotFound {
+		return nil, errOrderNotFound
+	}
 	if err != nil {
-		log.Println("Error conveting CreatedAt time to byte: ", err)
-		return nil, errors.New("couldn't convert time to byte CreateAt")
+		log.Println("Error getting order: ", err)
+		return nil, err
+	}
+	if err := auth.AuthorizeAccount(ctx, o.AccountID); err != nil {
+		// unauthenticated callers are stopped by the policy, so this is an order of another account
+		return nil, errOrderNotFound
 	}
 
-	// Add ordered products to protobuf response
-	for _, p := range order.Products {
-		orderProto.Products = append(orderProto.Products, &pb.Order_OrderProduct{
-			Id:          p.ID,
-			Name:        p.Name,
-			Description: p.Description,
-			Price:       p.Price,
-			Quantity:    p.Quantity,
-		})
+	op, err := orderToProto(o)
+	if err != nil {
+		log.Println("Error conveting time to bytes: ", err)
+		return nil, err
 	}
 
-	// Return the created order
-	return &pb.PostOrderResponse{Order: orderProto}, nil
+	return &pb.GetOrderResponse{Order: op}, nil
 }
 
-// GetOrdersForAccount retrieves orders for a specific account and enriches them with product details from the catalog service
+// GetOrdersForAccount retrieves orders for a specific account.
+// Like GetOrder it doesn't depend on the catalog service, product details come from the order snapshot.
 func (s *grpcServer) GetOrdersForAccount(ctx context.Context, r *pb.GetOrdersForAccountRequest) (*pb.GetOrdersForAccountResponse, error) {
+	if err := auth.AuthorizeAccount(ctx, r.AccountId); err != nil {
+		return nil, err
+	}
+
 	// Get all orders for the account from the order service
-	accOrders, err := s.service.GetOrdersForAccount(ctx, r.AccountId)
+	accOrders, err := s.service.GetOrdersForAccount(ctx, r.AccountId, r.After, r.Take)
 	if err != nil {
 		log.Println("Error getting account orders: ", err)
 		return nil, err
 	}
 
-	// Create a map to deduplicate product IDs across all orders
-	productIDMap := map[string]bool{}
+	// Convert domain orders to protobuf orders
+	orders := []*pb.Order{}
 	for _, o := range accOrders {
-		for _, p := range o.Products {
-			productIDMap[p.ID] = true
+		op, err := orderToProto(&o)
+		if err != nil {
+			log.Println("Error conveting time to bytes: ", err)
+			return nil, err
 		}
+		orders = append(orders, op)
 	}
 
-	// Convert the map keys (product IDs) to a slice for the catalog service call
-	productIDs := []string{}
-	for id := range productIDMap {
-		productIDs = append(productIDs, id)
+	// Return the response with all orders
+	return &pb.GetOrdersForAccountResponse{Orders: orders}, nil
+}
+
+// GetOrdersForAccounts retrieves the orders of several accounts in one call.
+// The caller needs to be allowed to see the orders of every account in the request.
+func (s *grpcServer) GetOrdersForAccounts(ctx context.Context, r *pb.GetOrdersForAccountsRequest) (*pb.GetOrdersForAccountsResponse, error) {
+	for _, id := range r.AccountIds {
+		if err := auth.AuthorizeAccount(ctx, id); err != nil {
+			return nil, err
+		}
 	}
 
-	// Fetch product details from the catalog service
-	products, err := s.catalogClient.GetProducts(ctx, 1, 0, productIDs, "")
+	accOrders, err := s.service.GetOrdersForAccounts(ctx, r.AccountIds)
 	if err != nil {
-		log.Println("Error getting products with ids from catalog")
+		log.Println("Error getting orders for accounts: ", err)
 		return nil, err
 	}
 
-	// Convert domain orders to protobuf orders and enrich with product details
 	orders := []*pb.Order{}
 	for _, o := range accOrders {
-		// Create new protobuf order
-		op := &pb.Order{
-			Id:         o.ID,
-			AccountId:  o.AccountID,
-			TotalPrice: o.TotalPrice,
+		op, err := orderToProto(&o)
+		if err != nil {
+			log.Println("Error conveting time to bytes: ", err)
+			return nil, err
 		}
+		orders = append(orders, op)
+	}
 
-		// Convert time.Time to binary for protobuf
-		op.CreatedAt, err = o.CreatedAt.MarshalBinary()
+	return &pb.GetOrdersForAccountsResponse{Orders: orders}, nil
+}
+
+// UpdateOrderStatus moves an order along its lifecycle, e.g. from pending to paid
+func (s *grpcServer) UpdateOrderStatus(ctx context.Context, r *pb.UpdateOrderStatusRequest) (*pb.UpdateOrderStatusResponse, error) {
+	newStatus, err := ParseStatus(r.Status)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	o, err := s.service.UpdateOrderStatus(ctx, r.Id, newStatus)
+	if code, ok := updateStatusErrors[err]; ok {
+		return nil, status.Error(code, err.Error())
+	}
+	if err != nil {
+		log.Println("Error updating order status: ", err)
+		return nil, err
+	}
+
+	op, err := orderToProto(o)
+	if err != nil {
+		log.Println("Error conveting time to bytes: ", err)
+		return nil, err
+	}
+
+	return &pb.UpdateOrderStatusResponse{Order: op}, nil
+}
+
+// WatchOrders streams order events to the caller until it goes away.
+// Callers can watch their own account, watching all accounts is for admins.
+func (s *grpcServer) WatchOrders(r *pb.WatchOrdersRequest, stream pb.OrderService_WatchOrdersServer) error {
+	ctx := stream.Context()
+	if r.AccountId == "" {
+		if !auth.FromContext(ctx).HasRole(auth.RoleAdmin) {
+			return auth.ErrPermissionDenied
+		}
+	} else if err := auth.AuthorizeAccount(ctx, r.AccountId); err != nil {
+		return err
+	}
+
+	events, err := s.service.WatchOrders(ctx, r.AccountId)
+	if err != nil {
+		log.Println("Error watching orders: ", err)
+		return err
+	}
+
+	for e := range events {
+		op, err := orderToProto(&e.Order)
 		if err != nil {
 			log.Println("Error conveting time to bytes: ", err)
+			return err
+		}
+		if err := stream.Send(&pb.OrderEvent{Type: string(e.Type), Order: op}); err != nil {
+			return err
+		}
+	}
+	if ctx.Err() == nil {
+		// the service disconnected the caller, it has missed events
+		return status.Error(codes.ResourceExhausted, ErrWatcherTooSlow.Error())
+	}
+	return nil
+}
+
+// AddToCart adds a product to the cart of the account, the product has to be in the catalog
+func (s *grpcServer) AddToCart(ctx context.Context, r *pb.AddToCartRequest) (*pb.AddToCartResponse, error) {
+	if err := auth.AuthorizeAccount(ctx, r.AccountId); err != nil {
+		return nil, err
+	}
+
+	// Only products that can be ordered go into the cart
+	p, err := s.catalogClient.GetProduct(s.credentials.Context(ctx), r.ProductId)
+	if err != nil || p.Deleted {
+		log.Println("Error getting product: ", err)
+		return nil, errors.New("product not found")
+	}
+
+	items, err := s.service.AddToCart(ctx, r.AccountId, r.ProductId, r.Quantity)
+	if err != nil {
+		log.Println("Error adding to cart: ", err)
+		return nil, err
+	}
+
+	cart, err := s.priceCart(ctx, r.AccountId, items)
+	if err != nil {
+		return nil, err
+	}
+	return &pb.AddToCartResponse{Cart: cart}, nil
+}
+
+// UpdateCartItem replaces the quantity of a product in the cart, 0 removes it
+func (s *grpcServer) UpdateCartItem(ctx context.Context, r *pb.UpdateCartItemRequest) (*pb.UpdateCartItemResponse, error) {
+	if err := auth.AuthorizeAccount(ctx, r.AccountId); err != nil {
+		return nil, err
+	}
+
+	items, err := s.service.UpdateCartItem(ctx, r.AccountId, r.ProductId, r.Quantity)
+	if err != nil {
+		log.Println("Error updating cart item: ", err)
+		return nil, err
+	}
+
+	cart, err := s.priceCart(ctx, r.AccountId, items)
+	if err != nil {
+		return nil, err
+	}
+	return &pb.UpdateCartItemResponse{Cart: cart}, nil
+}
+
+func (s *grpcServer) RemoveFromCart(ctx context.Context, r *pb.RemoveFromCartRequest) (*pb.RemoveFromCartResponse, error) {
+	if err := auth.AuthorizeAccount(ctx, r.AccountId); err != nil {
+		return nil, err
+	}
+
+	items, err := s.service.RemoveFromCart(ctx, r.AccountId, r.ProductId)
+	if err != nil {
+		log.Println("Error removing from cart: ", err)
+		return nil, err
+	}
+
+	cart, err := s.priceCart(ctx, r.AccountId, items)
+	if err != nil {
+		return nil, err
+	}
+	return &pb.RemoveFromCartResponse{Cart: cart}, nil
+}
+
+// GetCart returns the cart of the account at the current catalog prices
+func (s *grpcServer) GetCart(ctx context.Context, r *pb.GetCartRequest) (*pb.GetCartResponse, error) {
+	if err := auth.AuthorizeAccount(ctx, r.AccountId); err != nil {
+		return nil, err
+	}
+
+	items, err := s.service.GetCart(ctx, r.AccountId)
+	if err != nil {
+		log.Println("Error getting cart: ", err)
+		return nil, err
+	}
+
+	cart, err := s.priceCart(ctx, r.AccountId, items)
+	if err != nil {
+		return nil, err
+	}
+	return &pb.GetCartResponse{Cart: cart}, nil
+}
+
+// Checkout turns the cart into an order. The products are priced the same way as in PostOrder,
+// so the order gets the current prices and reserves the stock like any other order.
+func (s *grpcServer) Checkout(ctx context.Context, r *pb.CheckoutRequest) (*pb.CheckoutResponse, error) {
+	if err := auth.AuthorizeAccount(ctx, r.AccountId); err != nil {
+		return nil, err
+	}
+
+	items, err := s.service.GetCart(ctx, r.AccountId)
+	if err != nil {
+		log.Println("Error getting cart: ", err)
+		return nil, err
+	}
+	var orderedProducts []OrderedProduct
+	if len(items) > 0 {
+		orderedProducts, err = s.orderedProducts(ctx, r.AccountId, items)
+		if err != nil {
 			return nil, err
 		}
+	}
 
-		// Enrich each ordered product with details from catalog
-		for _, product := range o.Products {
-			// Find matching product from catalog and update details
-			for _, p := range products {
-				if p.ID == product.ID {
-					product.Name = p.Name
-					product.Description = p.Description
-					product.Price = p.Price
-					break
-				}
-			}
+	order, err := s.service.Checkout(ctx, r.AccountId, r.IdempotencyKey, r.CouponCode, orderedProducts)
+	// the caller can change the cart and try again
+	if st := placeOrderStatus(err); st != nil {
+		return nil, st.Err()
+	}
+	if err != nil {
+		log.Println("Error checking out cart: ", err)
+		return nil, errors.New("could not post order")
+	}
+
+	orderProto, err := orderToProto(order)
+	if err != nil {
+		log.Println("Error conveting CreatedAt time to byte: ", err)
+		return nil, errors.New("couldn't convert time to byte CreateAt")
+	}
+	return &pb.CheckoutResponse{Order: orderProto}, nil
+}
+
+// CreateCoupon adds a coupon, its code is stored upper case
+func (s *grpcServer) CreateCoupon(ctx context.Context, r *pb.CreateCouponRequest) (*pb.CreateCouponResponse, error) {
+	if r.Coupon == nil {
+		return nil, ErrInvalidCoupon
+	}
+	c, err := couponFromProto(r.Coupon)
+	if err != nil {
+		return nil, err
+	}
+
+	created, err := s.service.CreateCoupon(ctx, *c)
+	if err != nil {
+		log.Println("Error creating coupon: ", err)
+		return nil, err
+	}
+
+	cp, err := couponToProto(created)
+	if err != nil {
+		log.Println("Error conveting time to bytes: ", err)
+		return nil, err
+	}
+	return &pb.CreateCouponResponse{Coupon: cp}, nil
+}
+
+func (s *grpcServer) GetCoupon(ctx context.Context, r *pb.GetCouponRequest) (*pb.GetCouponResponse, error) {
+	c, err := s.service.GetCoupon(ctx, r.Code)
+	if err != nil {
+		log.Println("Error getting coupon: ", err)
+		return nil, err
+	}
+
+	cp, err := couponToProto(c)
+	if err != nil {
+		log.Println("Error conveting time to bytes: ", err)
+		return nil, err
+	}
+	return &pb.GetCouponResponse{Coupon: cp}, nil
+}
+
+// priceCart looks up the products of the cart in the catalog service.
+// Products that were deleted, or are missing from the catalog, are marked unavailable and not counted in the total.
+func (s *grpcServer) priceCart(ctx context.Context, accountID string, items []CartItem) (*pb.Cart, error) {
+	cart := &pb.Cart{AccountId: accountID, Items: []*pb.CartItem{}}
+	if len(items) == 0 {
+		return cart, nil
+	}
+
+	var ids []string
+	for _, item := range items {
+		ids = append(ids, item.ProductID)
+	}
+	products, err := s.catalogClient.GetProducts(s.credentials.Context(ctx), 0, 0, ids, "")
+	if err != nil {
+		log.Println("Error getting products:", err)
+		return nil, errors.New("products not found")
+	}
+	byID := map[string]catalog.Product{}
+	for _, p := range products {
+		byID[p.ID] = p
+	}
 
-			// Add enriched product to protobuf order
-			op.Products = append(op.Products, &pb.Order_OrderProduct{
-				Id:          product.ID,
-				Name:        product.Name,
-				Description: product.Description,
-				Price:       product.Price,
-				Quantity:    product.Quantity,
-			})
+	for _, item := range items {
+		ci := &pb.CartItem{ProductId: item.ProductID, Quantity: item.Quantity}
+		if p, ok := byID[item.ProductID]; ok {
+			ci.Name = p.Name
+			ci.Description = p.Description
+			ci.Price = p.Price
+			ci.Available = !p.Deleted
+			ci.AvailableQuantity = p.Stock
+		}
+		if ci.Available {
+			cart.TotalPrice += ci.Price * float64(ci.Quantity)
 		}
+		cart.Items = append(cart.Items, ci)
+	}
+	return cart, nil
+}
 
-		// Add the completed order (with all its enriched products) to the final orders slice
-		orders = append(orders, op)
+// orderToProto converts a domain order to it's protobuf representation
+func orderToProto(o *Order) (*pb.Order, error) {
+	op := &pb.Order{
+		Id:         o.ID,
+		AccountId:  o.AccountID,
+		Subtotal:   o.Subtotal,
+		TotalPrice: o.TotalPrice,
+		Status:     string(o.Status),
+		CouponCode: o.CouponCode,
 	}
 
-	// Return the response with all enriched orders
-	return &pb.GetOrdersForAccountResponse{Orders: orders}, nil
+	// Convert time.Time to binary for protobuf
+	createdAt, err := o.CreatedAt.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	op.CreatedAt = createdAt
+
+	for _, p := range o.Products {
+		op.Products = append(op.Products, &pb.Order_OrderProduct{
+			Id:          p.ID,
+			Name:        p.Name,
+			Description: p.Description,
+			Price:       p.Price,
+			Quantity:    p.Quantity,
+			Discount:    p.Discount,
+		})
+	}
+	for _, d := range o.Discounts {
+		op.Discounts = append(op.Discounts, &pb.Discount{
+			CouponCode:  d.CouponCode,
+			ProductId:   d.ProductID,
+			Description: d.Description,
+			Amount:      d.Amount,
+		})
+	}
+	return op, nil
+}
+
+// couponToProto converts a coupon to protobuf, the zero time of an open validity window is left empty
+func couponToProto(c *Coupon) (*pb.Coupon, error) {
+	cp := &pb.Coupon{
+		Code:              c.Code,
+		Type:              string(c.Type),
+		Value:             c.Value,
+		ProductId:         c.ProductID,
+		BuyQuantity:       c.BuyQuantity,
+		GetQuantity:       c.GetQuantity,
+		MinSpend:          c.MinSpend,
+		MaxUsesPerAccount: c.MaxUsesPerAccount,
+	}
+	var err error
+	if cp.ValidFrom, err = timeToBytes(c.ValidFrom); err != nil {
+		return nil, err
+	}
+	if cp.ValidUntil, err = timeToBytes(c.ValidUntil); err != nil {
+		return nil, err
+	}
+	if cp.CreatedAt, err = timeToBytes(c.CreatedAt); err != nil {
+		return nil, err
+	}
+	return cp, nil
+}
+
+// couponFromProto is the inverse of couponToProto, it fails with ErrInvalidCoupon for unknown types
+func couponFromProto(cp *pb.Coupon) (*Coupon, error) {
+	t, err := ParseCouponType(cp.Type)
+	if err != nil {
+		return nil, err
+	}
+	c := &Coupon{
+		Code:              cp.Code,
+		Type:              t,
+		Value:             cp.Value,
+		ProductID:         cp.ProductId,
+		BuyQuantity:       cp.BuyQuantity,
+		GetQuantity:       cp.GetQuantity,
+		MinSpend:          cp.MinSpend,
+		MaxUsesPerAccount: cp.MaxUsesPerAccount,
+	}
+	if c.ValidFrom, err = timeFromBytes(cp.ValidFrom); err != nil {
+		return nil, err
+	}
+	if c.ValidUntil, err = timeFromBytes(cp.ValidUntil); err != nil {
+		return nil, err
+	}
+	if c.CreatedAt, err = timeFromBytes(cp.CreatedAt); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// timeToBytes marshals t like the times of orders, the zero time is sent as no bytes
+func timeToBytes(t time.Time) ([]byte, error) {
+	if t.IsZero() {
+		return nil, nil
+	}
+	return t.MarshalBinary()
+}
+
+func timeFromBytes(b []byte) (time.Time, error) {
+	var t time.Time
+	if len(b) == 0 {
+		return t, nil
+	}
+	err := t.UnmarshalBinary(b)
+	return t, err
 }
@@ -3,17 +3,32 @@ package order
 
 import (
 	"context"
+	"errors"
+	"log"
 	"time"
 
 	"github.com/segmentio/ksuid"
 )
 
+// ErrEmptyOrder is returned by PostOrder for an order without products
+var ErrEmptyOrder = errors.New("order has no products")
+
 type Order struct {
-	ID         string           `json:"id"`
-	CreatedAt  time.Time        `json:"created_at"`
-	AccountID  string           `json:"account_id"`
+	ID        string    `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	AccountID string    `json:"account_id"`
+	// Subtotal is the price of the products before discounts
+	Subtotal float64 `json:"subtotal"`
+	// TotalPrice is the Subtotal minus the Discounts
 	TotalPrice float64          `json:"tatal_price"`
+	Status     Status           `json:"status"`
 	Products   []OrderedProduct `json:"products"`
+	// IdempotencyKey is an optional client supplied key, unique per account
+	IdempotencyKey string `json:"idempotency_key,omitempty"`
+	// CouponCode is the coupon applied to the order, if any
+	CouponCode string `json:"coupon_code,omitempty"`
+	// Discounts is the breakdown of what the coupon took off, per line and for the whole order
+	Discounts []Discount `json:"discounts,omitempty"`
 }
 
 type OrderedProduct struct {
@@ -22,39 +37,198 @@ type OrderedProduct struct {
 	Description string  `json:"description"`
 	Price       float64 `json:"price"`
 	Quantity    uint32  `json:"quantity"`
+	// Discount is the sum of the discounts of this line, discounts of the whole order aren't split up
+	Discount float64 `json:"discount,omitempty"`
 }
 
 type Service interface {
-	PostOrder(ctx context.Context, accountID string, products []OrderedProduct) (*Order, error)
-	GetOrdersForAccount(ctx context.Context, accountID string) ([]Order, error)
+	// PostOrder places the order, couponCode is optional
+	PostOrder(ctx context.Context, accountID, idempotencyKey, couponCode string, products []OrderedProduct) (*Order, error)
+	GetOrder(ctx context.Context, id string) (*Order, error)
+	// GetOrdersForAccount lists the orders of the account newest first.
+	// after is the ID of the last order of the previous page, take 0 returns all orders.
+	GetOrdersForAccount(ctx context.Context, accountID string, after string, take uint64) ([]Order, error)
+	GetOrdersForAccounts(ctx context.Context, accountIDs []string) ([]Order, error)
+	UpdateOrderStatus(ctx context.Context, id string, status Status) (*Order, error)
+	// WatchOrders streams the events of the account's orders, or of all orders if accountID is empty.
+	// The events come from the event bus, so they arrive once the relay published them.
+	// The channel is closed once ctx is done, or earlier if the caller doesn't keep up with the events.
+	WatchOrders(ctx context.Context, accountID string) (<-chan OrderEvent, error)
+	// The cart methods return the items of the cart after the change, see cart.go
+	AddToCart(ctx context.Context, accountID, productID string, quantity uint32) ([]CartItem, error)
+	UpdateCartItem(ctx context.Context, accountID, productID string, quantity uint32) ([]CartItem, error)
+	RemoveFromCart(ctx context.Context, accountID, productID string) ([]CartItem, error)
+	GetCart(ctx context.Context, accountID string) ([]CartItem, error)
+	Checkout(ctx context.Context, accountID, idempotencyKey, couponCode string, products []OrderedProduct) (*Order, error)
+	// CreateCoupon and GetCoupon manage the coupons orders can use, see coupon.go
+	CreateCoupon(ctx context.Context, c Coupon) (*Coupon, error)
+	GetCoupon(ctx context.Context, code string) (*Coupon, error)
 }
 
 type orderService struct {
 	repository Repository
+	inventory  Inventory
+	events     *broadcaster
+}
+
+// NewService returns the order service. It subscribes to the bus to feed the watchers of WatchOrders,
+// the relay of the repository's outbox has to publish to the same bus.
+func NewService(r Repository, inventory Inventory, bus EventBus) (Service, error) {
+	s := &orderService{r, inventory, newBroadcaster()}
+	// no name, every instance of the service needs every event for its own watchers
+	if err := bus.Subscribe(context.Background(), "", s.handleEvent); err != nil {
+		return nil, err
+	}
+	return s, nil
 }
 
-func NewService(r Repository) Service {
-	return &orderService{r}
+// PostOrder creates a new order.
+// If idempotencyKey is set and the account already placed an order with it,
+// the existing order is returned instead of creating a duplicate.
+// The stock of the products is reserved before the order is saved, ErrInsufficientStock
+// is returned if there isn't enough of a product. The reservation is recorded first,
+// so its stock is given back even if the process dies before the order is saved, see Reservation.
+// With a couponCode the coupon is applied to the price, one of the coupon errors is returned if it doesn't apply.
+func (s orderService) PostOrder(ctx context.Context, accountID, idempotencyKey, couponCode string, products []OrderedProduct) (*Order, error) {
+	o, _, err := s.placeOrder(ctx, accountID, idempotencyKey, couponCode, products)
+	return o, err
 }
 
-func (s orderService) PostOrder(ctx context.Context, accountID string, products []OrderedProduct) (*Order, error) {
+// placeOrder is PostOrder, it also reports if the order was created by this call.
+// It wasn't if the idempotency key returned an order placed before.
+func (s orderService) placeOrder(ctx context.Context, accountID, idempotencyKey, couponCode string, products []OrderedProduct) (*Order, bool, error) {
+	if len(products) == 0 {
+		return nil, false, ErrEmptyOrder
+	}
+	if idempotencyKey != "" {
+		existing, err := s.repository.GetOrderByIdempotencyKey(ctx, accountID, idempotencyKey)
+		if err == nil {
+			return existing, false, nil
+		}
+		if err != ErrNotFound {
+			return nil, false, err
+		}
+	}
+
 	o := Order{
-		ID:        ksuid.New().String(),
-		CreatedAt: time.Now().UTC(),
-		AccountID: accountID,
-		Products:  products,
+		ID:             ksuid.New().String(),
+		CreatedAt:      time.Now().UTC(),
+		AccountID:      accountID,
+		Status:         StatusPending,
+		Products:       products,
+		IdempotencyKey: idempotencyKey,
 	}
 	for _, p := range products {
 		// by default it would be 0.0
-		o.TotalPrice += p.Price * float64(p.Quantity)
+		o.Subtotal += p.Price * float64(p.Quantity)
+	}
+	o.Subtotal = roundCents(o.Subtotal)
+	o.TotalPrice = o.Subtotal
+	if couponCode != "" {
+		coupon, err := s.repository.GetCoupon(ctx, normalizeCouponCode(couponCode))
+		if err != nil {
+			return nil, false, err
+		}
+		if err := o.applyCoupon(*coupon); err != nil {
+			return nil, false, err
+		}
 	}
+
+	reservation := Reservation{OrderID: o.ID, Products: o.Products, CreatedAt: o.CreatedAt}
+	if err := s.repository.PutReservation(ctx, reservation); err != nil {
+		return nil, false, err
+	}
+	if err := s.inventory.ReserveStock(ctx, o.ID, o.Products); err != nil {
+		if errors.Is(err, ErrReservationUnknown) {
+			// the stock may be reserved or be about to, giving it back now could come before the reservation.
+			// The Reconciler cancels it once the inventory has answered for sure.
+			return nil, false, err
+		}
+		// nothing was reserved, only the record has to go
+		if _, err := s.repository.DeleteReservation(context.WithoutCancel(ctx), o.ID); err != nil {
+			log.Println("Error deleting stock reservation: ", err)
+		}
+		return nil, false, err
+	}
+	// the usage limit of the coupon is checked while saving, so concurrent orders can't exceed it.
+	// Saving deletes the reservation, the order owns the stock from then on.
 	err := s.repository.PutOrder(ctx, o)
+	if err != nil {
+		// the order wasn't placed, give the stock back
+		s.cancelReservation(ctx, reservation)
+	} else if err := s.inventory.ConfirmReservation(context.WithoutCancel(ctx), o.ID, o.Products); err != nil {
+		// the stock is taken either way, the inventory only keeps a record it doesn't need anymore
+		log.Println("Error confirming stock reservation: ", err)
+	}
+	if err == ErrDuplicateIdempotencyKey {
+		// A concurrent request with the same key won the race, return its order
+		existing, err := s.repository.GetOrderByIdempotencyKey(ctx, accountID, idempotencyKey)
+		return existing, false, err
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return &o, true, nil
+}
+
+func (s orderService) GetOrder(ctx context.Context, id string) (*Order, error) {
+	return s.repository.GetOrder(ctx, id)
+}
+
+func (s orderService) GetOrdersForAccount(ctx context.Context, accountID string, after string, take uint64) ([]Order, error) {
+	if take > 100 {
+		take = 100
+	}
+	return s.repository.GetOrderForAccount(ctx, accountID, after, take)
+}
+
+func (s orderService) GetOrdersForAccounts(ctx context.Context, accountIDs []string) ([]Order, error) {
+	if len(accountIDs) == 0 {
+		return []Order{}, nil
+	}
+	return s.repository.GetOrdersForAccounts(ctx, accountIDs)
+}
+
+// UpdateOrderStatus moves the order to the given status if the order state machine allows it.
+// Cancelling an order releases the stock reserved for it.
+func (s orderService) UpdateOrderStatus(ctx context.Context, id string, status Status) (*Order, error) {
+	o, err := s.repository.GetOrder(ctx, id)
 	if err != nil {
 		return nil, err
 	}
-	return &o, nil
+	if !o.Status.CanTransitionTo(status) {
+		return nil, ErrInvalidTransition
+	}
+	err = s.repository.UpdateOrderStatus(ctx, StatusTransition{
+		OrderID:   o.ID,
+		From:      o.Status,
+		To:        status,
+		CreatedAt: time.Now().UTC(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	o.Status = status
+	if status == StatusCancelled {
+		// the transition is conditional on the previous status, so this only happens once per order
+		s.releaseStock(ctx, o.Products)
+	}
+	return o, nil
+}
+
+// releaseStock gives back the stock of the products. It also runs if the request was cancelled,
+// a failure is only logged since the order change it belongs to already happened.
+func (s orderService) releaseStock(ctx context.Context, products []OrderedProduct) {
+	if err := s.inventory.ReleaseStock(context.WithoutCancel(ctx), products); err != nil {
+		log.Println("Error releasing stock: ", err)
+	}
 }
 
-func (s orderService) GetOrdersForAccount(ctx context.Context, accountID string) ([]Order, error) {
-	return s.repository.GetOrderForAccount(ctx, accountID)
+func (s orderService) WatchOrders(ctx context.Context, accountID string) (<-chan OrderEvent, error) {
+	events, cancel := s.events.subscribe(accountID)
+	go func() {
+		<-ctx.Done()
+		cancel()
+	}()
+	return events, nil
 }
@@ -0,0 +1,363 @@
+package order
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/segmentio/ksuid"
+)
+
+// testInventory keeps the stock in memory and records reservations like the catalog does.
+// reserveErr makes ReserveStock fail, after taking the stock if takeOnError is set, like a call to the
+// catalog that hits its deadline after the catalog committed, and without taking it otherwise.
+type testInventory struct {
+	mu           sync.Mutex
+	stock        map[string]uint64
+	reservations map[string][]OrderedProduct
+	reserveErr   error
+	takeOnError  bool
+}
+
+func (i *testInventory) ReserveStock(ctx context.Context, reservationID string, products []OrderedProduct) error {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	if i.reserveErr != nil && !i.takeOnError {
+		return i.reserveErr
+	}
+	if _, ok := i.reservations[reservationID]; ok {
+		return i.reserveErr
+	}
+	for _, p := range products {
+		if i.stock[p.ID] < uint64(p.Quantity) {
+			return ErrInsufficientStock
+		}
+	}
+	for _, p := range products {
+		i.stock[p.ID] -= uint64(p.Quantity)
+	}
+	if i.reservations == nil {
+		i.reservations = map[string][]OrderedProduct{}
+	}
+	i.reservations[reservationID] = products
+	return i.reserveErr
+}
+
+func (i *testInventory) CancelReservation(ctx context.Context, reservationID string, products []OrderedProduct) error {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	// only what the reservation took is given back
+	for _, p := range i.reservations[reservationID] {
+		i.stock[p.ID] += uint64(p.Quantity)
+	}
+	delete(i.reservations, reservationID)
+	return nil
+}
+
+func (i *testInventory) ConfirmReservation(ctx context.Context, reservationID string, products []OrderedProduct) error {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	delete(i.reservations, reservationID)
+	return nil
+}
+
+func (i *testInventory) ReleaseStock(ctx context.Context, products []OrderedProduct) error {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	for _, p := range products {
+		i.stock[p.ID] += uint64(p.Quantity)
+	}
+	return nil
+}
+
+func (i *testInventory) stockOf(id string) uint64 {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return i.stock[id]
+}
+
+func newTestService(t *testing.T, r Repository, inventory Inventory, bus EventBus) Service {
+	t.Helper()
+	s, err := NewService(r, inventory, bus)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestPostOrderStock(t *testing.T) {
+	ctx := context.Background()
+	product := OrderedProduct{ID: ksuid.New().String(), Name: "Mug", Description: "A mug", Price: 5, Quantity: 2}
+
+	// assertNoReservations checks that no stock is left reserved for an order that wasn't saved
+	assertNoReservations := func(t *testing.T, r Repository) {
+		t.Helper()
+		left, err := r.StaleReservations(ctx, time.Now().Add(time.Hour), 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(left) != 0 {
+			t.Errorf("%d reservations left, want none", len(left))
+		}
+	}
+
+	t.Run("placed", func(t *testing.T) {
+		r := NewMemoryRepository()
+		inventory := &testInventory{stock: map[string]uint64{product.ID: 5}}
+		s := newTestService(t, r, inventory, NewMemoryEventBus())
+
+		if _, err := s.PostOrder(ctx, ksuid.New().String(), "", "", []OrderedProduct{product}); err != nil {
+			t.Fatal(err)
+		}
+		if got := inventory.stockOf(product.ID); got != 3 {
+			t.Errorf("stock = %d, want 3", got)
+		}
+		if len(inventory.reservations) != 0 {
+			t.Errorf("inventory reservations = %v, want the saved order's confirmed", inventory.reservations)
+		}
+		assertNoReservations(t, r)
+	})
+
+	t.Run("reserve fails", func(t *testing.T) {
+		r := NewMemoryRepository()
+		// the catalog answered with an error, it didn't take any stock
+		reserveErr := errors.New("product not found")
+		inventory := &testInventory{stock: map[string]uint64{product.ID: 5}, reserveErr: reserveErr}
+		s := newTestService(t, r, inventory, NewMemoryEventBus())
+
+		accountID := ksuid.New().String()
+		if _, err := s.PostOrder(ctx, accountID, "", "", []OrderedProduct{product}); err != reserveErr {
+			t.Fatalf("PostOrder: %v, want %v", err, reserveErr)
+		}
+		if got := inventory.stockOf(product.ID); got != 5 {
+			t.Errorf("stock = %d, want 5, nothing was taken", got)
+		}
+		if orders, err := r.GetOrderForAccount(ctx, accountID, "", 0); err != nil || len(orders) != 0 {
+			t.Errorf("orders = %+v, %v, want none", orders, err)
+		}
+		assertNoReservations(t, r)
+	})
+
+	// without an answer the stock may or may not be taken, the Reconciler cancels the reservation later
+	for _, taken := range []bool{true, false} {
+		t.Run(fmt.Sprintf("reserve outcome unknown, taken %v", taken), func(t *testing.T) {
+			r := NewMemoryRepository()
+			reserveErr := fmt.Errorf("%w: %v", ErrReservationUnknown, context.DeadlineExceeded)
+			inventory := &testInventory{stock: map[string]uint64{product.ID: 5}, reserveErr: reserveErr, takeOnError: taken}
+			s := newTestService(t, r, inventory, NewMemoryEventBus())
+
+			accountID := ksuid.New().String()
+			if _, err := s.PostOrder(ctx, accountID, "", "", []OrderedProduct{product}); !errors.Is(err, ErrReservationUnknown) {
+				t.Fatalf("PostOrder: %v, want ErrReservationUnknown", err)
+			}
+			if orders, err := r.GetOrderForAccount(ctx, accountID, "", 0); err != nil || len(orders) != 0 {
+				t.Errorf("orders = %+v, %v, want none", orders, err)
+			}
+			if err := NewReconciler(r, inventory, 0, time.Minute).releaseStale(ctx); err != nil {
+				t.Fatal(err)
+			}
+			if got := inventory.stockOf(product.ID); got != 5 {
+				t.Errorf("stock = %d, want 5", got)
+			}
+			assertNoReservations(t, r)
+		})
+	}
+
+	t.Run("insufficient stock", func(t *testing.T) {
+		r := NewMemoryRepository()
+		inventory := &testInventory{stock: map[string]uint64{product.ID: 1}}
+		s := newTestService(t, r, inventory, NewMemoryEventBus())
+
+		if _, err := s.PostOrder(ctx, ksuid.New().String(), "", "", []OrderedProduct{product}); err != ErrInsufficientStock {
+			t.Fatalf("PostOrder: %v, want ErrInsufficientStock", err)
+		}
+		// nothing was reserved, so nothing is given back either
+		if got := inventory.stockOf(product.ID); got != 1 {
+			t.Errorf("stock = %d, want 1", got)
+		}
+		assertNoReservations(t, r)
+	})
+
+	t.Run("save fails", func(t *testing.T) {
+		r := NewMemoryRepository()
+		inventory := &testInventory{stock: map[string]uint64{product.ID: 5}}
+		s := newTestService(t, r, inventory, NewMemoryEventBus())
+
+		coupon := Coupon{Code: "ONCE", Type: CouponPercentage, Value: 10, MaxUsesPerAccount: 1, CreatedAt: time.Now()}
+		if err := r.PutCoupon(ctx, coupon); err != nil {
+			t.Fatal(err)
+		}
+		accountID := ksuid.New().String()
+		if _, err := s.PostOrder(ctx, accountID, "", coupon.Code, []OrderedProduct{product}); err != nil {
+			t.Fatal(err)
+		}
+		// the usage limit is only checked by PutOrder, after the stock was reserved
+		if _, err := s.PostOrder(ctx, accountID, "", coupon.Code, []OrderedProduct{product}); err != ErrCouponUsageLimit {
+			t.Fatalf("PostOrder: %v, want ErrCouponUsageLimit", err)
+		}
+		if got := inventory.stockOf(product.ID); got != 3 {
+			t.Errorf("stock = %d, want 3, only the placed order holds stock", got)
+		}
+		assertNoReservations(t, r)
+	})
+
+	t.Run("reconciler", func(t *testing.T) {
+		r := NewMemoryRepository()
+		inventory := &testInventory{stock: map[string]uint64{product.ID: 5}}
+
+		// the process died after reserving, before the order was saved
+		o := newTestOrder(ksuid.New().String(), "")
+		o.Products = []OrderedProduct{product}
+		o.CreatedAt = o.CreatedAt.Add(-time.Hour)
+		if err := r.PutReservation(ctx, newReservation(o)); err != nil {
+			t.Fatal(err)
+		}
+		if err := inventory.ReserveStock(ctx, o.ID, o.Products); err != nil {
+			t.Fatal(err)
+		}
+		// the process died before it reserved anything
+		unreserved := newTestOrder(ksuid.New().String(), "")
+		unreserved.Products = []OrderedProduct{product}
+		unreserved.CreatedAt = unreserved.CreatedAt.Add(-time.Hour)
+		if err := r.PutReservation(ctx, newReservation(unreserved)); err != nil {
+			t.Fatal(err)
+		}
+		// a fresh reservation belongs to an order that is still being placed
+		fresh := newTestOrder(ksuid.New().String(), "")
+		if err := r.PutReservation(ctx, newReservation(fresh)); err != nil {
+			t.Fatal(err)
+		}
+
+		reconciler := NewReconciler(r, inventory, time.Minute, time.Minute)
+		for i := 0; i < 2; i++ {
+			if err := reconciler.releaseStale(ctx); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if got := inventory.stockOf(product.ID); got != 5 {
+			t.Errorf("stock = %d, want 5, what was taken is back once and nothing more", got)
+		}
+		if err := r.PutOrder(ctx, o); err != ErrReservationExpired {
+			t.Errorf("PutOrder after the reservation was cancelled: %v, want ErrReservationExpired", err)
+		}
+		if err := r.PutOrder(ctx, fresh); err != nil {
+			t.Errorf("PutOrder with a fresh reservation: %v", err)
+		}
+	})
+}
+
+func TestCheckoutReplay(t *testing.T) {
+	ctx := context.Background()
+	r := NewMemoryRepository()
+	product := OrderedProduct{ID: ksuid.New().String(), Name: "Mug", Description: "A mug", Price: 5, Quantity: 1}
+	inventory := &testInventory{stock: map[string]uint64{product.ID: 10}}
+	s := newTestService(t, r, inventory, NewMemoryEventBus())
+	accountID := ksuid.New().String()
+
+	if _, err := s.AddToCart(ctx, accountID, product.ID, 1); err != nil {
+		t.Fatal(err)
+	}
+	first, err := s.Checkout(ctx, accountID, "checkout-1", "", []OrderedProduct{product})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the product is added again after the checkout, a retry of it must not take it out of the cart
+	if _, err := s.AddToCart(ctx, accountID, product.ID, 1); err != nil {
+		t.Fatal(err)
+	}
+	replayed, err := s.Checkout(ctx, accountID, "checkout-1", "", []OrderedProduct{product})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if replayed.ID != first.ID {
+		t.Errorf("replayed order = %s, want %s", replayed.ID, first.ID)
+	}
+	items, err := s.GetCart(ctx, accountID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 1 || items[0].ProductID != product.ID {
+		t.Errorf("cart after the replay = %+v, want the product added after the checkout", items)
+	}
+	if got := inventory.stockOf(product.ID); got != 9 {
+		t.Errorf("stock = %d, want 9", got)
+	}
+}
+
+func TestWatchOrders(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	r := NewMemoryRepository()
+	product := OrderedProduct{ID: ksuid.New().String(), Name: "Mug", Description: "A mug", Price: 5, Quantity: 1}
+	inventory := &testInventory{stock: map[string]uint64{product.ID: 100}}
+	bus := NewMemoryEventBus()
+	// two instances of the service share the repository and the bus, like replicas
+	placing := newTestService(t, r, inventory, bus)
+	watching := newTestService(t, r, inventory, bus)
+	relay := NewRelay(r, bus, time.Minute)
+
+	accountID := ksuid.New().String()
+	events, err := watching.WatchOrders(ctx, accountID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	o, err := placing.PostOrder(ctx, accountID, "", "", []OrderedProduct{product})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := placing.UpdateOrderStatus(ctx, o.ID, StatusPaid); err != nil {
+		t.Fatal(err)
+	}
+	// nothing is watched before it's committed and published
+	select {
+	case e := <-events:
+		t.Fatalf("event %s before the relay published it", e.Type)
+	default:
+	}
+	if err := relay.publishPending(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []struct {
+		eventType EventType
+		status    Status
+	}{{EventOrderCreated, StatusPending}, {EventOrderUpdated, StatusPaid}} {
+		e := <-events
+		if e.Type != want.eventType || e.Order.ID != o.ID || e.Order.Status != want.status {
+			t.Errorf("event = %s of %s in %s, want %s of %s in %s", e.Type, e.Order.ID, e.Order.Status, want.eventType, o.ID, want.status)
+		}
+		if len(e.Order.Products) != 1 || e.Order.TotalPrice != o.TotalPrice {
+			t.Errorf("order of %s event = %+v, want %+v", e.Type, e.Order, *o)
+		}
+	}
+
+	t.Run("slow watcher", func(t *testing.T) {
+		slow, err := watching.WatchOrders(ctx, accountID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i <= watcherBuffer; i++ {
+			if _, err := placing.PostOrder(ctx, accountID, "", "", []OrderedProduct{product}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := relay.publishPending(ctx); err != nil {
+			t.Fatal(err)
+		}
+		received := 0
+		for range slow {
+			received++
+		}
+		if received != watcherBuffer {
+			t.Errorf("slow watcher got %d events before it was disconnected, want %d", received, watcherBuffer)
+		}
+	})
+}
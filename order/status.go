@@ -0,0 +1,67 @@
+package order
+
+import (
+	"errors"
+	"time"
+)
+
+var (
+	ErrInvalidStatus     = errors.New("invalid order status")
+	ErrInvalidTransition = errors.New("order status transition not allowed")
+	// ErrStatusConflict is returned when the order changed status between reading
+	// it and writing the new status.
+	ErrStatusConflict = errors.New("order status was changed concurrently")
+)
+
+// Status is the stage of the order lifecycle an order is currently in.
+type Status string
+
+const (
+	StatusPending   Status = "pending"
+	StatusPaid      Status = "paid"
+	StatusFulfilled Status = "fulfilled"
+	StatusShipped   Status = "shipped"
+	StatusDelivered Status = "delivered"
+	StatusCancelled Status = "cancelled"
+)
+
+// transitions is the order state machine.
+// Every status maps to the statuses an order is allowed to move to from it.
+// Delivered and cancelled are final.
+var transitions = map[Status][]Status{
+	StatusPending:   {StatusPaid, StatusCancelled},
+	StatusPaid:      {StatusFulfilled, StatusCancelled},
+	StatusFulfilled: {StatusShipped},
+	StatusShipped:   {StatusDelivered},
+	StatusDelivered: {},
+	StatusCancelled: {},
+}
+
+// StatusTransition is a single entry in the status history of an order.
+// From is empty for the transition that created the order.
+type StatusTransition struct {
+	OrderID   string    `json:"order_id"`
+	From      Status    `json:"from"`
+	To        Status    `json:"to"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// ParseStatus converts a string to a Status, returning ErrInvalidStatus if it
+// is not one of the known statuses.
+func ParseStatus(s string) (Status, error) {
+	status := Status(s)
+	if _, ok := transitions[status]; !ok {
+		return "", ErrInvalidStatus
+	}
+	return status, nil
+}
+
+// CanTransitionTo reports whether an order in status s may move to status to.
+func (s Status) CanTransitionTo(to Status) bool {
+	for _, next := range transitions[s] {
+		if next == to {
+			return true
+		}
+	}
+	return false
+}
@@ -2,12 +2,107 @@ CREATE TABLE IF NOT EXISTS orders (
     id CHAR(27) PRIMARY KEY,
     created_at TIMESTAMP WITH TIME ZONE NOT NULL,
     account_id CHAR(27) NOT NULL,
-    total_price MONEY NOT NULL
+    total_price MONEY NOT NULL,
+    status VARCHAR(16) NOT NULL DEFAULT 'pending',
+    -- idempotency_key is set by clients so a retried request returns the existing order
+    idempotency_key VARCHAR(64),
+    -- subtotal is the price before discounts, coupon_code and discounts are set if a coupon was applied
+    subtotal MONEY,
+    coupon_code VARCHAR(64),
+    discounts JSONB,
+    UNIQUE (account_id, idempotency_key)
 );
 
 CREATE TABLE IF NOT EXISTS order_products (
     order_id CHAR(27) REFERENCES orders (id) ON DELETE CASCADE,
     product_id CHAR(27),
+    -- name, description and price are a snapshot of the product at the time of the order
+    name VARCHAR(255) NOT NULL,
+    description TEXT NOT NULL,
+    price MONEY NOT NULL,
     quantity INT NOT NULL,
+    -- discount is the sum of the coupon discounts of the line
+    discount MONEY NOT NULL DEFAULT 0,
     PRIMARY KEY (product_id, order_id)
 );
+
+-- databases created before the status lifecycle get the column, their orders are pending
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS status VARCHAR(16) NOT NULL DEFAULT 'pending';
+
+-- the index has the name of the UNIQUE constraint above, PutOrder recognizes duplicate keys by it
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS idempotency_key VARCHAR(64);
+CREATE UNIQUE INDEX IF NOT EXISTS orders_account_id_idempotency_key_key ON orders (account_id, idempotency_key);
+
+-- lines of orders placed before the product snapshot have no product details, they read as empty with a price of 0
+ALTER TABLE order_products ADD COLUMN IF NOT EXISTS name VARCHAR(255) NOT NULL DEFAULT '';
+ALTER TABLE order_products ADD COLUMN IF NOT EXISTS description TEXT NOT NULL DEFAULT '';
+ALTER TABLE order_products ADD COLUMN IF NOT EXISTS price MONEY NOT NULL DEFAULT 0;
+
+-- orders placed before coupons existed have no subtotal, their total price is used instead
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS subtotal MONEY;
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS coupon_code VARCHAR(64);
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS discounts JSONB;
+ALTER TABLE order_products ADD COLUMN IF NOT EXISTS discount MONEY NOT NULL DEFAULT 0;
+
+-- the usage limit of a coupon counts the orders of an account that used it
+CREATE INDEX IF NOT EXISTS orders_coupon_code_idx ON orders (coupon_code, account_id) WHERE coupon_code IS NOT NULL;
+
+CREATE TABLE IF NOT EXISTS order_status_transitions (
+    id SERIAL PRIMARY KEY,
+    order_id CHAR(27) REFERENCES orders (id) ON DELETE CASCADE,
+    from_status VARCHAR(16),
+    to_status VARCHAR(16) NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL
+);
+
+-- order_outbox holds the domain events, written in the transaction of the change they describe.
+-- The relay publishes the unpublished ones in seq order and sets published_at.
+CREATE TABLE IF NOT EXISTS order_outbox (
+    seq BIGSERIAL PRIMARY KEY,
+    id CHAR(27) NOT NULL UNIQUE,
+    type VARCHAR(64) NOT NULL,
+    order_id CHAR(27) NOT NULL,
+    payload JSONB NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    published_at TIMESTAMP WITH TIME ZONE
+);
+
+CREATE INDEX IF NOT EXISTS order_outbox_unpublished_idx ON order_outbox (seq) WHERE published_at IS NULL;
+
+-- cart_items is the cart of every account, prices are looked up in the catalog when it's read
+CREATE TABLE IF NOT EXISTS cart_items (
+    account_id CHAR(27) NOT NULL,
+    product_id CHAR(27) NOT NULL,
+    quantity INT NOT NULL CHECK (quantity > 0),
+    added_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    PRIMARY KEY (account_id, product_id)
+);
+
+-- coupons are the promotions orders can apply, see order/coupon.go for the types
+CREATE TABLE IF NOT EXISTS coupons (
+    code VARCHAR(64) PRIMARY KEY,
+    type VARCHAR(16) NOT NULL,
+    -- percentage off or amount off, depending on the type
+    value DOUBLE PRECISION NOT NULL DEFAULT 0,
+    -- the offer of buy_x_get_y coupons
+    product_id CHAR(27),
+    buy_quantity INT NOT NULL DEFAULT 0,
+    get_quantity INT NOT NULL DEFAULT 0,
+    min_spend MONEY NOT NULL DEFAULT 0,
+    -- NULL leaves that side of the validity window open, valid_until is exclusive
+    valid_from TIMESTAMP WITH TIME ZONE,
+    valid_until TIMESTAMP WITH TIME ZONE,
+    -- 0 for no limit
+    max_uses_per_account INT NOT NULL DEFAULT 0,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL
+);
+
+-- stock_reservations is the stock taken for orders that aren't saved yet. PutOrder deletes the row
+-- in the transaction that saves the order, rows left behind are released by the reconciler.
+CREATE TABLE IF NOT EXISTS stock_reservations (
+    order_id CHAR(27) PRIMARY KEY,
+    products JSONB NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS stock_reservations_created_at_idx ON stock_reservations (created_at);
@@ -1,10 +1,16 @@
 // Function to send GraphQL requests
 function sendGraphQLRequest(query, targetElementId) {
+  const headers = {
+    'Content-Type': 'application/json',
+  };
+  // Send the access token if there is one, the gateway checks it for protected fields
+  const accessToken = document.getElementById('access-token').value;
+  if (accessToken) {
+    headers['Authorization'] = `Bearer ${accessToken}`;
+  }
   fetch('http://localhost:8000/graphql', {
     method: 'POST',
-    headers: {
-      'Content-Type': 'application/json',
-    },
+    headers,
     body: JSON.stringify({ query }),
   })
     .then(response => response.json())
@@ -112,9 +118,11 @@ document.getElementById('create-order-form').addEventListener('submit', function
   const accountId = document.getElementById('order-account-id').value;
   const productId = document.getElementById('order-product-id').value;
   const quantity = document.getElementById('order-quantity').value;
+  // accountId is optional, orders are placed for the logged in account by default
+  const accountField = accountId ? `accountId: "${accountId}", ` : '';
   const query = `
                 mutation {
-                    createOrder(order: {accountId: "${accountId}", products: [{id: "${productId}", quantity: ${quantity}}]}) {
+                    createOrder(order: {${accountField}products: [{id: "${productId}", quantity: ${quantity}}]}) {
                         id
                         totalPrice
                         products {